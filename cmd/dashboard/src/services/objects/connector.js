@@ -24,6 +24,10 @@ export default {
                     name : "Starrocks",
                     value : "starrocks"
                 },
+                {
+                    name : "Doris",
+                    value : "doris"
+                },
                 {
                     name : "ElasticSearch",
                     value : "elasticsearch",
@@ -64,4 +68,4 @@ export default {
             hiddenOnList: true
         },
     ]
-}
\ No newline at end of file
+}
\ No newline at end of file
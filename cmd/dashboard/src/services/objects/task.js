@@ -23,7 +23,7 @@ export default {
         {
             name : "writer",
             type : "dynamic_options",
-            option_type: "single",
+            option_type: "multiple",
             data_source : "connectors",
             hiddenOnList : true,
         },
@@ -53,7 +53,7 @@ export default {
         {
             name : "tables",
             type: "string",
-            placeholder : "table_1:table_1_alias,table_2,table_3",
+            placeholder : 'table_1:table_1_alias,orders_*,!orders_tmp_* or [{"source":"users","destination":"people","rename":{"id":"user_id"},"exclude":["password"],"types":{"age":"int"},"constants":{"region":"eu"},"computed":{"full_name":"concat(first,\' \',last)"},"filter":"region = \'EU\'","masks":{"email":"hash"}},{"source":"user_*","destination":"user","shard":"_shard"}]',
             hiddenOnList: true
         },
         {
@@ -66,8 +66,38 @@ export default {
             type : "number",
             hiddenOnList: true,
         },
+        {
+            name : "writer_policy",
+            type : "string",
+            placeholder: '{"on_conflict":"upsert","on_fail":"retry","max_retries":3,"backoff":1000,"tables":{"table_1":{"on_fail":"skip"}}}',
+            hiddenOnList: true,
+        },
+        {
+            name : "transform",
+            type : "json",
+            placeholder: "function transform(event) { return event }",
+            hiddenOnList: true,
+        },
+        {
+            name : "transform_timeout",
+            type : "number",
+            placeholder: "milliseconds a transform may run, default as 200",
+            hiddenOnList: true,
+        },
+        {
+            name : "flush_interval",
+            type : "number",
+            placeholder: "seconds between flushes of buffered events, default as 10",
+            hiddenOnList: true,
+        },
+        {
+            name : "cdc_batch_size",
+            type : "number",
+            placeholder: "cdc events applied in one batch by sql writers, 0 applies them one by one",
+            hiddenOnList: true,
+        },
         {
             name : "status",
         },
     ]
-}
\ No newline at end of file
+}
\ No newline at end of file
@@ -23,6 +23,7 @@ func init() {
 	actions = map[string]map[string]func(c *gin.Context) error{
 		"tasks": map[string]func(c *gin.Context) error{
 			ActionBeforeCreate: beforeCreateTask,
+			ActionBeforeUpdate: beforeUpdateTask,
 			ActionBeforeDelete: beforeDeleteTask,
 			ActionAfterUpdate:  afterUpdateTask,
 		},
@@ -46,15 +47,37 @@ func beforeCreateTask(c *gin.Context) error {
 	if m.Reader == "" {
 		return errors.New("empty reader")
 	}
-	if m.Writer == "" {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	writers, err := m.GetWriters()
+	if err != nil {
+		return err
+	}
+	if len(writers) < 1 {
 		return errors.New("empty writer")
 	}
-	if m.Reader == m.Writer {
-		return errors.New("writer and reader cannot be the same")
+	for _, writer := range writers {
+		if m.Reader == writer {
+			return errors.New("writer and reader cannot be the same")
+		}
 	}
 	return nil
 }
 
+// beforeUpdateTask validates the fields of the task which are updated, the others are kept.
+func beforeUpdateTask(c *gin.Context) error {
+	id := c.Param("id")
+	var m model.Task
+	if err := model.DB().Where("id = ?", id).First(&m).Error; err != nil {
+		return err
+	}
+	if err := Parse(c, &m); err != nil {
+		return err
+	}
+	return m.Validate()
+}
+
 func beforeDeleteTask(c *gin.Context) error {
 	id := c.Param("id")
 	var m model.Task
@@ -52,3 +52,38 @@ func TestConnector(g *gin.Context) {
 	}
 	Success(g, "success", true)
 }
+
+// ReindexTable copies a table of the connector into a new version behind its alias, the schema manager of
+// the plugin must support it.
+func ReindexTable(g *gin.Context) {
+	var req struct {
+		Table string `json:"table"`
+	}
+	if err := Parse(g, &req); err != nil {
+		return
+	}
+	connector, err := model.GetConnectorByID(g.Param("id"))
+	if err != nil {
+		Error(g, http.StatusNotFound, "can not find connector:"+g.Param("id"))
+		return
+	}
+	plugin, ok := core.GetPlugin(connector.Type)
+	if !ok || plugin.SchemaFactory == nil {
+		Error(g, http.StatusBadRequest, "unsupported schema protocol for plugin:"+connector.Type)
+		return
+	}
+	reindexer, ok := plugin.SchemaFactory(context.Background(), &core.SchemaOption{
+		Connector: connector,
+		Logger:    core.SysLogger,
+	}).(core.Reindexer)
+	if !ok {
+		Error(g, http.StatusBadRequest, "unsupported reindex for plugin:"+connector.Type)
+		return
+	}
+	index, err := reindexer.Reindex(req.Table)
+	if err != nil {
+		Error(g, http.StatusInternalServerError, core.SysLogger.Errorf("can not reindex %s on %s, %s", req.Table, connector.Name, err).Error())
+		return
+	}
+	Success(g, "index", index)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/imiskolee/anycdc/cmd/server/runtime"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"net/http"
+	"strconv"
+)
+
+func ListDeadLetters(ctx *gin.Context) {
+	id := ctx.Param("id")
+	offset, _ := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	letters, total, err := model.GetDeadLetters(id, offset, limit)
+	if err != nil {
+		Error(ctx, http.StatusInternalServerError, core.SysLogger.Errorf("can not get dead letters of task:%s, %s", id, err).Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"error": 0,
+		"msg":   "success",
+		"data": map[string]interface{}{
+			"dead_letters": letters,
+			"total":        total,
+		},
+	})
+}
+
+func GetDeadLetter(ctx *gin.Context) {
+	letter, ok := loadDeadLetter(ctx)
+	if !ok {
+		return
+	}
+	Success(ctx, "dead_letter", letter)
+}
+
+// ReplayDeadLetter applies the event with the writer of the task, the dead letter is removed once it's applied.
+func ReplayDeadLetter(ctx *gin.Context) {
+	letter, ok := loadDeadLetter(ctx)
+	if !ok {
+		return
+	}
+	t, running := runtime.R.Tasks[letter.TaskID]
+	if !running {
+		t = core.NewTask(letter.TaskID)
+		if err := t.Prepare(); err != nil {
+			Error(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := t.ReplayDeadLetter(letter); err != nil {
+		Error(ctx, http.StatusInternalServerError, core.SysLogger.Errorf("can not replay dead letter:%s, %s", letter.ID, err).Error())
+		return
+	}
+	if err := model.DeleteDeadLetter(letter); err != nil {
+		Error(ctx, http.StatusInternalServerError, core.SysLogger.Errorf("can not delete dead letter:%s, %s", letter.ID, err).Error())
+		return
+	}
+	Success(ctx, "success", true)
+}
+
+func DiscardDeadLetter(ctx *gin.Context) {
+	letter, ok := loadDeadLetter(ctx)
+	if !ok {
+		return
+	}
+	if err := model.DeleteDeadLetter(letter); err != nil {
+		Error(ctx, http.StatusInternalServerError, core.SysLogger.Errorf("can not delete dead letter:%s, %s", letter.ID, err).Error())
+		return
+	}
+	Success(ctx, "success", true)
+}
+
+func loadDeadLetter(ctx *gin.Context) (*model.DeadLetter, bool) {
+	id := ctx.Param("id")
+	letterID := ctx.Param("letter_id")
+	letter, err := model.GetDeadLetter(id, letterID)
+	if err != nil {
+		Error(ctx, http.StatusNotFound, core.SysLogger.Errorf("can not get dead letter:%s", letterID).Error())
+		return nil, false
+	}
+	return letter, true
+}
@@ -94,10 +94,17 @@ func Start() {
 	server.PUT("/api/tasks/:id/stop", StopTask)
 	server.GET("/api/tasks/:id/logs", GetTaskLog)
 	server.GET("/api/tasks/:id/table_logs", GetTaskTableLogs)
+	server.GET("/api/tasks/:id/state", GetTaskState)
+	server.GET("/api/tasks/:id/dead_letters", ListDeadLetters)
+	server.GET("/api/tasks/:id/dead_letters/:letter_id", GetDeadLetter)
+	server.POST("/api/tasks/:id/dead_letters/:letter_id/replay", ReplayDeadLetter)
+	server.DELETE("/api/tasks/:id/dead_letters/:letter_id", DiscardDeadLetter)
 	server.PUT("/api/tasks/:id/rotate", TaskRotateTo)
 	server.PUT("/api/task_tables/:id/resync", TaskTableResync)
+	server.POST("/api/connectors/:id/reindex", ReindexTable)
 	server.POST("/api/utils/test_connector", TestConnector)
 	server.POST("/api/utils/log_tail", LogTailHandler)
+	server.POST("/api/utils/test_transform", TestTransform)
 
 	core.SysLogger.Info("Starting API Server:%s", config.G.Admin.Listen)
 	if err := server.Run(config.G.Admin.Listen); err != nil {
@@ -89,6 +89,45 @@ func GetTaskLog(ctx *gin.Context) {
 	Success(ctx, "log", string(data))
 }
 
+// GetTaskState returns the fail policy state of a running task, or the configured policies of a stopped task.
+func GetTaskState(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if t, ok := runtime.R.Tasks[id]; ok {
+		Success(ctx, "state", t.State())
+		return
+	}
+	var task model.Task
+	if err := model.DB().Where("id = ?", id).Last(&task).Error; err != nil {
+		Error(ctx, http.StatusBadRequest, core.SysLogger.Errorf("can not get task:%s", id).Error())
+		return
+	}
+	policy, err := task.ParseWriterPolicy()
+	if err != nil {
+		Error(ctx, http.StatusBadRequest, core.SysLogger.Errorf("can not parse writer policy of task %s:%s", id, err).Error())
+		return
+	}
+	writers, err := task.GetWriters()
+	if err != nil {
+		Error(ctx, http.StatusBadRequest, core.SysLogger.Errorf("can not parse writers of task %s:%s", id, err).Error())
+		return
+	}
+	state := core.TaskState{
+		TaskID: id,
+		Policy: policy,
+	}
+	for _, writer := range writers {
+		ws := core.WriterState{Writer: writer, Position: task.LastCDCPosition}
+		for _, table := range task.GetTables() {
+			ws.Tables = append(ws.Tables, core.TablePolicyState{
+				Table:  table.SourceTable,
+				Policy: state.Policy.TableFailPolicy(table.SourceTable),
+			})
+		}
+		state.Writers = append(state.Writers, ws)
+	}
+	Success(ctx, "state", state)
+}
+
 func TaskRotateTo(ctx *gin.Context) {
 	id := ctx.Param("id")
 	var body struct {
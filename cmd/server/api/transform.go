@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"net/http"
+	"time"
+)
+
+// TestTransform runs a transform script on a sample event, so scripts can be checked before they are saved on a task.
+func TestTransform(ctx *gin.Context) {
+	var body struct {
+		Script  string               `json:"script"`
+		Timeout int                  `json:"timeout"`
+		Event   core.TransformSample `json:"event"`
+	}
+	if err := Parse(ctx, &body); err != nil {
+		return
+	}
+	transformer, err := core.NewTransformer(body.Script, time.Duration(body.Timeout)*time.Millisecond)
+	if err != nil {
+		Error(ctx, http.StatusBadRequest, core.SysLogger.Errorf("can not compile transform:%s", err).Error())
+		return
+	}
+	events, err := transformer.TransformSample(body.Event)
+	if err != nil {
+		Error(ctx, http.StatusBadRequest, core.SysLogger.Errorf("can not transform event:%s", err).Error())
+		return
+	}
+	Success(ctx, "events", events)
+}
@@ -35,11 +35,20 @@ type Admin struct {
 	Auth     Auth     `yaml:"auth"`
 }
 
+// Masking holds the secrets of column masks. EncryptionKey is a hex or base64 encoded AES key
+// of 16, 24 or 32 bytes.
+type Masking struct {
+	Salt          string `yaml:"salt"`
+	TokenKey      string `yaml:"token_key"`
+	EncryptionKey string `yaml:"encryption_key"`
+}
+
 type Config struct {
-	DataDir  string `yaml:"data_dir"`
-	Tester   bool   `yaml:"tester"`
-	LogLevel string `yaml:"log_level;default=info"`
-	Admin    Admin  `yaml:"admin"`
+	DataDir  string  `yaml:"data_dir"`
+	Tester   bool    `yaml:"tester"`
+	LogLevel string  `yaml:"log_level;default=info"`
+	Admin    Admin   `yaml:"admin"`
+	Masking  Masking `yaml:"masking"`
 }
 
 func Parse(path string) {
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"sync"
@@ -49,3 +50,12 @@ func (s *CachedSchemaManager) Get(dbName string, tableName string) *schemas.Tabl
 func (s *CachedSchemaManager) CreateTable(table *schemas.Table) error {
 	return s.factory.CreateTable(table)
 }
+
+// ListTables lists the tables of the factory, the list is never cached so new tables are found.
+func (s *CachedSchemaManager) ListTables(dbName string) ([]string, error) {
+	lister, ok := s.factory.(TableLister)
+	if !ok {
+		return nil, errors.New("schema manager can not list tables")
+	}
+	return lister.ListTables(dbName)
+}
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"strconv"
+	"time"
+)
+
+var eventTypeNames = map[EventType]string{
+	EventTypeInsert: "insert",
+	EventTypeUpdate: "update",
+	EventTypeDelete: "delete",
+}
+
+// deadLetterField is a serialized EventField, values are decoded by their type when replayed.
+type deadLetterField struct {
+	Name  string       `json:"name"`
+	Type  schemas.Type `json:"type"`
+	Value interface{}  `json:"value"`
+}
+
+// deadLetters stores events which can not be applied by a writer, or transformed when writer is empty.
+// They are logged only when they can not be stored.
+func (s *Task) deadLetters(writer string, events []Event, err error, attempts int) {
+	letters := make([]model.DeadLetter, 0, len(events))
+	for _, e := range events {
+		letter, encodeErr := newDeadLetter(s.id, writer, e, err, attempts)
+		if encodeErr != nil {
+			s.logger.Error("can not encode dead letter of table %s: %s", e.SourceSchema.Name, encodeErr)
+			continue
+		}
+		letters = append(letters, *letter)
+	}
+	if len(letters) < 1 {
+		return
+	}
+	if err := model.CreateDeadLetters(letters); err != nil {
+		s.logger.Error("can not save %d dead letters: %s", len(letters), err)
+	}
+}
+
+func newDeadLetter(taskID string, writer string, e Event, err error, attempts int) (*model.DeadLetter, error) {
+	sch, encodeErr := json.Marshal(e.SourceSchema)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	record, encodeErr := encodeRecord(e.Record)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	letter := &model.DeadLetter{
+		TaskID:           taskID,
+		Writer:           writer,
+		Table:            e.SourceSchema.Name,
+		DestinationTable: e.DestinationTableName,
+		Operation:        eventTypeNames[e.Type],
+		Schema:           string(sch),
+		Record:           record,
+		Position:         e.LastPOS,
+		Error:            err.Error(),
+		Attempts:         attempts,
+	}
+	letter.ID = uuid.New().String()
+	if e.OldRecord != nil {
+		if letter.OldRecord, encodeErr = encodeRecord(*e.OldRecord); encodeErr != nil {
+			return nil, encodeErr
+		}
+	}
+	return letter, nil
+}
+
+// DeadLetterEvent decodes the event of a dead letter.
+func DeadLetterEvent(letter *model.DeadLetter) (Event, error) {
+	var e Event
+	for t, name := range eventTypeNames {
+		if name == letter.Operation {
+			e.Type = t
+		}
+	}
+	if e.Type == EventTypeUnknown {
+		return e, errors.New("unknown operation " + letter.Operation)
+	}
+	if err := json.Unmarshal([]byte(letter.Schema), &e.SourceSchema); err != nil {
+		return e, err
+	}
+	record, err := decodeRecord(letter.Record)
+	if err != nil {
+		return e, err
+	}
+	e.Record = record
+	if letter.OldRecord != "" {
+		old, err := decodeRecord(letter.OldRecord)
+		if err != nil {
+			return e, err
+		}
+		e.OldRecord = &old
+	}
+	e.DestinationTableName = letter.DestinationTable
+	e.LastPOS = letter.Position
+	return e, nil
+}
+
+// ReplayDeadLetter applies the event of a dead letter with the writer it failed on. Letters without
+// a writer failed before the writers, they are mapped and transformed again and applied on every writer.
+// Writers are created when the task is not running.
+func (s *Task) ReplayDeadLetter(letter *model.DeadLetter) error {
+	e, err := DeadLetterEvent(letter)
+	if err != nil {
+		return err
+	}
+	if letter.Writer != "" {
+		return s.replayEvent(letter.Writer, e)
+	}
+	events, err := s.transformEvent(e)
+	if err != nil {
+		return err
+	}
+	for _, c := range s.state.Writers {
+		for _, ev := range events {
+			if err := s.replayEvent(c.ID, ev); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayEvent applies an event with a writer of the task. Events replayed on a running task are applied under
+// the flush lock of its writer, so the flusher does not commit or handle its batches at the same time.
+func (s *Task) replayEvent(writerID string, e Event) error {
+	for _, w := range s.writers {
+		if w.connector.ID == writerID {
+			return s.replayOnWriter(w, e)
+		}
+	}
+	var connector *model.Connector
+	for _, c := range s.state.Writers {
+		if c.ID == writerID {
+			connector = c
+			break
+		}
+	}
+	if connector == nil {
+		return s.logger.Errorf("can not find writer %s", writerID)
+	}
+	writerPlugin, ok := GetPlugin(connector.Type)
+	if !ok || writerPlugin.WriterFactory == nil {
+		return s.logger.Errorf("plugin %s have not supports writer protocol", connector.Type)
+	}
+	writer := writerPlugin.WriterFactory(s.ctx, &WriterOption{
+		Connector: connector,
+		Logger:    s.logger,
+		Task:      s.state.Task,
+	})
+	if err := writer.Prepare(); err != nil {
+		return s.logger.Errorf("can not prepare writer: %s", err)
+	}
+	if releaser, ok := writer.(WriterReleaser); ok {
+		defer (func() {
+			if err := releaser.Release(); err != nil {
+				s.logger.Error("can not release writer %s: %s", connector.Name, err)
+			}
+		})()
+	}
+	if err := writer.Execute(e); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (s *Task) replayOnWriter(w *taskWriter, e Event) error {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+	var failed *BatchError
+	if err := w.writer.Execute(e); errors.As(err, &failed) {
+		// the event is buffered, the batch which failed is handled with the policy of its own table.
+		s.handleBatchError(w, failed)
+	} else if err != nil {
+		return err
+	}
+	return s.flushBuffered(w)
+}
+
+func encodeRecord(record EventRecord) (string, error) {
+	fields := make([]deadLetterField, len(record.Columns))
+	for i, col := range record.Columns {
+		fields[i] = deadLetterField{
+			Name:  col.Name,
+			Type:  col.Value.T,
+			Value: col.Value.V,
+		}
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+func decodeRecord(data string) (EventRecord, error) {
+	var record EventRecord
+	var fields []deadLetterField
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return record, err
+	}
+	for _, f := range fields {
+		v, err := decodeValue(f.Type, f.Value)
+		if err != nil {
+			return record, err
+		}
+		if v == nil {
+			record.Set(f.Name, types.NewNullData())
+			continue
+		}
+		record.Set(f.Name, types.NewTypedData(f.Type, v))
+	}
+	return record, nil
+}
+
+// decodeValue restores the go type of a value after the json round trip.
+func decodeValue(t schemas.Type, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	n, isNumber := v.(json.Number)
+	s, isString := v.(string)
+	switch t {
+	case schemas.TypeInt:
+		if isNumber {
+			return strconv.ParseInt(n.String(), 10, 64)
+		}
+	case schemas.TypeUint:
+		if isNumber {
+			return strconv.ParseUint(n.String(), 10, 64)
+		}
+	case schemas.TypeDecimal:
+		// decimals are kept as their text, a float64 would round them.
+		if isNumber {
+			return n.String(), nil
+		}
+	case schemas.TypeDate, schemas.TypeTimestamp:
+		if isString {
+			if tm, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return tm, nil
+			}
+		}
+	case schemas.TypeBlob:
+		if isString {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case schemas.TypeJSON:
+		return normalizeJSONNumbers(v), nil
+	}
+	if isNumber {
+		return n.String(), nil
+	}
+	return v, nil
+}
+
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeJSONNumbers(item)
+		}
+	}
+	return v
+}
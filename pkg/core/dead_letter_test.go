@@ -0,0 +1,53 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterRecord(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 123000000, time.UTC)
+	cases := []struct {
+		column string
+		value  types.TypedData
+		want   interface{}
+	}{
+		{"id", types.NewTypedData(schemas.TypeInt, int64(9007199254740993)), int64(9007199254740993)},
+		{"counter", types.NewTypedData(schemas.TypeUint, uint64(18446744073709551615)), uint64(18446744073709551615)},
+		{"price", types.NewTypedData(schemas.TypeDecimal, "12345678901234567.890123"), "12345678901234567.890123"},
+		{"ratio", types.NewTypedData(schemas.TypeDecimal, 0.1), "0.1"},
+		{"created_at", types.NewTypedData(schemas.TypeTimestamp, at), at},
+		{"data", types.NewTypedData(schemas.TypeBlob, []byte{0, 1, 2}), []byte{0, 1, 2}},
+		{"name", types.NewTypedData(schemas.TypeString, "Ada"), "Ada"},
+		{"payload", types.NewTypedData(schemas.TypeJSON, map[string]interface{}{"n": int64(1)}), map[string]interface{}{"n": int64(1)}},
+	}
+	var record EventRecord
+	for _, c := range cases {
+		record.Set(c.column, c.value)
+	}
+	record.Set("deleted_at", types.NewNullData())
+	encoded, err := encodeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeRecord(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		field, err := decoded.FieldByName(c.column)
+		if err != nil {
+			t.Errorf("column %s is missing", c.column)
+			continue
+		}
+		if field.Value.T != c.value.T || !reflect.DeepEqual(field.Value.V, c.want) {
+			t.Errorf("column %s = %v %#v, want %v %#v", c.column, field.Value.T, field.Value.V, c.value.T, c.want)
+		}
+	}
+	if field, err := decoded.FieldByName("deleted_at"); err != nil || field.Value.V != nil {
+		t.Errorf("deleted_at should be null")
+	}
+}
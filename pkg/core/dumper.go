@@ -9,6 +9,12 @@ type DumperSubscriber interface {
 	DumperEvent(sch *schemas.Table, records []EventRecord) error
 }
 
+// DumperKeySubscriber is implemented by subscribers which keep the key a dumper resumes from. Dumpers
+// which can not resume from the primary keys of the last dumped record set their own key after a batch.
+type DumperKeySubscriber interface {
+	DumperKey(table string, key interface{})
+}
+
 type DumperOption struct {
 	Connector  *model.Connector
 	Task       *model.Task
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/core/types"
+	"time"
 )
 
 type EventType int
@@ -70,4 +71,5 @@ type Event struct {
 	SourceSchema         schemas.Table
 	DestinationTableName string
 	LastPOS              string
+	CommitTime           time.Time // commit time on the source, zero when it's unknown
 }
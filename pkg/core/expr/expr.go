@@ -0,0 +1,543 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Env resolves the columns an expression refers to, ok is false for unknown columns which evaluate to null.
+type Env func(name string) (value interface{}, ok bool)
+
+// Expr is a parsed expression: literals ('text', 1, 1.5, true, false, null), column names,
+// function calls such as concat(first, ' ', last), comparisons (= != <> < <= > >=, like, in,
+// is [not] null) and and, or, not. Comparisons with null are null, as in SQL.
+type Expr struct {
+	src     string
+	root    node
+	columns []string
+}
+
+type node interface {
+	eval(env Env) (interface{}, error)
+	sql(w *sqlWriter)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type column struct {
+	name string
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n literal) eval(env Env) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n column) eval(env Env) (interface{}, error) {
+	v, _ := env(n.name)
+	return v, nil
+}
+
+func (n call) eval(env Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+func Parse(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Expr{src: src, root: root, columns: p.columns}, nil
+}
+
+// Match evaluates the expression as a condition, null is not a match.
+func (e *Expr) Match(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, _ := v.(bool)
+	return b, nil
+}
+
+// Columns returns the columns the expression refers to.
+func (e *Expr) Columns() []string {
+	return e.columns
+}
+
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type parser struct {
+	src     string
+	pos     int
+	tok     token
+	columns []string
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expression %q at %d: %s", p.src, p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) next() error {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokenEOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '\'' || c == '"':
+		var sb strings.Builder
+		p.pos++
+		for {
+			if p.pos >= len(p.src) {
+				p.tok = token{pos: start}
+				return p.errorf("unterminated string")
+			}
+			if p.src[p.pos] == c {
+				// a doubled quote is an escaped quote, as in SQL.
+				if p.pos+1 < len(p.src) && p.src[p.pos+1] == c {
+					sb.WriteByte(c)
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				break
+			}
+			sb.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		p.tok = token{kind: tokenString, text: sb.String(), pos: start}
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokenNumber, text: p.src[start:p.pos], pos: start}
+	case c == '_' || c == '`' || unicode.IsLetter(rune(c)):
+		if c == '`' {
+			end := strings.IndexByte(p.src[start+1:], '`')
+			if end < 0 {
+				p.tok = token{pos: start}
+				return p.errorf("unterminated identifier")
+			}
+			p.pos = start + end + 2
+			p.tok = token{kind: tokenIdent, text: p.src[start+1 : start+1+end], pos: start}
+			return nil
+		}
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == '.' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokenIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		if p.pos < len(p.src) {
+			switch op := p.src[start : p.pos+1]; op {
+			case "<=", ">=", "<>", "!=", "==":
+				p.pos++
+				p.tok = token{kind: tokenPunct, text: op, pos: start}
+				return nil
+			}
+		}
+		p.tok = token{kind: tokenPunct, text: string(c), pos: start}
+	}
+	return nil
+}
+
+func (p *parser) isPunct(text string) bool {
+	return p.tok.kind == tokenPunct && p.tok.text == text
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	return p.tok.kind == tokenIdent && strings.EqualFold(p.tok.text, keyword)
+}
+
+func (p *parser) expect(text string) error {
+	if p.tok.kind != tokenPunct || p.tok.text != text {
+		return p.errorf("expected %q, got %s", text, p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOperators = map[string]string{
+	"=": "=", "==": "=", "!=": "<>", "<>": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenPunct {
+		op, ok := comparisonOperators[p.tok.text]
+		if !ok {
+			return left, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return comparison{op: op, left: left, right: right}, nil
+	}
+	if p.isKeyword("is") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		negated := p.isKeyword("not")
+		if negated {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.isKeyword("null") {
+			return nil, p.errorf("expected null, got %s", p.tok)
+		}
+		return isNull{x: left, negated: negated}, p.next()
+	}
+	negated := p.isKeyword("not")
+	if negated {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("in") && !p.isKeyword("like") {
+			return nil, p.errorf("expected in or like, got %s", p.tok)
+		}
+	}
+	switch {
+	case p.isKeyword("like"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		pattern, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return like{x: left, pattern: pattern, negated: negated}, nil
+	case p.isKeyword("in"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		n := in{x: left, negated: negated}
+		for {
+			item, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, item)
+			if !p.isPunct(",") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		return n, p.expect(")")
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenString:
+		return literal{tok.text}, p.next()
+	case tokenNumber:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if i, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return literal{i}, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		return literal{f}, nil
+	case tokenIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenPunct && p.tok.text == "(" {
+			return p.parseCall(tok)
+		}
+		switch strings.ToLower(tok.text) {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		p.columns = append(p.columns, tok.text)
+		return column{tok.text}, nil
+	case tokenPunct:
+		if tok.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name.text)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	c := call{name: strings.ToLower(name.text), fn: fn}
+	if p.tok.kind == tokenPunct && p.tok.text == ")" {
+		return c, p.next()
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if p.tok.kind == tokenPunct && p.tok.text == "," {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return c, p.expect(")")
+	}
+}
+
+type function func(args []interface{}) (interface{}, error)
+
+var functions = map[string]function{
+	"concat": func(args []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			if arg != nil {
+				sb.WriteString(toString(arg))
+			}
+		}
+		return sb.String(), nil
+	},
+	"upper": stringFunction(strings.ToUpper),
+	"lower": stringFunction(strings.ToLower),
+	"trim":  stringFunction(strings.TrimSpace),
+	"coalesce": func(args []interface{}) (interface{}, error) {
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	},
+	"substr": func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 || len(args) > 3 {
+			return nil, errors.New("expects 2 or 3 arguments")
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		s := []rune(toString(args[0]))
+		start, ok := toInt(args[1])
+		if !ok {
+			return nil, errors.New("start is not a number")
+		}
+		// positions start at 1, as in SQL.
+		start--
+		if start < 0 {
+			start = 0
+		}
+		if start > int64(len(s)) {
+			start = int64(len(s))
+		}
+		end := int64(len(s))
+		if len(args) == 3 {
+			n, ok := toInt(args[2])
+			if !ok {
+				return nil, errors.New("length is not a number")
+			}
+			if start+n < end {
+				end = start + n
+			}
+		}
+		if end < start {
+			end = start
+		}
+		return string(s[start:end]), nil
+	},
+	"now": func(args []interface{}) (interface{}, error) {
+		return time.Now(), nil
+	},
+}
+
+func stringFunction(fn func(string) string) function {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("expects 1 argument")
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return fn(toString(args[0])), nil
+	}
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
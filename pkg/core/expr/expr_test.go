@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testRow = map[string]interface{}{
+	"first":  "Ada",
+	"last":   "Lovelace",
+	"name":   "  Ada  ",
+	"age":    int64(36),
+	"region": "EU",
+	"empty":  nil,
+}
+
+func testEnv(name string) (interface{}, bool) {
+	v, ok := testRow[name]
+	return v, ok
+}
+
+func mustEval(t *testing.T, src string) interface{} {
+	t.Helper()
+	e, err := Parse(src)
+	if err != nil {
+		t.Fatalf("%s: %s", src, err)
+	}
+	v, err := e.Eval(testEnv)
+	if err != nil {
+		t.Fatalf("%s: %s", src, err)
+	}
+	return v
+}
+
+func TestEvalFunctions(t *testing.T) {
+	cases := []struct {
+		src  string
+		want interface{}
+	}{
+		{"upper(first)", "ADA"},
+		{"lower(region)", "eu"},
+		{"trim(name)", "Ada"},
+		{"upper(empty)", nil},
+		{"substr(last, 1, 4)", "Love"},
+		{"substr(last, 5)", "lace"},
+		{"substr(last, 0, 2)", "Lo"},
+		{"substr(last, 20)", ""},
+		{"substr(empty, 1)", nil},
+		{"coalesce(empty, first)", "Ada"},
+		{"coalesce(empty, null)", nil},
+		{"concat(first, ' ', last)", "Ada Lovelace"},
+		{"concat(first, empty, age)", "Ada36"},
+		{"CONCAT(upper(first), '-', lower(last))", "ADA-lovelace"},
+		{"first", "Ada"},
+		{"unknown", nil},
+		{"'it''s'", "it's"},
+		{"`first`", "Ada"},
+		{"1.5", 1.5},
+		{"42", int64(42)},
+		{"true", true},
+		{"null", nil},
+	}
+	for _, c := range cases {
+		if got := mustEval(t, c.src); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s = %#v, want %#v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestEvalNow(t *testing.T) {
+	before := time.Now()
+	v, ok := mustEval(t, "now()").(time.Time)
+	if !ok {
+		t.Fatal("now() should be a time")
+	}
+	if v.Before(before) || v.After(time.Now()) {
+		t.Fatalf("now() = %s is not the current time", v)
+	}
+}
+
+func TestEvalFunctionErrors(t *testing.T) {
+	for _, src := range []string{"upper(first, last)", "substr(first)", "substr(first, 'a')", "substr(first, 1, 'b')"} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+		if _, err := e.Eval(testEnv); err == nil {
+			t.Errorf("%s should fail", src)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	e, err := Parse("concat(first, ' ', last, coalesce(empty, 'x'))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "last", "empty"}; !reflect.DeepEqual(e.Columns(), want) {
+		t.Fatalf("columns = %v, want %v", e.Columns(), want)
+	}
+}
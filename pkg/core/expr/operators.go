@@ -0,0 +1,314 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type logical struct {
+	op    string
+	left  node
+	right node
+}
+
+type not struct {
+	x node
+}
+
+type comparison struct {
+	op    string
+	left  node
+	right node
+}
+
+type isNull struct {
+	x       node
+	negated bool
+}
+
+type in struct {
+	x       node
+	list    []node
+	negated bool
+}
+
+type like struct {
+	x       node
+	pattern node
+	negated bool
+}
+
+func (n logical) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	l, lok := left.(bool)
+	// short circuit, the result does not depend on the right side.
+	if lok && l == (n.op == "OR") {
+		return l, nil
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, rok := right.(bool)
+	if rok && r == (n.op == "OR") {
+		return r, nil
+	}
+	if !lok || !rok {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func (n not) eval(env Env) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, nil
+	}
+	return !b, nil
+}
+
+func (n comparison) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	c, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func (n isNull) eval(env Env) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != n.negated, nil
+}
+
+func (n in) eval(env Env) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	unknown := false
+	for _, item := range n.list {
+		iv, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if iv == nil {
+			unknown = true
+			continue
+		}
+		c, err := compare(v, iv)
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			return !n.negated, nil
+		}
+	}
+	if unknown {
+		return nil, nil
+	}
+	return n.negated, nil
+}
+
+func (n like) eval(env Env) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := n.pattern.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || pattern == nil {
+		return nil, nil
+	}
+	re, err := likePattern(toString(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(toString(v)) != n.negated, nil
+}
+
+// likePattern converts a like pattern into a regular expression, % matches any characters and _ one.
+func likePattern(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// compare orders two non null values. Numbers compare with numbers and numeric strings, booleans
+// with booleans and 0 or 1, times with times and strings in RFC 3339 or SQL format, other values as strings.
+func compare(a interface{}, b interface{}) (int, error) {
+	if t, ok := a.(time.Time); ok {
+		return compareTime(t, b)
+	}
+	if t, ok := b.(time.Time); ok {
+		c, err := compareTime(t, a)
+		return -c, err
+	}
+	ab, aIsBool := a.(bool)
+	bb, bIsBool := b.(bool)
+	if aIsBool && bIsBool {
+		return compareFloat(boolNumber(ab), boolNumber(bb)), nil
+	}
+	if aIsBool {
+		a = boolNumber(ab)
+	}
+	if bIsBool {
+		b = boolNumber(bb)
+	}
+	af, aIsNumber := toFloat(a)
+	bf, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		if isNumber(a) || isNumber(b) {
+			return compareFloat(af, bf), nil
+		}
+	}
+	return strings.Compare(toString(a), toString(b)), nil
+}
+
+func compareTime(t time.Time, v interface{}) (int, error) {
+	var other time.Time
+	switch v := v.(type) {
+	case time.Time:
+		other = v
+	case string:
+		parsed, err := parseTime(v)
+		if err != nil {
+			return 0, err
+		}
+		other = parsed
+	default:
+		return 0, fmt.Errorf("can not compare time with %T", v)
+	}
+	switch {
+	case t.Before(other):
+		return -1, nil
+	case t.After(other):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02", "15:04:05"}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func compareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"age >",
+		"age = 1 and",
+		"(age = 1",
+		"age is 1",
+		"age not between 1",
+		"region in 'EU'",
+		"region in ('EU'",
+		"'unterminated",
+		"nope(age)",
+		"age = 1 )",
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%q should not parse", src)
+		}
+	}
+}
+
+func TestEvalOperators(t *testing.T) {
+	cases := []struct {
+		src  string
+		want interface{}
+	}{
+		// and binds tighter than or, not tighter than and.
+		{"age = 1 or age = 36 and region = 'EU'", true},
+		{"age = 36 or age = 1 and region = 'US'", true},
+		{"(age = 36 or age = 1) and region = 'US'", false},
+		{"not age = 1 and region = 'EU'", true},
+		{"not (age = 36 and region = 'US')", true},
+		{"age >= 36", true},
+		{"age > 36", false},
+		{"age < '40'", true},
+		{"age <> 36", false},
+		{"age != 1", true},
+		{"age == 36.0", true},
+		{"first < 'B'", true},
+		// null is unknown, and or or only decide when a side does.
+		{"empty = 1", nil},
+		{"empty <> 1", nil},
+		{"not empty = 1", nil},
+		{"empty = 1 and age = 1", false},
+		{"empty = 1 and age = 36", nil},
+		{"empty = 1 or age = 36", true},
+		{"empty = 1 or age = 1", nil},
+		{"empty is null", true},
+		{"empty is not null", false},
+		{"first is null", false},
+		{"unknown is null", true},
+		{"region in ('US', 'EU')", true},
+		{"region not in ('US', 'EU')", false},
+		{"region in ('US', null)", nil},
+		{"region in ('EU', null)", true},
+		{"empty in ('EU')", nil},
+		{"age in (1, 36)", true},
+		{"first like 'A%'", true},
+		{"first like 'a%'", false},
+		{"first like 'A_a'", true},
+		{"first like 'A_'", false},
+		{"first not like '%z%'", true},
+		{"last like 'L.%'", false},
+		{"empty like '%'", nil},
+	}
+	for _, c := range cases {
+		if got := mustEval(t, c.src); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s = %#v, want %#v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{"age = 36", true},
+		{"age = 1", false},
+		{"empty = 1", false},
+		{"not empty = 1", false},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.src)
+		if err != nil {
+			t.Fatalf("%s: %s", c.src, err)
+		}
+		got, err := e.Match(testEnv)
+		if err != nil {
+			t.Fatalf("%s: %s", c.src, err)
+		}
+		if got != c.want {
+			t.Errorf("%s matched %v, want %v", c.src, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package expr
+
+import "strings"
+
+// SQLDialect renders the parts of an expression which differ between databases.
+type SQLDialect struct {
+	// Quote quotes a column name.
+	Quote func(name string) string
+	// Bind adds a value to the arguments of the statement and returns its placeholder.
+	Bind func(v interface{}) string
+}
+
+type sqlWriter struct {
+	sb      strings.Builder
+	dialect SQLDialect
+}
+
+// SQL renders the conditions of the expression which a database evaluates at least as broadly as Eval, as a
+// condition of a WHERE clause, literals are bound as arguments. ok is false when no condition is rendered.
+//
+// Rows read with the condition are still filtered with Eval, so it only has to keep every row Eval matches.
+// Functions such as now() or upper(), negations and ordering comparisons of non numbers depend on the clock,
+// collations and casts of the database, conditions which use them are left out.
+func (e *Expr) SQL(dialect SQLDialect) (string, bool) {
+	var conditions []string
+	for _, n := range conjuncts(e.root) {
+		if !pushable(n) {
+			continue
+		}
+		w := &sqlWriter{dialect: dialect}
+		n.sql(w)
+		conditions = append(conditions, w.sb.String())
+	}
+	if len(conditions) == 0 {
+		return "", false
+	}
+	return strings.Join(conditions, " AND "), true
+}
+
+// conjuncts splits an expression into the conditions which are joined by and.
+func conjuncts(n node) []node {
+	if l, ok := n.(logical); ok && l.op == "AND" {
+		return append(conjuncts(l.left), conjuncts(l.right)...)
+	}
+	return []node{n}
+}
+
+// pushable reports whether a database keeps every row Eval matches with the condition. Equality is at least
+// as broad with case insensitive or padded collations, other comparisons only agree on numbers.
+func pushable(n node) bool {
+	switch n := n.(type) {
+	case logical:
+		return pushable(n.left) && pushable(n.right)
+	case comparison:
+		if !isOperand(n.left) || !isOperand(n.right) {
+			return false
+		}
+		return n.op == "=" || isNumberLiteral(n.left) || isNumberLiteral(n.right)
+	case isNull:
+		return isOperand(n.x)
+	case in:
+		if n.negated || !isOperand(n.x) {
+			return false
+		}
+		for _, item := range n.list {
+			if !isOperand(item) {
+				return false
+			}
+		}
+		return true
+	case like:
+		if n.negated || !isOperand(n.x) {
+			return false
+		}
+		// backslashes escape wildcards in mysql patterns, they are plain characters for Eval.
+		pattern, ok := n.pattern.(literal)
+		s, isString := pattern.value.(string)
+		return ok && isString && !strings.Contains(s, `\`)
+	}
+	return false
+}
+
+func isOperand(n node) bool {
+	switch n.(type) {
+	case column, literal:
+		return true
+	}
+	return false
+}
+
+func isNumberLiteral(n node) bool {
+	l, ok := n.(literal)
+	return ok && isNumber(l.value)
+}
+
+func (n literal) sql(w *sqlWriter) {
+	if n.value == nil {
+		w.sb.WriteString("NULL")
+		return
+	}
+	w.sb.WriteString(w.dialect.Bind(n.value))
+}
+
+func (n column) sql(w *sqlWriter) {
+	w.sb.WriteString(w.dialect.Quote(n.name))
+}
+
+func (n call) sql(w *sqlWriter) {
+	w.sb.WriteString(strings.ToUpper(n.name))
+	w.sb.WriteString("(")
+	for i, arg := range n.args {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		arg.sql(w)
+	}
+	w.sb.WriteString(")")
+}
+
+func (n logical) sql(w *sqlWriter) {
+	w.sb.WriteString("(")
+	n.left.sql(w)
+	w.sb.WriteString(" " + n.op + " ")
+	n.right.sql(w)
+	w.sb.WriteString(")")
+}
+
+func (n not) sql(w *sqlWriter) {
+	w.sb.WriteString("(NOT ")
+	n.x.sql(w)
+	w.sb.WriteString(")")
+}
+
+func (n comparison) sql(w *sqlWriter) {
+	w.sb.WriteString("(")
+	n.left.sql(w)
+	w.sb.WriteString(" " + n.op + " ")
+	n.right.sql(w)
+	w.sb.WriteString(")")
+}
+
+func (n isNull) sql(w *sqlWriter) {
+	w.sb.WriteString("(")
+	n.x.sql(w)
+	if n.negated {
+		w.sb.WriteString(" IS NOT NULL)")
+		return
+	}
+	w.sb.WriteString(" IS NULL)")
+}
+
+func (n in) sql(w *sqlWriter) {
+	w.sb.WriteString("(")
+	n.x.sql(w)
+	if n.negated {
+		w.sb.WriteString(" NOT")
+	}
+	w.sb.WriteString(" IN (")
+	for i, item := range n.list {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		item.sql(w)
+	}
+	w.sb.WriteString("))")
+}
+
+func (n like) sql(w *sqlWriter) {
+	w.sb.WriteString("(")
+	n.x.sql(w)
+	if n.negated {
+		w.sb.WriteString(" NOT")
+	}
+	w.sb.WriteString(" LIKE ")
+	n.pattern.sql(w)
+	w.sb.WriteString(")")
+}
@@ -0,0 +1,60 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testDialect(postgres bool, args *[]interface{}) SQLDialect {
+	return SQLDialect{
+		Quote: func(name string) string {
+			return `"` + name + `"`
+		},
+		Bind: func(v interface{}) string {
+			*args = append(*args, v)
+			if postgres {
+				return fmt.Sprintf("$%d", len(*args))
+			}
+			return "?"
+		},
+	}
+}
+
+func TestSQL(t *testing.T) {
+	cases := []struct {
+		src      string
+		postgres string
+		mysql    string
+		args     []interface{}
+	}{
+		{"age = 36", `("age" = $2)`, `("age" = ?)`, []interface{}{int64(36)}},
+		{"age > 18 and region in ('EU', 'US')", `("age" > $2) AND ("region" IN ($3, $4))`, `("age" > ?) AND ("region" IN (?, ?))`, []interface{}{int64(18), "EU", "US"}},
+		{"region = 'EU' or deleted_at is null", `(("region" = $2) OR ("deleted_at" IS NULL))`, `(("region" = ?) OR ("deleted_at" IS NULL))`, []interface{}{"EU"}},
+		{"name like 'A%' and age = null", `("name" LIKE $2) AND ("age" = NULL)`, `("name" LIKE ?) AND ("age" = NULL)`, []interface{}{"A%"}},
+		// conditions databases may evaluate more narrowly are left to the filter.
+		{"updated_at > now() and region = 'EU'", `("region" = $2)`, `("region" = ?)`, []interface{}{"EU"}},
+		{"upper(region) = 'EU' and not age = 1 and name > 'M'", "", "", nil},
+		{"region = 'EU' or upper(region) = 'EU'", "", "", nil},
+		{"region not in ('EU') and name not like 'A%' and name like 'A\\_%'", "", "", nil},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.src)
+		if err != nil {
+			t.Fatalf("%s: %s", c.src, err)
+		}
+		// the primary key bound is bound first, placeholders of the filter continue after it.
+		args := []interface{}{int64(100)}
+		got, ok := e.SQL(testDialect(true, &args))
+		if ok != (c.postgres != "") || got != c.postgres {
+			t.Errorf("%s = %q for postgres, want %q", c.src, got, c.postgres)
+		}
+		if !reflect.DeepEqual(args[1:], c.args) && len(c.args)+len(args[1:]) > 0 {
+			t.Errorf("%s binds %v, want %v", c.src, args[1:], c.args)
+		}
+		args = []interface{}{int64(100)}
+		if got, _ := e.SQL(testDialect(false, &args)); got != c.mysql {
+			t.Errorf("%s = %q for mysql, want %q", c.src, got, c.mysql)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/expr"
+)
+
+// tableFilter replicates the rows of a table which match its filter. Updates are compared with the
+// row before the update, a row which moves into the filter is inserted and a row which moves out of
+// it is deleted. Without the row before the update, updates of matching rows are kept and the others
+// become deletes, and deletes which lack the filtered columns are kept.
+type tableFilter struct {
+	expr *expr.Expr
+}
+
+func newTableFilter(filter string) (*tableFilter, error) {
+	e, err := expr.Parse(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &tableFilter{expr: e}, nil
+}
+
+// match evaluates the filter on a record, known is false when the record lacks a filtered column.
+func (f *tableFilter) match(r EventRecord) (matched bool, known bool, err error) {
+	known = true
+	matched, err = f.expr.Match(func(name string) (interface{}, bool) {
+		field, err := r.FieldByName(name)
+		if err != nil {
+			known = false
+			return nil, false
+		}
+		return field.Value.V, true
+	})
+	return matched, known, err
+}
+
+// event returns the event to replicate, ok is false when it's filtered out.
+func (f *tableFilter) event(e Event) (Event, bool, error) {
+	matched, known, err := f.match(e.Record)
+	if err != nil {
+		return e, false, err
+	}
+	switch e.Type {
+	case EventTypeInsert:
+		return e, matched, nil
+	case EventTypeDelete:
+		return e, matched || !known, nil
+	case EventTypeUpdate:
+		oldMatched, oldKnown := false, false
+		if e.OldRecord != nil {
+			if oldMatched, oldKnown, err = f.match(*e.OldRecord); err != nil {
+				return e, false, err
+			}
+		}
+		switch {
+		case !oldKnown && matched:
+			return e, true, nil
+		case !oldKnown:
+			e.Type = EventTypeDelete
+			e.OldRecord = nil
+			return e, true, nil
+		case oldMatched && matched:
+			return e, true, nil
+		case matched:
+			e.Type = EventTypeInsert
+			e.OldRecord = nil
+			return e, true, nil
+		case oldMatched:
+			e.Type = EventTypeDelete
+			e.Record = *e.OldRecord
+			e.OldRecord = nil
+			return e, true, nil
+		}
+		return e, false, nil
+	}
+	return e, true, nil
+}
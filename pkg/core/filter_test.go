@@ -0,0 +1,71 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"testing"
+)
+
+func testFilterRecord(id int64, region string) *EventRecord {
+	var record EventRecord
+	record.Set("id", types.NewTypedData(schemas.TypeInt, id))
+	if region != "" {
+		record.Set("region", types.NewTypedData(schemas.TypeString, region))
+	}
+	return &record
+}
+
+func TestTableFilterEvent(t *testing.T) {
+	f, err := newTableFilter("region = 'EU'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		typ        EventType
+		record     *EventRecord
+		old        *EventRecord
+		ok         bool
+		want       EventType
+		wantRegion string
+	}{
+		{"insert matching", EventTypeInsert, testFilterRecord(1, "EU"), nil, true, EventTypeInsert, "EU"},
+		{"insert not matching", EventTypeInsert, testFilterRecord(1, "US"), nil, false, EventTypeInsert, "US"},
+		{"delete matching", EventTypeDelete, testFilterRecord(1, "EU"), nil, true, EventTypeDelete, "EU"},
+		{"delete not matching", EventTypeDelete, testFilterRecord(1, "US"), nil, false, EventTypeDelete, "US"},
+		{"delete of the key only", EventTypeDelete, testFilterRecord(1, ""), nil, true, EventTypeDelete, ""},
+		{"update inside", EventTypeUpdate, testFilterRecord(1, "EU"), testFilterRecord(1, "EU"), true, EventTypeUpdate, "EU"},
+		{"update outside", EventTypeUpdate, testFilterRecord(1, "US"), testFilterRecord(1, "US"), false, EventTypeUpdate, "US"},
+		{"update moving in", EventTypeUpdate, testFilterRecord(1, "EU"), testFilterRecord(1, "US"), true, EventTypeInsert, "EU"},
+		{"update moving out", EventTypeUpdate, testFilterRecord(1, "US"), testFilterRecord(1, "EU"), true, EventTypeDelete, "EU"},
+		{"update matching without the old row", EventTypeUpdate, testFilterRecord(1, "EU"), nil, true, EventTypeUpdate, "EU"},
+		{"update not matching without the old row", EventTypeUpdate, testFilterRecord(1, "US"), nil, true, EventTypeDelete, "US"},
+		{"update with the key only in the old row", EventTypeUpdate, testFilterRecord(1, "US"), testFilterRecord(1, ""), true, EventTypeDelete, "US"},
+	}
+	for _, c := range cases {
+		e, ok, err := f.event(Event{Type: c.typ, Record: *c.record, OldRecord: c.old})
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if ok != c.ok {
+			t.Errorf("%s: replicated %v, want %v", c.name, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if e.Type != c.want {
+			t.Errorf("%s: type %v, want %v", c.name, e.Type, c.want)
+		}
+		if c.want != EventTypeUpdate && e.OldRecord != nil {
+			t.Errorf("%s: old record should be removed", c.name)
+		}
+		region := ""
+		if field, err := e.Record.FieldByName("region"); err == nil {
+			region, _ = field.Value.V.(string)
+		}
+		if region != c.wantRegion {
+			t.Errorf("%s: replicated the row of %q, want %q", c.name, region, c.wantRegion)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultFlushInterval = 10 * time.Second
+
+// flusher flushes the buffered events of a writer on a timer,
+// so the last events are committed even when no more events arrive.
+type flusher struct {
+	flush    func() error
+	logger   *FileLogger
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func newFlusher(flush func() error, logger *FileLogger, interval time.Duration) *flusher {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &flusher{
+		flush:    flush,
+		logger:   logger,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (f *flusher) start() {
+	go (func() {
+		defer close(f.stopped)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.done:
+				return
+			case <-ticker.C:
+				if err := f.flush(); err != nil {
+					f.logger.Error("can not flush writer: %s", err)
+				}
+			}
+		}
+	})()
+}
+
+// stop stops the timer and flushes the remaining events.
+func (f *flusher) stop() error {
+	f.once.Do(func() {
+		close(f.done)
+	})
+	<-f.stopped
+	return f.flush()
+}
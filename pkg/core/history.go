@@ -0,0 +1,117 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"strings"
+	"time"
+)
+
+// columns of history tables.
+const (
+	HistoryIDColumn        = "_history_id"
+	HistoryOpColumn        = "_op"
+	HistorySourcePosColumn = "_source_pos"
+	HistoryCommitTSColumn  = "_commit_ts"
+	HistoryValidFromColumn = "_valid_from"
+	HistoryValidToColumn   = "_valid_to"
+)
+
+// HistorySchema returns the history table of a source table, the source primary key is replaced
+// by the surrogate key _history_id and the version columns are appended.
+func HistorySchema(sch *schemas.Table) *schemas.Table {
+	history := &schemas.Table{Name: sch.Name}
+	history.Columns = append(history.Columns, schemas.Column{
+		Name:         HistoryIDColumn,
+		DataType:     schemas.TypeString,
+		SecondlyType: schemas.SecondlyTypeVarChar,
+		ColumnLength: 40,
+		IsPrimaryKey: true,
+	})
+	for _, col := range sch.Columns {
+		if col.Name == HistoryIDColumn {
+			continue
+		}
+		col.IsPrimaryKey = false
+		col.Nullable = true
+		history.Columns = append(history.Columns, col)
+	}
+	history.Columns = append(history.Columns,
+		schemas.Column{Name: HistoryOpColumn, DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 16, Nullable: true},
+		schemas.Column{Name: HistorySourcePosColumn, DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 255, Nullable: true},
+		schemas.Column{Name: HistoryCommitTSColumn, DataType: schemas.TypeTimestamp, Nullable: true},
+		schemas.Column{Name: HistoryValidFromColumn, DataType: schemas.TypeTimestamp, Nullable: true},
+		schemas.Column{Name: HistoryValidToColumn, DataType: schemas.TypeTimestamp, Nullable: true},
+	)
+	return history
+}
+
+// HistoryEvents converts events into inserts of history rows. A version is closed by the next version
+// of the same source key in events, deletes are closed at once. The history id is derived from the
+// event, so a retried batch writes the same rows again.
+func HistoryEvents(events []Event) []Event {
+	rows := make([]Event, 0, len(events))
+	latest := make(map[string]int)
+	now := time.Now()
+	for _, e := range events {
+		validFrom := e.CommitTime
+		if validFrom.IsZero() {
+			validFrom = now
+		}
+		key := HistoryKey(e)
+		if idx, ok := latest[key]; ok {
+			rows[idx].Record.Set(HistoryValidToColumn, types.NewTypedData(schemas.TypeTimestamp, validFrom))
+		}
+		var record EventRecord
+		record.Set(HistoryIDColumn, types.NewTypedData(schemas.TypeString, historyID(e, key)))
+		for _, col := range e.Record.Columns {
+			record.Set(col.Name, col.Value)
+		}
+		record.Set(HistoryOpColumn, types.NewTypedData(schemas.TypeString, eventTypeNames[e.Type]))
+		record.Set(HistorySourcePosColumn, types.NewTypedData(schemas.TypeString, e.LastPOS))
+		if e.CommitTime.IsZero() {
+			record.Set(HistoryCommitTSColumn, types.NewNullData())
+		} else {
+			record.Set(HistoryCommitTSColumn, types.NewTypedData(schemas.TypeTimestamp, e.CommitTime))
+		}
+		record.Set(HistoryValidFromColumn, types.NewTypedData(schemas.TypeTimestamp, validFrom))
+		if e.Type == EventTypeDelete {
+			record.Set(HistoryValidToColumn, types.NewTypedData(schemas.TypeTimestamp, validFrom))
+			delete(latest, key)
+		} else {
+			record.Set(HistoryValidToColumn, types.NewNullData())
+			latest[key] = len(rows)
+		}
+		rows = append(rows, Event{
+			Type:                 EventTypeInsert,
+			Record:               record,
+			SourceSchema:         e.SourceSchema,
+			DestinationTableName: e.DestinationTableName,
+			LastPOS:              e.LastPOS,
+			CommitTime:           e.CommitTime,
+		})
+	}
+	return rows
+}
+
+// HistoryKey returns the source primary key of an event as a string.
+func HistoryKey(e Event) string {
+	var parts []string
+	for _, pk := range e.SourceSchema.GetPrimaryKeyNames() {
+		v, err := e.Record.FieldByName(pk)
+		if err != nil {
+			parts = append(parts, "")
+			continue
+		}
+		parts = append(parts, fmt.Sprint(v.Value.V))
+	}
+	return strings.Join(parts, "|")
+}
+
+func historyID(e Event, key string) string {
+	h := sha1.Sum([]byte(strings.Join([]string{e.DestinationTableName, key, e.LastPOS, eventTypeNames[e.Type]}, "\x00")))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,62 @@
+package core
+
+import "sync"
+
+// inflight tracks the events handed to the thread pool for a writer until they're applied or buffered,
+// the position of the writer is not acknowledged beyond them.
+type inflight struct {
+	mutex  sync.Mutex
+	events []*inflightEvent
+	// last is the position of the latest event removed from the head of events.
+	last string
+}
+
+type inflightEvent struct {
+	position string
+	done     bool
+}
+
+// add tracks an event which is handed to the thread pool, events are added in the order they're read.
+func (f *inflight) add(position string) *inflightEvent {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e := &inflightEvent{position: position}
+	f.events = append(f.events, e)
+	return e
+}
+
+// done marks an event as applied or buffered, finished events are removed from the head.
+func (f *inflight) done(e *inflightEvent) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e.done = true
+	for len(f.events) > 0 && f.events[0].done {
+		f.last = f.events[0].position
+		f.events[0] = nil
+		f.events = f.events[1:]
+	}
+}
+
+// limit returns the position the events in flight allow to acknowledge, limited is false when none is in flight.
+// position is "" when it can not be told, e.g. events at the same position are still in flight, or positions
+// can not be compared.
+func (f *inflight) limit(comparer PositionComparer) (position string, limited bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.events) < 1 {
+		return "", false
+	}
+	if f.last == "" || comparer == nil {
+		return "", true
+	}
+	// events of tables found while cdc runs are handed to the pool after later events, so they're all checked.
+	for _, e := range f.events {
+		if e.done {
+			continue
+		}
+		if e.position == "" || comparer.ComparePosition(e.position, f.last) <= 0 {
+			return "", true
+		}
+	}
+	return f.last, true
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+type intComparer struct{}
+
+func (intComparer) ComparePosition(a string, b string) int {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func TestInflightLimit(t *testing.T) {
+	var f inflight
+	if _, limited := f.limit(intComparer{}); limited {
+		t.Fatal("no event is in flight")
+	}
+	first := f.add("1")
+	second := f.add("2")
+	third := f.add("3")
+	if pos, limited := f.limit(intComparer{}); !limited || pos != "" {
+		t.Fatalf("nothing is applied yet, got %q", pos)
+	}
+	f.done(second)
+	if pos, _ := f.limit(intComparer{}); pos != "" {
+		t.Fatalf("event 1 is in flight, got %q", pos)
+	}
+	f.done(first)
+	if pos, limited := f.limit(intComparer{}); !limited || pos != "2" {
+		t.Fatalf("expected 2, got %q", pos)
+	}
+	if pos, limited := f.limit(nil); !limited || pos != "" {
+		t.Fatalf("positions can not be compared, got %q", pos)
+	}
+	f.done(third)
+	if _, limited := f.limit(intComparer{}); limited {
+		t.Fatal("every event is applied")
+	}
+}
+
+func TestInflightLimitSamePosition(t *testing.T) {
+	var f inflight
+	f.done(f.add("1"))
+	f.done(f.add("2"))
+	f.add("2")
+	if pos, _ := f.limit(intComparer{}); pos != "" {
+		t.Fatalf("a row of position 2 is in flight, got %q", pos)
+	}
+}
+
+func TestInflightLimitEarlierEvent(t *testing.T) {
+	var f inflight
+	f.done(f.add("5"))
+	// buffered events of a table found while cdc runs are handed to the pool later.
+	f.add("3")
+	if pos, _ := f.limit(intComparer{}); pos != "" {
+		t.Fatalf("event 3 is in flight, got %q", pos)
+	}
+}
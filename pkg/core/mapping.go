@@ -0,0 +1,337 @@
+package core
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core/expr"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tableMapping applies the column mapping of a table define on schemas and records. Columns are
+// excluded and renamed first, then typed, constant and computed columns are added in name order.
+// Expressions refer to the source columns. The shard column is added last and extends the primary key.
+type tableMapping struct {
+	exclude   map[string]bool
+	rename    map[string]string
+	types     map[string]schemas.Column
+	constants []mappedColumn
+	computed  []mappedColumn
+	shard     *mappedColumn
+}
+
+type mappedColumn struct {
+	name  string
+	value interface{}
+	expr  *expr.Expr
+}
+
+// newTableMapping builds the mapping of a table of a database, the database and the table are the
+// value of its shard column.
+func newTableMapping(table model.TableDefine, database string) (*tableMapping, error) {
+	m := &tableMapping{
+		exclude: make(map[string]bool),
+		rename:  table.Rename,
+		types:   make(map[string]schemas.Column),
+	}
+	for _, name := range table.Exclude {
+		m.exclude[name] = true
+	}
+	for name, t := range table.Types {
+		col, err := parseColumnType(t)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		col.Name = name
+		m.types[name] = col
+	}
+	for name, value := range table.Constants {
+		// json numbers are floats, whole numbers are kept as integers.
+		if f, ok := value.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			value = int64(f)
+		}
+		m.constants = append(m.constants, mappedColumn{name: name, value: value})
+	}
+	for name, src := range table.Computed {
+		e, err := expr.Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		m.computed = append(m.computed, mappedColumn{name: name, expr: e})
+	}
+	if table.Shard != "" {
+		shard := table.SourceTable
+		if database != "" {
+			shard = database + "." + shard
+		}
+		m.shard = &mappedColumn{name: table.Shard, value: shard}
+	}
+	sort.Slice(m.constants, func(i, j int) bool { return m.constants[i].name < m.constants[j].name })
+	sort.Slice(m.computed, func(i, j int) bool { return m.computed[i].name < m.computed[j].name })
+	return m, nil
+}
+
+func (m *tableMapping) columnName(name string) string {
+	if n, ok := m.rename[name]; ok && n != "" {
+		return n
+	}
+	return name
+}
+
+// addedColumns returns the names of the constant, computed and shard columns.
+func (m *tableMapping) addedColumns() []string {
+	var names []string
+	for _, c := range m.constants {
+		names = append(names, c.name)
+	}
+	for _, c := range m.computed {
+		names = append(names, c.name)
+	}
+	if m.shard != nil {
+		names = append(names, m.shard.name)
+	}
+	return names
+}
+
+// schema maps the schema of the source table, the name stays the source table name.
+func (m *tableMapping) schema(sch schemas.Table) schemas.Table {
+	mapped := schemas.Table{Name: sch.Name}
+	var index uint
+	for _, col := range sch.Columns {
+		if m.exclude[col.Name] {
+			continue
+		}
+		col.Name = m.columnName(col.Name)
+		if t, ok := m.types[col.Name]; ok {
+			col.DataType = t.DataType
+			col.SecondlyType = t.SecondlyType
+			col.ColumnLength = t.ColumnLength
+			col.NumericPrecision = t.NumericPrecision
+			col.NumericScale = t.NumericScale
+		}
+		if col.Index > index {
+			index = col.Index
+		}
+		mapped.Columns = append(mapped.Columns, col)
+	}
+	add := func(c mappedColumn, v interface{}) {
+		if mapped.Exists(c.name) {
+			return
+		}
+		col, ok := m.types[c.name]
+		if !ok {
+			col = defaultColumn(inferType(v))
+		}
+		index++
+		col.Name = c.name
+		col.Index = index
+		col.Nullable = true
+		col.IsPrimaryKey = false
+		mapped.Columns = append(mapped.Columns, col)
+	}
+	for _, c := range m.constants {
+		add(c, c.value)
+	}
+	for _, c := range m.computed {
+		// the type of an expression is only known once it's evaluated, they are strings unless typed.
+		add(c, "")
+	}
+	if m.shard != nil {
+		add(*m.shard, m.shard.value)
+		for i := range mapped.Columns {
+			if mapped.Columns[i].Name == m.shard.name {
+				mapped.Columns[i].IsPrimaryKey = true
+				mapped.Columns[i].Nullable = false
+			}
+		}
+	}
+	return mapped
+}
+
+func (m *tableMapping) record(r EventRecord) (EventRecord, error) {
+	var mapped EventRecord
+	for _, field := range r.Columns {
+		if m.exclude[field.Name] {
+			continue
+		}
+		name := m.columnName(field.Name)
+		value := field.Value
+		if t, ok := m.types[name]; ok {
+			v, err := castValue(t.DataType, value)
+			if err != nil {
+				return mapped, fmt.Errorf("can not cast column %s: %w", name, err)
+			}
+			value = v
+		}
+		mapped.Set(name, value)
+	}
+	for _, c := range m.constants {
+		v, err := m.typed(c.name, c.value)
+		if err != nil {
+			return mapped, err
+		}
+		mapped.Set(c.name, v)
+	}
+	env := func(name string) (interface{}, bool) {
+		field, err := r.FieldByName(name)
+		if err != nil {
+			return nil, false
+		}
+		return field.Value.V, true
+	}
+	for _, c := range m.computed {
+		res, err := c.expr.Eval(env)
+		if err != nil {
+			return mapped, fmt.Errorf("can not compute column %s: %w", c.name, err)
+		}
+		v, err := m.typed(c.name, res)
+		if err != nil {
+			return mapped, err
+		}
+		mapped.Set(c.name, v)
+	}
+	if m.shard != nil {
+		v, err := m.typed(m.shard.name, m.shard.value)
+		if err != nil {
+			return mapped, err
+		}
+		mapped.Set(m.shard.name, v)
+	}
+	return mapped, nil
+}
+
+func (m *tableMapping) typed(name string, v interface{}) (types.TypedData, error) {
+	t, ok := m.types[name]
+	if !ok {
+		return types.NewTypedData(inferType(v), v), nil
+	}
+	td, err := castValue(t.DataType, types.NewTypedData(inferType(v), v))
+	if err != nil {
+		return td, fmt.Errorf("can not cast column %s: %w", name, err)
+	}
+	return td, nil
+}
+
+func (m *tableMapping) event(e Event) (Event, error) {
+	record, err := m.record(e.Record)
+	if err != nil {
+		return e, err
+	}
+	e.Record = record
+	if e.OldRecord != nil {
+		old, err := m.record(*e.OldRecord)
+		if err != nil {
+			return e, err
+		}
+		e.OldRecord = &old
+	}
+	e.SourceSchema = m.schema(e.SourceSchema)
+	return e, nil
+}
+
+// castValue converts a value to another type, it's decoded into a plain value and encoded as the type.
+func castValue(t schemas.Type, v types.TypedData) (types.TypedData, error) {
+	if v.T == t || v.V == nil {
+		return types.TypedData{T: t, V: v.V}, nil
+	}
+	val, err := defaultTypes.Decode(v)
+	if err != nil {
+		return v, err
+	}
+	switch t {
+	case schemas.TypeInt, schemas.TypeUint:
+		// the integer encoders do not take floats, whole numbers are converted.
+		if f, ok := val.(float64); ok {
+			if f != math.Trunc(f) {
+				return v, fmt.Errorf("%v is not a whole number", f)
+			}
+			val = int64(f)
+		}
+	}
+	return defaultTypes.Encode(t, val)
+}
+
+// defaultColumn is the column migrated for values of a type without a declared column type.
+func defaultColumn(t schemas.Type) schemas.Column {
+	switch t {
+	case schemas.TypeInt:
+		return schemas.Column{DataType: t, SecondlyType: schemas.SecondlyTypeBigInt}
+	case schemas.TypeDecimal:
+		return schemas.Column{DataType: t, SecondlyType: schemas.SecondlyTypeDecimal, NumericPrecision: 20, NumericScale: 6}
+	case schemas.TypeBool, schemas.TypeTimestamp, schemas.TypeJSON, schemas.TypeBlob:
+		return schemas.Column{DataType: t}
+	}
+	return schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 255}
+}
+
+var columnTypePattern = regexp.MustCompile(`^([a-z]+)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+// parseColumnType parses types such as bigint, varchar(64), decimal(10,2) or timestamptz.
+func parseColumnType(s string) (schemas.Column, error) {
+	matches := columnTypePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if matches == nil {
+		return schemas.Column{}, fmt.Errorf("invalid type %q", s)
+	}
+	var args []int
+	for _, m := range matches[2:] {
+		if m == "" {
+			break
+		}
+		n, _ := strconv.Atoi(m)
+		args = append(args, n)
+	}
+	arg := func(i int, def int) int {
+		if i < len(args) {
+			return args[i]
+		}
+		return def
+	}
+	var col schemas.Column
+	switch matches[1] {
+	case "int", "integer":
+		col = schemas.Column{DataType: schemas.TypeInt}
+	case "smallint":
+		col = schemas.Column{DataType: schemas.TypeInt, SecondlyType: schemas.SecondlyTypeSmallInt}
+	case "mediumint":
+		col = schemas.Column{DataType: schemas.TypeInt, SecondlyType: schemas.SecondlyTypeMediumInt}
+	case "bigint":
+		col = schemas.Column{DataType: schemas.TypeInt, SecondlyType: schemas.SecondlyTypeBigInt}
+	case "float", "double":
+		col = schemas.Column{DataType: schemas.TypeDecimal, SecondlyType: schemas.SecondlyTypeFloat}
+	case "real":
+		col = schemas.Column{DataType: schemas.TypeDecimal, SecondlyType: schemas.SecondlyTypeReal}
+	case "decimal", "numeric":
+		col = schemas.Column{DataType: schemas.TypeDecimal, SecondlyType: schemas.SecondlyTypeDecimal,
+			NumericPrecision: arg(0, 20), NumericScale: arg(1, 6)}
+	case "varchar", "string":
+		col = schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: arg(0, 255)}
+	case "char":
+		col = schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeChar, ColumnLength: arg(0, 1)}
+	case "text":
+		col = schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeText}
+	case "bool", "boolean":
+		col = schemas.Column{DataType: schemas.TypeBool}
+	case "date":
+		col = schemas.Column{DataType: schemas.TypeDate}
+	case "time":
+		col = schemas.Column{DataType: schemas.TypeTime}
+	case "timestamp", "datetime":
+		col = schemas.Column{DataType: schemas.TypeTimestamp}
+	case "timestamptz":
+		col = schemas.Column{DataType: schemas.TypeTimestamp, SecondlyType: schemas.SecondlyTypeTimestampWithTZ}
+	case "json", "jsonb":
+		col = schemas.Column{DataType: schemas.TypeJSON}
+	case "uuid":
+		col = schemas.Column{DataType: schemas.TypeUUID}
+	case "blob", "bytes", "bytea":
+		col = schemas.Column{DataType: schemas.TypeBlob, SecondlyType: schemas.SecondlyTypeBlob}
+	default:
+		return col, fmt.Errorf("unsupported type %q", s)
+	}
+	return col, nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"reflect"
+	"testing"
+)
+
+func testMappingTable() model.TableDefine {
+	return model.TableDefine{
+		SourceTable:      "users",
+		DestinationTable: "people",
+		Rename:           map[string]string{"id": "user_id"},
+		Exclude:          []string{"password"},
+		Types:            map[string]string{"age": "bigint", "score": "decimal(10,2)"},
+		Constants:        map[string]interface{}{"region": "eu", "version": float64(2)},
+		Computed:         map[string]string{"full_name": "concat(first, ' ', last)"},
+		Shard:            "_shard",
+	}
+}
+
+func testMappingRecord() EventRecord {
+	var record EventRecord
+	record.Set("id", types.NewTypedData(schemas.TypeInt, int64(1)))
+	record.Set("first", types.NewTypedData(schemas.TypeString, "Ada"))
+	record.Set("last", types.NewTypedData(schemas.TypeString, "Lovelace"))
+	record.Set("password", types.NewTypedData(schemas.TypeString, "secret"))
+	record.Set("age", types.NewTypedData(schemas.TypeString, "36"))
+	return record
+}
+
+func TestMappingRecord(t *testing.T) {
+	m, err := newTableMapping(testMappingTable(), "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := m.record(testMappingRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		column string
+		want   types.TypedData
+	}{
+		{"user_id", types.NewTypedData(schemas.TypeInt, int64(1))},
+		{"first", types.NewTypedData(schemas.TypeString, "Ada")},
+		{"age", types.NewTypedData(schemas.TypeInt, int64(36))},
+		{"region", types.NewTypedData(schemas.TypeString, "eu")},
+		{"version", types.NewTypedData(schemas.TypeInt, int64(2))},
+		{"full_name", types.NewTypedData(schemas.TypeString, "Ada Lovelace")},
+		{"_shard", types.NewTypedData(schemas.TypeString, "shop.users")},
+	}
+	for _, c := range cases {
+		field, err := record.FieldByName(c.column)
+		if err != nil {
+			t.Errorf("column %s is missing", c.column)
+			continue
+		}
+		if !reflect.DeepEqual(field.Value, c.want) {
+			t.Errorf("column %s = %#v, want %#v", c.column, field.Value, c.want)
+		}
+	}
+	for _, column := range []string{"id", "password"} {
+		if _, err := record.FieldByName(column); err == nil {
+			t.Errorf("column %s should be removed", column)
+		}
+	}
+}
+
+func TestMappingSchema(t *testing.T) {
+	m, err := newTableMapping(testMappingTable(), "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sch := m.schema(schemas.Table{Name: "users", Columns: []schemas.Column{
+		{Name: "id", DataType: schemas.TypeInt, IsPrimaryKey: true, Index: 1},
+		{Name: "password", DataType: schemas.TypeString, Index: 2},
+		{Name: "age", DataType: schemas.TypeString, Index: 3},
+	}})
+	var names []string
+	for _, col := range sch.Columns {
+		names = append(names, col.Name)
+	}
+	if want := []string{"user_id", "age", "region", "version", "full_name", "_shard"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("columns = %v, want %v", names, want)
+	}
+	if want := []string{"user_id", "_shard"}; !reflect.DeepEqual(sch.GetPrimaryKeyNames(), want) {
+		t.Fatalf("primary keys = %v, want %v", sch.GetPrimaryKeyNames(), want)
+	}
+	for _, col := range sch.Columns {
+		if col.Name == "age" && col.DataType != schemas.TypeInt {
+			t.Fatalf("age should be typed as an integer, got %v", col.DataType)
+		}
+	}
+}
+
+func TestMappingErrors(t *testing.T) {
+	cases := []model.TableDefine{
+		{SourceTable: "users", Types: map[string]string{"age": "big int"}},
+		{SourceTable: "users", Computed: map[string]string{"name": "upper(first"}},
+		{SourceTable: "users", Computed: map[string]string{"name": "nope(first)"}},
+	}
+	for _, c := range cases {
+		if _, err := newTableMapping(c, ""); err == nil {
+			t.Errorf("mapping %+v should be rejected", c)
+		}
+	}
+	m, err := newTableMapping(model.TableDefine{SourceTable: "users", Types: map[string]string{"first": "int"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.record(testMappingRecord()); err == nil {
+		t.Fatal("casting a name to an integer should fail")
+	}
+}
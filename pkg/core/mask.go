@@ -0,0 +1,275 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/config"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaskHash     = "hash"
+	MaskRedact   = "redact"
+	MaskPartial  = "partial"
+	MaskTokenize = "tokenize"
+	MaskEncrypt  = "encrypt"
+
+	redactedValue = "***"
+)
+
+// maskFunc masks a non null value.
+type maskFunc func(v interface{}) (string, error)
+
+type columnMask struct {
+	fn     maskFunc
+	column schemas.Column
+}
+
+// tableMasks masks the columns of a table, they're applied on events after the mapping and the
+// transform, so they see the destination columns and every writer gets masked values.
+type tableMasks map[string]columnMask
+
+func newTableMasks(masks map[string]string, keys config.Masking) (tableMasks, error) {
+	m := make(tableMasks)
+	for name, spec := range masks {
+		mask, err := newColumnMask(spec, keys)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		m[name] = mask
+	}
+	return m, nil
+}
+
+var maskSpecPattern = regexp.MustCompile(`^([a-z]+)\s*(?:\(\s*(\d+)\s*(?:,\s*(\d+)\s*)?\))?$`)
+
+// newColumnMask parses a mask: hash, redact, partial, partial(start,end), tokenize or encrypt.
+func newColumnMask(spec string, keys config.Masking) (columnMask, error) {
+	matches := maskSpecPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(spec)))
+	if matches == nil {
+		return columnMask{}, fmt.Errorf("invalid mask %q", spec)
+	}
+	name := matches[1]
+	if name != MaskPartial && matches[2] != "" {
+		return columnMask{}, fmt.Errorf("mask %s takes no arguments", name)
+	}
+	varchar := func(length int) schemas.Column {
+		return schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: length}
+	}
+	text := schemas.Column{DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeText}
+	switch name {
+	case MaskHash:
+		if keys.Salt == "" {
+			return columnMask{}, errors.New("mask hash needs masking.salt in the config")
+		}
+		return columnMask{fn: hashMask(keys.Salt), column: varchar(64)}, nil
+	case MaskRedact:
+		return columnMask{fn: redactMask, column: varchar(255)}, nil
+	case MaskPartial:
+		if matches[2] == "" {
+			return columnMask{fn: partialMask(-1, -1), column: text}, nil
+		}
+		start, _ := strconv.Atoi(matches[2])
+		end := 0
+		if matches[3] != "" {
+			end, _ = strconv.Atoi(matches[3])
+		}
+		return columnMask{fn: partialMask(start, end), column: text}, nil
+	case MaskTokenize:
+		if keys.TokenKey == "" {
+			return columnMask{}, errors.New("mask tokenize needs masking.token_key in the config")
+		}
+		return columnMask{fn: tokenizeMask([]byte(keys.TokenKey)), column: varchar(64)}, nil
+	case MaskEncrypt:
+		key, err := decodeKey(keys.EncryptionKey)
+		if err != nil {
+			return columnMask{}, err
+		}
+		fn, err := encryptMask(key)
+		if err != nil {
+			return columnMask{}, err
+		}
+		return columnMask{fn: fn, column: text}, nil
+	}
+	return columnMask{}, fmt.Errorf("unknown mask %q", spec)
+}
+
+// hashMask is the hex encoded SHA-256 of the salt and the value.
+func hashMask(salt string) maskFunc {
+	return func(v interface{}) (string, error) {
+		sum := sha256.Sum256([]byte(salt + maskString(v)))
+		return hex.EncodeToString(sum[:]), nil
+	}
+}
+
+func redactMask(v interface{}) (string, error) {
+	return redactedValue, nil
+}
+
+// partialMask keeps the first start and the last end characters and masks the others. Without them,
+// emails keep the first character of the name and the domain, other values keep the last 4 characters.
+func partialMask(start int, end int) maskFunc {
+	return func(v interface{}) (string, error) {
+		s := maskString(v)
+		if start < 0 {
+			if at := strings.LastIndexByte(s, '@'); at > 0 {
+				return maskRunes(s[:at], 1, 0) + s[at:], nil
+			}
+			return maskRunes(s, 0, 4), nil
+		}
+		return maskRunes(s, start, end), nil
+	}
+}
+
+func maskRunes(s string, start int, end int) string {
+	runes := []rune(s)
+	if start+end >= len(runes) {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:start]) + strings.Repeat("*", len(runes)-start-end) + string(runes[len(runes)-end:])
+}
+
+// tokenizeMask is the hex encoded HMAC-SHA256 of the value, equal values get equal tokens.
+func tokenizeMask(key []byte) maskFunc {
+	return func(v interface{}) (string, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(maskString(v)))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	}
+}
+
+// encryptMask encrypts values with AES-GCM, the value is the base64 encoded nonce and ciphertext.
+func encryptMask(key []byte) (maskFunc, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) (string, error) {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := gcm.Seal(nonce, nonce, []byte(maskString(v)), nil)
+		return base64.StdEncoding.EncodeToString(sealed), nil
+	}, nil
+}
+
+// DecryptMasked decrypts a value of the encrypt mask.
+func DecryptMasked(key []byte, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// decodeKey decodes a hex or base64 encoded AES key.
+func decodeKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("mask encrypt needs masking.encryption_key in the config")
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("masking.encryption_key is neither hex nor base64")
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("masking.encryption_key has %d bytes, AES keys have 16, 24 or 32 bytes", len(key))
+}
+
+func maskString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func (m tableMasks) record(r EventRecord) (EventRecord, error) {
+	masked := EventRecord{Columns: make([]EventField, len(r.Columns))}
+	for i, field := range r.Columns {
+		masked.Columns[i] = field
+		mask, ok := m[field.Name]
+		if !ok || field.Value.V == nil {
+			continue
+		}
+		v, err := mask.fn(field.Value.V)
+		if err != nil {
+			return masked, fmt.Errorf("can not mask column %s: %w", field.Name, err)
+		}
+		masked.Columns[i].Value = types.NewTypedData(schemas.TypeString, v)
+	}
+	return masked, nil
+}
+
+func (m tableMasks) event(e Event) (Event, error) {
+	record, err := m.record(e.Record)
+	if err != nil {
+		return e, err
+	}
+	e.Record = record
+	if e.OldRecord != nil {
+		old, err := m.record(*e.OldRecord)
+		if err != nil {
+			return e, err
+		}
+		e.OldRecord = &old
+	}
+	return e, nil
+}
+
+// schema migrates masked columns as strings.
+func (m tableMasks) schema(sch schemas.Table) schemas.Table {
+	columns := make([]schemas.Column, len(sch.Columns))
+	for i, col := range sch.Columns {
+		if mask, ok := m[col.Name]; ok {
+			col.DataType = mask.column.DataType
+			col.SecondlyType = mask.column.SecondlyType
+			col.ColumnLength = mask.column.ColumnLength
+			col.NumericPrecision = 0
+			col.NumericScale = 0
+		}
+		columns[i] = col
+	}
+	sch.Columns = columns
+	return sch
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/imiskolee/anycdc/pkg/config"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"strings"
+	"testing"
+)
+
+var testMaskKeys = config.Masking{
+	Salt:          "pepper",
+	TokenKey:      "token-key",
+	EncryptionKey: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+}
+
+func mustMask(t *testing.T, spec string, v interface{}) string {
+	t.Helper()
+	mask, err := newColumnMask(spec, testMaskKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := mask.fn(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return masked
+}
+
+func TestMaskHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("pepper" + "ada@example.com"))
+	if got := mustMask(t, "hash", "ada@example.com"); got != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected hash %s", got)
+	}
+	if mustMask(t, "hash", int64(42)) != mustMask(t, "hash", "42") {
+		t.Fatal("hash of a number should be the hash of its text")
+	}
+	if _, err := newColumnMask("hash", config.Masking{}); err == nil {
+		t.Fatal("hash without a salt should fail")
+	}
+}
+
+func TestMaskRedact(t *testing.T) {
+	if got := mustMask(t, "redact", "+44 20 7946 0958"); got != redactedValue {
+		t.Fatalf("unexpected redacted value %s", got)
+	}
+}
+
+func TestMaskPartial(t *testing.T) {
+	cases := []struct {
+		spec  string
+		value interface{}
+		want  string
+	}{
+		{"partial", "ada@example.com", "a**@example.com"},
+		{"partial", "AB123456C", "*****456C"},
+		{"partial", "123", "***"},
+		{"partial(2,2)", "+447946095", "+4******95"},
+		{"partial(3)", "secret", "sec***"},
+		{"partial(1,1)", "ab", "**"},
+		{"partial", int64(123456789), "*****6789"},
+	}
+	for _, c := range cases {
+		if got := mustMask(t, c.spec, c.value); got != c.want {
+			t.Fatalf("%s of %v: want %s, got %s", c.spec, c.value, c.want, got)
+		}
+	}
+}
+
+func TestMaskTokenize(t *testing.T) {
+	a := mustMask(t, "tokenize", "AB123456C")
+	if a != mustMask(t, "tokenize", "AB123456C") {
+		t.Fatal("tokens should be deterministic")
+	}
+	if a == mustMask(t, "tokenize", "AB123456D") {
+		t.Fatal("different values should have different tokens")
+	}
+	if len(a) != 64 || strings.Contains(a, "AB123456C") {
+		t.Fatalf("unexpected token %s", a)
+	}
+	if _, err := newColumnMask("tokenize", config.Masking{}); err == nil {
+		t.Fatal("tokenize without a key should fail")
+	}
+}
+
+func TestMaskEncrypt(t *testing.T) {
+	a := mustMask(t, "encrypt", "ada@example.com")
+	if a == mustMask(t, "encrypt", "ada@example.com") {
+		t.Fatal("encrypted values should use random nonces")
+	}
+	key, err := decodeKey(testMaskKeys.EncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := DecryptMasked(key, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "ada@example.com" {
+		t.Fatalf("unexpected decrypted value %s", plain)
+	}
+	for _, k := range []string{"", "abcd", "not a key!"} {
+		if _, err := newColumnMask("encrypt", config.Masking{EncryptionKey: k}); err == nil {
+			t.Fatalf("encrypt with key %q should fail", k)
+		}
+	}
+}
+
+func TestMaskSpec(t *testing.T) {
+	for _, spec := range []string{"unknown", "hash(1)", "partial(a)", ""} {
+		if _, err := newColumnMask(spec, testMaskKeys); err == nil {
+			t.Fatalf("mask %q should fail", spec)
+		}
+	}
+}
+
+func TestMaskEvent(t *testing.T) {
+	masks, err := newTableMasks(map[string]string{"email": "tokenize", "phone": "redact"}, testMaskKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record EventRecord
+	record.Set("id", types.NewTypedData(schemas.TypeInt, int64(1)))
+	record.Set("email", types.NewTypedData(schemas.TypeString, "ada@example.com"))
+	record.Set("phone", types.NewNullData())
+	old := record
+	e, err := masks.event(Event{Type: EventTypeUpdate, Record: record, OldRecord: &old})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []EventRecord{e.Record, *e.OldRecord} {
+		email, _ := r.FieldByName("email")
+		if email.Value.V != mustMask(t, "tokenize", "ada@example.com") {
+			t.Fatalf("email is not masked: %v", email.Value.V)
+		}
+		if phone, _ := r.FieldByName("phone"); phone.Value.V != nil {
+			t.Fatal("null values should stay null")
+		}
+		if id, _ := r.FieldByName("id"); id.Value.V != int64(1) {
+			t.Fatal("other columns should not change")
+		}
+	}
+	if email, _ := record.FieldByName("email"); email.Value.V != "ada@example.com" {
+		t.Fatal("the source record should not change")
+	}
+	sch := masks.schema(schemas.Table{Columns: []schemas.Column{{Name: "email", DataType: schemas.TypeInt}}})
+	if sch.Columns[0].DataType != schemas.TypeString || sch.Columns[0].ColumnLength != 64 {
+		t.Fatalf("masked columns should be migrated as strings: %+v", sch.Columns[0])
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"time"
+)
+
+// cdc metadata columns appended to written rows when WriterPolicy.MetadataColumns is set.
+const (
+	MetaOpColumn       = "_cdc_op"
+	MetaSourceTSColumn = "_cdc_source_ts"
+	MetaPositionColumn = "_cdc_position"
+	MetaSyncedAtColumn = "_cdc_synced_at"
+	MetaTaskColumn     = "_cdc_task"
+)
+
+// MetadataColumns describes the metadata columns added to migrated tables.
+func MetadataColumns() []schemas.Column {
+	return []schemas.Column{
+		{Name: MetaOpColumn, DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 16, Nullable: true},
+		{Name: MetaSourceTSColumn, DataType: schemas.TypeTimestamp, Nullable: true},
+		{Name: MetaPositionColumn, DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 255, Nullable: true},
+		{Name: MetaSyncedAtColumn, DataType: schemas.TypeTimestamp, Nullable: true},
+		{Name: MetaTaskColumn, DataType: schemas.TypeString, SecondlyType: schemas.SecondlyTypeVarChar, ColumnLength: 255, Nullable: true},
+	}
+}
+
+// WithMetadata returns e with the metadata columns set on its record when the task enables them,
+// writers call it before ConvertRecord, so the columns are dropped on tables without them. Events which
+// already carry them are kept, so buffered events keep the values set when they arrived.
+func WithMetadata(task *model.Task, e Event) Event {
+	if task == nil || !task.GetWriterPolicy().MetadataColumns {
+		return e
+	}
+	if _, err := e.Record.FieldByName(MetaOpColumn); err == nil {
+		return e
+	}
+	record := EventRecord{Columns: make([]EventField, len(e.Record.Columns), len(e.Record.Columns)+5)}
+	copy(record.Columns, e.Record.Columns)
+	record.Set(MetaOpColumn, types.NewTypedData(schemas.TypeString, eventTypeNames[e.Type]))
+	if e.CommitTime.IsZero() {
+		record.Set(MetaSourceTSColumn, types.NewNullData())
+	} else {
+		record.Set(MetaSourceTSColumn, types.NewTypedData(schemas.TypeTimestamp, e.CommitTime))
+	}
+	record.Set(MetaPositionColumn, types.NewTypedData(schemas.TypeString, e.LastPOS))
+	record.Set(MetaSyncedAtColumn, types.NewTypedData(schemas.TypeTimestamp, time.Now()))
+	record.Set(MetaTaskColumn, types.NewTypedData(schemas.TypeString, task.Name))
+	e.Record = record
+	return e
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
@@ -24,6 +25,33 @@ func (s *Pipeline) Append(cdcPosition string, event Event) {
 	s.Count++
 }
 
+// Remove drops the events of a table, it's used after the table has been flushed.
+func (s *Pipeline) Remove(table string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Count -= len(s.Events[table])
+	delete(s.Events, table)
+}
+
+// Position returns the cdc position of the latest appended event.
+func (s *Pipeline) Position() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cdcPosition
+}
+
+// SortEvents sorts events by their positions, events at the same position keep their order. Events are
+// appended by concurrent workers, so they may arrive out of the source order. Events are kept as they
+// are without a comparer.
+func SortEvents(comparer PositionComparer, events []Event) {
+	if comparer == nil {
+		return
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return comparer.ComparePosition(events[i].LastPOS, events[j].LastPOS) < 0
+	})
+}
+
 func NewPipeline() *Pipeline {
 	return &Pipeline{
 		CreatedAt: time.Now(),
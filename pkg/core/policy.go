@@ -0,0 +1,204 @@
+package core
+
+import (
+	"github.com/imiskolee/anycdc/pkg/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxFailBackoff = time.Minute
+
+// TablePolicyState is the fail policy of a table and the failures it has handled.
+type TablePolicyState struct {
+	Table       string           `json:"table"`
+	Policy      model.FailPolicy `json:"policy"`
+	Retries     int              `json:"retries"`
+	Skipped     int64            `json:"skipped"`
+	LastError   string           `json:"last_error"`
+	LastErrorAt *time.Time       `json:"last_error_at"`
+	Stopped     bool             `json:"stopped"`
+}
+
+// WriterState is the state of a writer of a task. HeldBy is the stopped table which holds its position.
+type WriterState struct {
+	Writer   string             `json:"writer"`
+	Name     string             `json:"name"`
+	Position string             `json:"position"`
+	HeldBy   string             `json:"held_by,omitempty"`
+	Tables   []TablePolicyState `json:"tables"`
+}
+
+// TaskState is the runtime state of a task, it's exposed by the api.
+type TaskState struct {
+	TaskID        string             `json:"task_id"`
+	DumperRunning bool               `json:"dumper_running"`
+	CDCRunning    bool               `json:"cdc_running"`
+	Policy        model.WriterPolicy `json:"policy"`
+	Writers       []WriterState      `json:"writers"`
+}
+
+type policyStates struct {
+	mutex  sync.Mutex
+	tables map[string]*TablePolicyState
+}
+
+func (s *policyStates) get(table string, policy model.FailPolicy) *TablePolicyState {
+	if s.tables == nil {
+		s.tables = make(map[string]*TablePolicyState)
+	}
+	state, ok := s.tables[table]
+	if !ok {
+		state = &TablePolicyState{Table: table}
+		s.tables[table] = state
+	}
+	state.Policy = policy
+	return state
+}
+
+func (s *policyStates) update(table string, policy model.FailPolicy, fn func(state *TablePolicyState)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fn(s.get(table, policy))
+}
+
+// applyWithPolicy runs fn for events of a table on a writer and passes the result to done, failures are handled by
+// the fail policy of the table: retry is called again with backoff, then the events are skipped, or the error is
+// passed to stop the table. Skipped events are kept as dead letters, the events of a stopped table are read again
+// after a restart. Retries run in their own goroutine, so a failing table holds neither a worker of the shared
+// pool nor the flusher, and they end with the error when the task stops.
+func (s *Task) applyWithPolicy(w *taskWriter, table string, events []Event, fn func() error, retry func() error, done func(err error)) {
+	policy := s.state.Task.GetWriterPolicy().TableFailPolicy(table)
+	err := fn()
+	if err == nil || *policy.MaxRetries < 1 {
+		done(s.applyPolicy(w, table, events, policy, err))
+		return
+	}
+	go (func() {
+		backoff := time.Duration(policy.Backoff) * time.Millisecond
+		for i := 0; err != nil && i < *policy.MaxRetries; i++ {
+			s.recordFailure(w, table, policy, err, i+1)
+			s.logger.Error("failed to write table %s on %s, retry %d/%d after %s: %s", table, w.connector.Name, i+1, *policy.MaxRetries, backoff, err)
+			if !s.wait(backoff) {
+				s.logger.Error("stopped retrying table %s on %s, the task is stopping: %s", table, w.connector.Name, err)
+				done(err)
+				return
+			}
+			backoff *= 2
+			if backoff > maxFailBackoff {
+				backoff = maxFailBackoff
+			}
+			err = retry()
+		}
+		done(s.applyPolicy(w, table, events, policy, err))
+	})()
+}
+
+// applyPolicy handles the result of the last attempt, failed events are skipped, or the error is returned to stop the table.
+func (s *Task) applyPolicy(w *taskWriter, table string, events []Event, policy model.FailPolicy, err error) error {
+	if err == nil {
+		w.policyStates.update(table, policy, func(state *TablePolicyState) {
+			state.Retries = 0
+			state.Stopped = false
+		})
+		return nil
+	}
+	s.recordFailure(w, table, policy, err, *policy.MaxRetries)
+	if policy.OnFail == model.EventFailPolicySkip {
+		s.deadLetters(w.connector.ID, events, err, *policy.MaxRetries+1)
+		s.logger.Error("skipped %d events of table %s on %s: %s", len(events), table, w.connector.Name, err)
+		w.policyStates.update(table, policy, func(state *TablePolicyState) {
+			state.Retries = 0
+			state.Skipped += int64(len(events))
+		})
+		return nil
+	}
+	s.logger.Error("stopped writing table %s on %s: %s", table, w.connector.Name, err)
+	w.policyStates.update(table, policy, func(state *TablePolicyState) {
+		state.Stopped = true
+	})
+	return err
+}
+
+// wait sleeps for d, it returns false when the task stops first.
+func (s *Task) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stopping:
+		return false
+	}
+}
+
+// handleBatchError applies the fail policy of its table to a batch a writer could not commit. The batch is
+// retried by the writer, then dropped when it's skipped or the table is stopped, so the other tables keep
+// flushing. The policy is applied once, the writer returns the same error until the batch is dropped.
+func (s *Task) handleBatchError(w *taskWriter, failed *BatchError) {
+	failed.handled.Do(func() {
+		bw, ok := w.writer.(BatchWriter)
+		if !ok {
+			s.logger.Error("writer %s can not retry failed batches, stopped writing table %s: %s", w.connector.Name, failed.Table, failed.Err)
+			w.tableErrors.Store(failed.Table, failed.Err)
+			return
+		}
+		s.applyWithPolicy(w, failed.Table, failed.Events, func() error {
+			return failed.Err
+		}, func() error {
+			return bw.RetryBatch(failed.Table)
+		}, func(err error) {
+			if err != nil {
+				w.tableErrors.Store(failed.Table, err)
+			}
+			bw.DropBatch(failed.Table)
+		})
+	})
+}
+
+func (s *Task) recordFailure(w *taskWriter, table string, policy model.FailPolicy, err error, retries int) {
+	now := time.Now()
+	w.policyStates.update(table, policy, func(state *TablePolicyState) {
+		state.Retries = retries
+		state.LastError = err.Error()
+		state.LastErrorAt = &now
+	})
+}
+
+// State returns the acknowledged position and the fail policy state of the tables of every writer.
+func (s *Task) State() TaskState {
+	state := TaskState{
+		TaskID:        s.id,
+		DumperRunning: s.dumperRunning,
+		CDCRunning:    s.cdcRunning,
+	}
+	if s.state.Task == nil {
+		return state
+	}
+	state.Policy = s.state.Task.GetWriterPolicy()
+	for _, w := range s.writers {
+		state.Writers = append(state.Writers, w.state(s.tables.GetTables(), state.Policy))
+	}
+	return state
+}
+
+func (w *taskWriter) state(tables []model.TableDefine, policy model.WriterPolicy) WriterState {
+	state := WriterState{
+		Writer:   w.connector.ID,
+		Name:     w.connector.Name,
+		Position: w.acked(),
+		HeldBy:   w.held(),
+	}
+	w.policyStates.mutex.Lock()
+	defer w.policyStates.mutex.Unlock()
+	for _, table := range tables {
+		w.policyStates.get(table.SourceTable, policy.TableFailPolicy(table.SourceTable))
+	}
+	for _, t := range w.policyStates.tables {
+		state.Tables = append(state.Tables, *t)
+	}
+	sort.Slice(state.Tables, func(i, j int) bool {
+		return state.Tables[i].Table < state.Tables[j].Table
+	})
+	return state
+}
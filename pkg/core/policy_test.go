@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"testing"
+	"time"
+)
+
+func testPolicyTask(policy string) *Task {
+	return &Task{
+		logger:   NewFileLog("", LevelInfo),
+		state:    State{Task: &model.Task{WriterPolicy: policy}},
+		stopping: make(chan struct{}),
+	}
+}
+
+// applyWithPolicyResult applies the policy on fn, which fails failures times, and returns the result and attempts.
+func applyWithPolicyResult(t *testing.T, s *Task, w *taskWriter, failures int) (error, int) {
+	t.Helper()
+	attempts := 0
+	fn := func() error {
+		attempts++
+		if attempts <= failures {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		return nil
+	}
+	result := make(chan error, 1)
+	s.applyWithPolicy(w, "users", nil, fn, fn, func(err error) {
+		result <- err
+	})
+	select {
+	case err := <-result:
+		return err, attempts
+	case <-time.After(5 * time.Second):
+		t.Fatal("the policy did not finish")
+	}
+	return nil, 0
+}
+
+func TestApplyWithPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		failures int
+		err      bool
+		attempts int
+		state    TablePolicyState
+	}{
+		{"success", `{"max_retries":2,"backoff":1}`, 0, false, 1, TablePolicyState{}},
+		{"retried", `{"max_retries":2,"backoff":1}`, 2, false, 3, TablePolicyState{}},
+		{"retries exhausted", `{"max_retries":2,"backoff":1}`, 5, true, 3, TablePolicyState{Retries: 2, Stopped: true}},
+		{"stop", `{"on_fail":"stop","max_retries":0}`, 1, true, 1, TablePolicyState{Stopped: true}},
+		{"skip", `{"on_fail":"skip","max_retries":1,"backoff":1}`, 5, false, 2, TablePolicyState{}},
+		{"table policy", `{"on_fail":"skip","max_retries":0,"tables":{"users":{"on_fail":"stop"}}}`, 1, true, 1, TablePolicyState{Stopped: true}},
+	}
+	for _, c := range cases {
+		s := testPolicyTask(c.policy)
+		w := &taskWriter{connector: &model.Connector{Name: "writer"}}
+		err, attempts := applyWithPolicyResult(t, s, w, c.failures)
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v, want error %v", c.name, err, c.err)
+		}
+		if attempts != c.attempts {
+			t.Errorf("%s: %d attempts, want %d", c.name, attempts, c.attempts)
+		}
+		state := w.policyStates.tables["users"]
+		if state == nil {
+			t.Errorf("%s: no policy state", c.name)
+			continue
+		}
+		if state.Retries != c.state.Retries || state.Stopped != c.state.Stopped {
+			t.Errorf("%s: retries %d stopped %v, want retries %d stopped %v", c.name, state.Retries, state.Stopped, c.state.Retries, c.state.Stopped)
+		}
+		if c.failures > 0 && state.LastError == "" {
+			t.Errorf("%s: the last error is not recorded", c.name)
+		}
+	}
+}
+
+func TestApplyWithPolicyStopping(t *testing.T) {
+	s := testPolicyTask(`{"on_fail":"skip","max_retries":3,"backoff":60000}`)
+	w := &taskWriter{connector: &model.Connector{Name: "writer"}}
+	failed := errors.New("failed")
+	result := make(chan error, 1)
+	s.applyWithPolicy(w, "users", nil, func() error {
+		return failed
+	}, func() error {
+		return failed
+	}, func(err error) {
+		result <- err
+	})
+	// the retry waits in its own goroutine, the caller is not blocked.
+	close(s.stopping)
+	select {
+	case err := <-result:
+		if !errors.Is(err, failed) {
+			t.Fatalf("events should not be skipped when the task stops, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retry did not end when the task stopped")
+	}
+}
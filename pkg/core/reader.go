@@ -14,6 +14,21 @@ type ReaderOption struct {
 	Subscriber ReaderSubscriber
 	Logger     *FileLogger
 	Task       *model.Task
+	// Tables are the tables to read, the tables of Task when it's nil.
+	Tables TableSource
+}
+
+// TableSource returns the tables of a task, tasks with table patterns add tables while they run.
+type TableSource interface {
+	GetTables() []model.TableDefine
+}
+
+// GetTables returns the tables the reader reads.
+func (o *ReaderOption) GetTables() []model.TableDefine {
+	if o.Tables != nil {
+		return o.Tables.GetTables()
+	}
+	return o.Task.GetTables()
 }
 
 type ReaderPosition struct {
@@ -29,3 +44,23 @@ type Reader interface {
 	CurrentPosition() ReaderPosition
 	Release() error
 }
+
+// PositionComparer is implemented by readers whose positions can be ordered, tasks with several writers
+// use it to save the minimum position acknowledged by all of them.
+type PositionComparer interface {
+	// ComparePosition returns -1 when a is before b, 0 when they are equal and 1 when a is after b.
+	ComparePosition(a string, b string) int
+}
+
+// PositionSequencer is implemented by readers whose positions map to increasing integers, writers use
+// them to version rows, e.g. the sequence column of Doris.
+type PositionSequencer interface {
+	// PositionSequence returns the integer of a position, ok is false when it can not be parsed.
+	PositionSequence(pos string) (seq int64, ok bool)
+}
+
+// TableSubscriber is implemented by readers which subscribe to tables, tasks call AddTables with the
+// tables which started matching their patterns.
+type TableSubscriber interface {
+	AddTables(tables []string) error
+}
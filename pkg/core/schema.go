@@ -14,3 +14,15 @@ type SchemaManager interface {
 	Get(dbname string, tableName string) *schemas.Table
 	CreateTable(table *schemas.Table) error
 }
+
+// Reindexer is implemented by schema managers which can copy a table into a new version behind an alias,
+// Reindex returns the name of the new version.
+type Reindexer interface {
+	Reindex(table string) (string, error)
+}
+
+// TableLister is implemented by schema managers which can list the tables of a database,
+// tasks with table patterns resolve them against it.
+type TableLister interface {
+	ListTables(dbname string) ([]string, error)
+}
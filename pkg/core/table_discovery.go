@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"sync"
+	"time"
+)
+
+const tableDiscoveryInterval = time.Minute
+
+// pendingTable buffers the events of a table found while cdc runs, they're applied once the table
+// is migrated and snapshotted.
+type pendingTable struct {
+	mutex   sync.Mutex
+	events  []Event
+	done    bool
+	batches sync.WaitGroup
+}
+
+// buffer keeps the event until the table is ready, it returns false when it's ready.
+func (p *pendingTable) buffer(e Event) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.done {
+		return false
+	}
+	p.events = append(p.events, e)
+	return true
+}
+
+// listTables lists the tables of the reader database.
+func (s *Task) listTables(connector *model.Connector) ([]string, error) {
+	plugin, ok := GetPlugin(connector.Type)
+	if !ok {
+		return nil, fmt.Errorf("can not find plugin %s", connector.Type)
+	}
+	if plugin.SchemaFactory == nil {
+		return nil, fmt.Errorf("plugin %s have not supports schema protocol", connector.Type)
+	}
+	lister, ok := plugin.SchemaFactory(s.ctx, &SchemaOption{
+		Connector: connector,
+		Logger:    s.logger,
+	}).(TableLister)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s can not list tables", connector.Type)
+	}
+	return lister.ListTables(connector.Database)
+}
+
+// resolveTables returns the tables of the validated task, its patterns are resolved against the reader database.
+func (s *Task) resolveTables(task *model.Task, connector *model.Connector) ([]model.TableDefine, error) {
+	if !task.HasTablePatterns() {
+		return task.GetTables(), nil
+	}
+	names, err := s.listTables(connector)
+	if err != nil {
+		return nil, s.logger.Errorf("can not resolve table patterns of task %s, %s", task.Name, err)
+	}
+	tables := task.ResolveTables(names)
+	s.logger.Info("resolved %d tables of task %s", len(tables), task.Name)
+	return tables, nil
+}
+
+// discoverTables looks for new tables matching the patterns of the task until stop is closed.
+func (s *Task) discoverTables(readerPlugin Plugin, stop chan struct{}) {
+	ticker := time.NewTicker(tableDiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		names, err := s.listTables(s.state.Reader)
+		if err != nil {
+			s.logger.Error("can not discover tables of task %s, %s", s.state.Task.Name, err)
+			continue
+		}
+		for _, table := range s.state.Task.ResolveTables(names) {
+			if _, ok := s.tables.get(table.SourceTable); ok {
+				continue
+			}
+			if err := s.addTable(readerPlugin, table); err != nil {
+				s.logger.Error("can not add table %s, it will be retried, %s", table.SourceTable, err)
+			}
+		}
+	}
+}
+
+// addTable replicates a table which started matching the patterns of the task. Its events are buffered
+// while the reader subscribes to it and it's migrated and snapshotted, then they're applied.
+func (s *Task) addTable(readerPlugin Plugin, table model.TableDefine) error {
+	s.logger.Info("found new table %s", table.SourceTable)
+	pending := &pendingTable{}
+	s.pendingTables.Store(table.SourceTable, pending)
+	if err := s.tables.add(table); err != nil {
+		s.pendingTables.Delete(table.SourceTable)
+		return err
+	}
+	if err := s.prepareTable(readerPlugin, table); err != nil {
+		s.tables.remove(table.SourceTable)
+		s.pendingTables.Delete(table.SourceTable)
+		return err
+	}
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	for _, e := range pending.events {
+		if err := s.dispatch(e); err != nil {
+			s.logger.Error("can not apply buffered event of table %s, %s", table.SourceTable, err)
+		}
+	}
+	s.logger.Info("applied %d buffered events of table %s", len(pending.events), table.SourceTable)
+	pending.events = nil
+	pending.done = true
+	s.pendingTables.Delete(table.SourceTable)
+	return nil
+}
+
+func (s *Task) prepareTable(readerPlugin Plugin, table model.TableDefine) error {
+	if _, err := model.GetOrCreateTaskTable(s.state.Task.ID, table); err != nil {
+		return err
+	}
+	if subscriber, ok := s.reader.(TableSubscriber); ok {
+		if err := subscriber.AddTables([]string{table.SourceTable}); err != nil {
+			return err
+		}
+	}
+	if s.state.Task.MigrateEnabled {
+		if err := s.migrateTables(&readerPlugin, []model.TableDefine{table}); err != nil {
+			return err
+		}
+	}
+	if !s.state.Task.DumperEnabled || readerPlugin.DumperFactory == nil {
+		return nil
+	}
+	dumper := readerPlugin.DumperFactory(s.ctx, &DumperOption{
+		Task:       s.state.Task,
+		Connector:  s.state.Reader,
+		Subscriber: s,
+		Logger:     s.logger,
+		BatchSize:  1000,
+	})
+	if err := dumper.Prepare(); err != nil {
+		return err
+	}
+	defer dumper.Stop()
+	return s.startDumpTable(dumper, table)
+}
+
+// hasPendingTables reports whether tables found while cdc runs are not applied yet.
+func (s *Task) hasPendingTables() bool {
+	pending := false
+	s.pendingTables.Range(func(key, value interface{}) bool {
+		pending = true
+		return false
+	})
+	return pending
+}
+
+// waitDumpedBatches waits until the dumped batches of a table are written.
+func (s *Task) waitDumpedBatches(table string) {
+	if p, ok := s.pendingTables.Load(table); ok {
+		p.(*pendingTable).batches.Wait()
+		return
+	}
+	s.dumpedBatches.Wait()
+}
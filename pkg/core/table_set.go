@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/config"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"sync"
+)
+
+// tableSet holds the tables of a task with their mappings, filters and masks. Tables matching the
+// patterns of the task are added while it runs, so it's safe for concurrent use.
+type tableSet struct {
+	mutex    sync.RWMutex
+	database string
+	keys     config.Masking
+	tables   []model.TableDefine
+	mappings map[string]*tableMapping
+	filters  map[string]*tableFilter
+	masks    map[string]tableMasks
+}
+
+func newTableSet(tables []model.TableDefine, database string, keys config.Masking) (*tableSet, error) {
+	set := &tableSet{
+		database: database,
+		keys:     keys,
+		mappings: make(map[string]*tableMapping),
+		filters:  make(map[string]*tableFilter),
+		masks:    make(map[string]tableMasks),
+	}
+	for _, table := range tables {
+		if err := set.add(table); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// add parses the mapping, filter and masks of a table and adds it.
+func (s *tableSet) add(table model.TableDefine) error {
+	var mapping *tableMapping
+	var filter *tableFilter
+	var masks tableMasks
+	var err error
+	if table.Mapped() {
+		if mapping, err = newTableMapping(table, s.database); err != nil {
+			return fmt.Errorf("table %s: %w", table.SourceTable, err)
+		}
+	}
+	if table.Filter != "" {
+		if filter, err = newTableFilter(table.Filter); err != nil {
+			return fmt.Errorf("table %s: %w", table.SourceTable, err)
+		}
+	}
+	if len(table.Masks) > 0 {
+		if masks, err = newTableMasks(table.Masks, s.keys); err != nil {
+			return fmt.Errorf("table %s: %w", table.SourceTable, err)
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, t := range s.tables {
+		if t.SourceTable == table.SourceTable {
+			return nil
+		}
+	}
+	s.tables = append(s.tables, table)
+	if mapping != nil {
+		s.mappings[table.SourceTable] = mapping
+	}
+	if filter != nil {
+		s.filters[table.SourceTable] = filter
+	}
+	if masks != nil {
+		s.masks[table.SourceTable] = masks
+	}
+	return nil
+}
+
+func (s *tableSet) remove(table string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, t := range s.tables {
+		if t.SourceTable == table {
+			s.tables = append(s.tables[:i:i], s.tables[i+1:]...)
+			break
+		}
+	}
+	delete(s.mappings, table)
+	delete(s.filters, table)
+	delete(s.masks, table)
+}
+
+// GetTables returns the tables of the set, it makes the set a table source of readers.
+func (s *tableSet) GetTables() []model.TableDefine {
+	if s == nil {
+		return nil
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	tables := make([]model.TableDefine, len(s.tables))
+	copy(tables, s.tables)
+	return tables
+}
+
+func (s *tableSet) get(table string) (model.TableDefine, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, t := range s.tables {
+		if t.SourceTable == table {
+			return t, true
+		}
+	}
+	return model.TableDefine{}, false
+}
+
+func (s *tableSet) mapping(table string) *tableMapping {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.mappings[table]
+}
+
+func (s *tableSet) filter(table string) *tableFilter {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.filters[table]
+}
+
+func (s *tableSet) tableMasks(table string) tableMasks {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.masks[table]
+}
@@ -2,11 +2,15 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/imiskolee/anycdc/pkg/config"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/model"
 	"github.com/panjf2000/ants/v2"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,8 +27,10 @@ type taskLogState struct {
 
 type metric struct {
 	metrics sync.Map
-	mutex   sync.Mutex
-	task    *model.Task
+	// keys are the keys set by dumpers by table, they replace the primary keys of the last record.
+	keys  sync.Map
+	mutex sync.Mutex
+	task  *model.Task
 }
 
 func (m *metric) add(e *Event) {
@@ -54,7 +60,9 @@ func (m *metric) add(e *Event) {
 	default:
 	}
 
-	if e.SourceSchema.Name != "" {
+	if key, ok := m.keys.Load(e.SourceSchema.Name); ok {
+		tl.data.LastSyncedKeys = key
+	} else if e.SourceSchema.Name != "" {
 		pks := e.SourceSchema.GetPrimaryKeyNames()
 		lastSyncRecord := make(map[string]interface{})
 		for _, pk := range pks {
@@ -68,6 +76,21 @@ func (m *metric) add(e *Event) {
 	}
 	m.metrics.Store(e.SourceSchema.Name, tl)
 }
+
+// setKey replaces the last synced keys of a table, dumped rows which are counted later keep it.
+func (m *metric) setKey(table string, key interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.keys.Store(table, key)
+	met, ok := m.metrics.Load(table)
+	if !ok {
+		return
+	}
+	tl := met.(taskLogState)
+	tl.data.LastSyncedKeys = key
+	m.metrics.Store(table, tl)
+}
+
 func (m *metric) flush(mode string) {
 	m.mutex.Lock()
 	ms := make(map[string]taskLogState)
@@ -93,7 +116,7 @@ func (m *metric) flush(mode string) {
 
 type State struct {
 	Reader   *model.Connector
-	Writer   *model.Connector
+	Writers  []*model.Connector
 	Task     *model.Task
 	TaskLogs map[string]model.TaskTable
 }
@@ -101,10 +124,13 @@ type State struct {
 type Task struct {
 	id                string
 	state             State
-	tables            []model.TableDefine
+	tables            *tableSet
+	pendingTables     sync.Map
+	tablePatterns     bool
 	dumper            Dumper
 	reader            Reader
-	writer            Writer
+	writers           []*taskWriter
+	transformer       *Transformer
 	logger            *FileLogger
 	ctx               context.Context
 	dumpStartPosition string
@@ -112,9 +138,11 @@ type Task struct {
 	dumperRunning     bool
 	cdcRunning        bool
 	dumperWG          sync.WaitGroup
+	dumpedBatches     sync.WaitGroup
 	threadPool        *ants.Pool
-	tableErrors       sync.Map
 	lastSaveAt        time.Time
+	stopping          chan struct{}
+	stopOnce          sync.Once
 }
 
 func NewTask(id string) *Task {
@@ -133,6 +161,7 @@ func NewTask(id string) *Task {
 			task: &model.Task{Base: model.Base{ID: id}},
 		},
 		lastSaveAt: time.Now(),
+		stopping:   make(chan struct{}),
 	}
 }
 
@@ -141,26 +170,56 @@ func (s *Task) Prepare() error {
 	if err != nil {
 		return s.logger.Errorf("can not load task %s, %s", s.id, err)
 	}
+	if err := task.Validate(); err != nil {
+		return s.logger.Errorf("can not prepare task %s, %s", task.Name, err)
+	}
 	readerConnector, err := model.GetConnectorByID(task.Reader)
 	if err != nil {
 		return s.logger.Errorf("can not load reader connector %s, %s", task.Reader, err)
 	}
-	writerConnector, err := model.GetConnectorByID(task.Writer)
+	writers, err := task.GetWriters()
 	if err != nil {
-		return s.logger.Errorf("can not load writer connector %s, %s", task.Writer, err)
+		return s.logger.Errorf("can not parse writers of task %s, %s", task.Name, err)
+	}
+	var writerConnectors []*model.Connector
+	for _, id := range writers {
+		writerConnector, err := model.GetConnectorByID(id)
+		if err != nil {
+			return s.logger.Errorf("can not load writer connector %s, %s", id, err)
+		}
+		writerConnectors = append(writerConnectors, writerConnector)
+	}
+	if len(writerConnectors) < 1 {
+		return s.logger.Errorf("task %s has no writer", task.Name)
 	}
 	task.Preload()
+	if task.Transform != "" {
+		transformer, err := NewTransformer(task.Transform, time.Duration(task.TransformTimeout)*time.Millisecond)
+		if err != nil {
+			return s.logger.Errorf("can not compile transform of task %s, %s", task.Name, err)
+		}
+		s.transformer = transformer
+	}
 	s.state.Task = task
 	s.state.Reader = readerConnector
-	s.state.Writer = writerConnector
-	s.tables = task.GetTables()
+	s.state.Writers = writerConnectors
+	tables, err := s.resolveTables(task, readerConnector)
+	if err != nil {
+		return err
+	}
+	s.tablePatterns = task.HasTablePatterns()
+	s.tables, err = newTableSet(tables, readerConnector.Database, config.G.Masking)
+	if err != nil {
+		return s.logger.Errorf("can not parse tables of task %s, %s", task.Name, err)
+	}
+	s.checkMergedTables(tables)
 	return nil
 }
 
 func (s *Task) Start() error {
 	shouldDumper := false
 	if s.state.Task.DumperEnabled {
-		for _, table := range s.tables {
+		for _, table := range s.tables.GetTables() {
 			t, err := model.GetOrCreateTaskTable(s.state.Task.ID, table)
 			if err != nil {
 				continue
@@ -186,6 +245,10 @@ func (s *Task) Start() error {
 }
 
 func (s *Task) Stop() error {
+	// retries which wait end first, so the writers are flushed and saved without them.
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
 	if s.dumper != nil {
 		_ = s.stopDumper()
 	}
@@ -212,6 +275,7 @@ func (s *Task) startCDC() error {
 	_ = s.state.Task.UpdateCDCStatus(model.CDCStatusRunning)
 	success := false
 	defer (func() {
+		s.stopFlushers()
 		_ = s.Save()
 		s.cdcRunning = false
 		if success {
@@ -221,7 +285,6 @@ func (s *Task) startCDC() error {
 		}
 	})()
 	var readerPlugin Plugin
-	var writerPlugin Plugin
 	var ok bool
 	{
 		readerPlugin, ok = GetPlugin(s.state.Reader.Type)
@@ -236,26 +299,32 @@ func (s *Task) startCDC() error {
 			Subscriber: s,
 			Logger:     s.logger,
 			Task:       s.state.Task,
+			Tables:     s.tables,
 		})
 	}
-	{
-		writerPlugin, ok = GetPlugin(s.state.Writer.Type)
-		if !ok {
-			return s.logger.Errorf("can not find plugin:%s", s.state.Writer.Type)
-		}
-		if writerPlugin.WriterFactory == nil {
-			return s.logger.Errorf("plugin %s have not supports writer protocol", s.state.Writer.Type)
-		}
-		s.writer = writerPlugin.WriterFactory(s.ctx, &WriterOption{
-			Connector: s.state.Writer,
-			Logger:    s.logger,
-		})
+	writers, err := s.newWriters()
+	if err != nil {
+		return err
 	}
+	s.writers = writers
 	if err := s.reader.Prepare(); err != nil {
 		return s.logger.Errorf("can not prepare reader: %s", err)
 	}
-	if err := s.writer.Prepare(); err != nil {
-		return s.logger.Errorf("can not prepare writer: %s", err)
+	if err := s.prepareWriters(); err != nil {
+		return err
+	}
+	s.startFlushers()
+	if s.tablePatterns {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go (func() {
+			defer close(stopped)
+			s.discoverTables(readerPlugin, stop)
+		})()
+		defer (func() {
+			close(stop)
+			<-stopped
+		})()
 	}
 	if err := s.reader.Start(); err != nil {
 		return err
@@ -265,6 +334,7 @@ func (s *Task) startCDC() error {
 }
 
 func (s *Task) stopCDC() error {
+	s.stopFlushers()
 	_ = s.Save()
 	err := s.reader.Stop()
 	_ = s.state.Task.UpdateCDCStatus(model.CDCStatusStopped)
@@ -288,7 +358,6 @@ func (s *Task) startDumper() error {
 		s.dumperRunning = false
 	})()
 	var readerPlugin Plugin
-	var writerPlugin Plugin
 	var ok bool
 	{
 		readerPlugin, ok = GetPlugin(s.state.Reader.Type)
@@ -311,6 +380,7 @@ func (s *Task) startDumper() error {
 				Subscriber: s,
 				Logger:     s.logger,
 				Task:       s.state.Task,
+				Tables:     s.tables,
 			})
 			if err := reader.Prepare(); err != nil {
 				return s.logger.Errorf("can not prepare reader: %s", err)
@@ -327,23 +397,14 @@ func (s *Task) startDumper() error {
 		}
 		s.dumper = dumper
 	}
-	{
-		writerPlugin, ok = GetPlugin(s.state.Writer.Type)
-		if !ok {
-			return s.logger.Errorf("can not find plugin %s", s.state.Writer.Type)
-		}
-		if writerPlugin.WriterFactory == nil {
-			return s.logger.Errorf("plugin %s have not supports writer protocol", s.state.Writer.Type)
-		}
-		writer := writerPlugin.WriterFactory(s.ctx, &WriterOption{
-			Connector: s.state.Writer,
-			Logger:    s.logger,
-		})
-		s.writer = writer
+	writers, err := s.newWriters()
+	if err != nil {
+		return err
 	}
+	s.writers = writers
 
 	if s.state.Task.MigrateEnabled {
-		if err := s.migrateTables(&readerPlugin, &writerPlugin); err != nil {
+		if err := s.migrateTables(&readerPlugin, s.tables.GetTables()); err != nil {
 			return err
 		}
 	}
@@ -352,8 +413,8 @@ func (s *Task) startDumper() error {
 		return s.logger.Errorf("dumper prepare fail, %s", err)
 	}
 
-	if err := s.writer.Prepare(); err != nil {
-		return s.logger.Errorf("writer prepare fail, %s", err)
+	if err := s.prepareWriters(); err != nil {
+		return err
 	}
 	if err := s.startDumperTask(); err != nil {
 		return s.logger.Errorf("dumper start fail, %s", err)
@@ -370,62 +431,181 @@ func (s *Task) stopDumper() error {
 	return err
 }
 
+// DumperKey keeps the key the dumper resumes a table from, it replaces the primary keys of the last dumped record.
+func (s *Task) DumperKey(table string, key interface{}) {
+	s.metric.setKey(table, key)
+}
+
 func (s *Task) DumperEvent(sch *schemas.Table, records []EventRecord) error {
 	if len(records) < 1 {
 		return nil
 	}
-	err, ok := s.tableErrors.Load(sch.Name)
-	if ok && err != nil {
-		return err.(error)
+	if err := s.tableError(sch.Name); err != nil {
+		return err
+	}
+	var events []Event
+	for _, r := range records {
+		events = append(events, Event{
+			Type:                 EventTypeInsert,
+			Record:               r,
+			SourceSchema:         *sch,
+			DestinationTableName: s.getDestinationTable(sch.Name),
+		})
+	}
+	dumped := events
+	events, err := s.transform(sch.Name, events)
+	if err != nil {
+		return err
+	}
+	// transformed events may be routed to other tables, every destination is a batch.
+	var destinations []string
+	batches := make(map[string][]Event)
+	for _, e := range events {
+		if _, ok := batches[e.DestinationTableName]; !ok {
+			destinations = append(destinations, e.DestinationTableName)
+		}
+		batches[e.DestinationTableName] = append(batches[e.DestinationTableName], e)
+	}
+	// snapshots of tables found while cdc runs share the pool with cdc events, their batches are counted.
+	var pending *pendingTable
+	if p, ok := s.pendingTables.Load(sch.Name); ok {
+		pending = p.(*pendingTable)
+	}
+	// the dumped records are counted once every batch is written, the batches are held until they're all submitted.
+	count := &dumpedBatch{}
+	count.remaining.Add(1)
+	defer count.release(s, dumped)
+	for _, w := range s.writers {
+		if w.tableError(sch.Name) != nil {
+			continue
+		}
+		for _, dest := range destinations {
+			batch := batches[dest]
+			s.dumpedBatches.Add(1)
+			count.remaining.Add(1)
+			if pending != nil {
+				pending.batches.Add(1)
+			}
+			finished := func(err error) {
+				count.finish(s, dumped, err)
+				if pending != nil {
+					pending.batches.Done()
+				}
+				s.dumpedBatches.Done()
+			}
+			if err := s.threadPool.Submit(s.runDumperEvent(w, &batch[0].SourceSchema, batch, finished)); err != nil {
+				finished(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dumpedBatch counts the batches of dumped records on every writer.
+type dumpedBatch struct {
+	remaining atomic.Int64
+	written   atomic.Bool
+	failed    atomic.Bool
+}
+
+// finish finishes a batch, err is nil when it's written.
+func (b *dumpedBatch) finish(s *Task, dumped []Event, err error) {
+	if err != nil {
+		b.failed.Store(true)
+	} else {
+		b.written.Store(true)
+	}
+	b.release(s, dumped)
+}
+
+// release drops a reference, the dumped records are counted after the last one when a batch is written and none failed.
+func (b *dumpedBatch) release(s *Task, dumped []Event) {
+	if b.remaining.Add(-1) > 0 || !b.written.Load() || b.failed.Load() {
+		return
+	}
+	for _, e := range dumped {
+		s.metric.add(&e)
 	}
-	return s.threadPool.Submit(s.runDumperEvent(sch, records))
 }
 
-func (s *Task) runDumperEvent(sch *schemas.Table, records []EventRecord) func() {
+// runDumperEvent writes a dumped batch, finished is called when it's written, skipped or its table is stopped.
+func (s *Task) runDumperEvent(w *taskWriter, sch *schemas.Table, events []Event, finished func(err error)) func() {
 	return func() {
-		var events []Event
-		for _, r := range records {
-			events = append(events, Event{
-				Type:                 EventTypeInsert,
-				Record:               r,
-				SourceSchema:         *sch,
-				DestinationTableName: s.getDestinationTable(sch.Name),
-			})
-		}
-		if err := s.writer.ExecuteBatch(sch, events); err != nil {
-			s.tableErrors.Store(sch.Name, err)
-			return
-		}
-		s.tableErrors.Delete(sch.Name)
-		for _, record := range records {
-			var e Event
-			e.Type = EventTypeInsert
-			e.DestinationTableName = s.getDestinationTable(sch.Name)
-			e.Record = record
-			e.SourceSchema = *sch
-			s.metric.add(&e)
+		execute := func() error {
+			return w.writer.ExecuteBatch(sch, events)
 		}
+		s.applyWithPolicy(w, sch.Name, events, execute, execute, func(err error) {
+			defer finished(err)
+			if err != nil {
+				w.tableErrors.Store(sch.Name, err)
+				return
+			}
+			w.tableErrors.Delete(sch.Name)
+		})
 	}
 }
 
+// ReaderEvent applies the event on every writer which has not stopped the table,
+// the reader gets an error only when the table is stopped on all of them.
 func (s *Task) ReaderEvent(e Event) error {
-	err, ok := s.tableErrors.Load(e.SourceSchema.Name)
-	if ok && err != nil {
-		return err.(error)
+	if p, ok := s.pendingTables.Load(e.SourceSchema.Name); ok && p.(*pendingTable).buffer(e) {
+		return nil
 	}
-	return s.threadPool.Submit(s.runTask(e))
+	if _, ok := s.tables.get(e.SourceSchema.Name); !ok && s.tablePatterns {
+		// the table is not replicated yet, it's snapshotted when it's found.
+		return nil
+	}
+	return s.dispatch(e)
 }
 
-func (s *Task) runTask(e Event) func() {
+func (s *Task) dispatch(e Event) error {
+	if err := s.tableError(e.SourceSchema.Name); err != nil {
+		return err
+	}
+	s.metric.add(&e)
+	e.DestinationTableName = s.getDestinationTable(e.SourceSchema.Name)
+	events, err := s.transform(e.SourceSchema.Name, []Event{e})
+	if err != nil {
+		return err
+	}
+	for _, w := range s.writers {
+		if w.tableError(e.SourceSchema.Name) != nil {
+			continue
+		}
+		for _, ev := range events {
+			tracked := w.inflight.add(ev.LastPOS)
+			if err := s.threadPool.Submit(s.runTask(w, ev, tracked)); err != nil {
+				w.inflight.done(tracked)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Task) runTask(w *taskWriter, e Event, tracked *inflightEvent) func() {
 	return func() {
-		s.metric.add(&e)
-		e.DestinationTableName = s.getDestinationTable(e.SourceSchema.Name)
-		err := s.writer.Execute(e)
-		if err != nil {
-			s.tableErrors.Store(e.SourceSchema.Name, err)
-		} else {
-			s.tableErrors.Delete(e.SourceSchema.Name)
+		var failed *BatchError
+		execute := func() error {
+			err := w.writer.Execute(e)
+			// the event is buffered, the batch which failed is handled with the policy of its own table.
+			if errors.As(err, &failed) {
+				return nil
+			}
+			return err
 		}
+		s.applyWithPolicy(w, e.SourceSchema.Name, []Event{e}, execute, execute, func(err error) {
+			defer w.inflight.done(tracked)
+			if err != nil {
+				w.tableErrors.Store(e.SourceSchema.Name, err)
+			} else {
+				w.tableErrors.Delete(e.SourceSchema.Name)
+			}
+			if failed != nil {
+				s.handleBatchError(w, failed)
+			}
+		})
 	}
 }
 
@@ -436,7 +616,9 @@ func (s *Task) Save() error {
 		return nil
 	}
 	if s.cdcRunning {
+		// the reader position is read before the writers are flushed, so it only covers committed events.
 		currentPosition := s.reader.CurrentPosition()
+		currentPosition.Position = s.ackedPosition(currentPosition.Position)
 		if currentPosition.Position != s.state.Task.LastCDCPosition {
 			s.summary()
 			s.state.Task.LastCDCPosition = currentPosition.Position
@@ -456,45 +638,92 @@ func (s *Task) Save() error {
 	return nil
 }
 
-func (s *Task) migrateTables(readerPlugin *Plugin, writerPlugin *Plugin) error {
+func (s *Task) migrateTables(readerPlugin *Plugin, tables []model.TableDefine) error {
 	s.logger.Info("starting migrating tables")
-	var readerSchManager SchemaManager
-	var writerSchManager SchemaManager
 	if readerPlugin.SchemaFactory == nil {
 		return s.logger.Errorf("can not find reader schema factory for %s", readerPlugin.Name)
 	}
-	if writerPlugin.SchemaFactory == nil {
-		return s.logger.Errorf("can not find writer schema factory for %s", writerPlugin.Name)
-	}
-
-	readerSchManager = readerPlugin.SchemaFactory(context.Background(), &SchemaOption{
+	readerSchManager := readerPlugin.SchemaFactory(context.Background(), &SchemaOption{
 		Connector: s.state.Reader,
 		Logger:    s.logger,
 	})
-	writerSchManager = writerPlugin.SchemaFactory(context.Background(), &SchemaOption{
-		Connector: s.state.Writer,
-		Logger:    s.logger,
-	})
-
-	for _, table := range s.tables {
-		if err := s.migrateTable(readerSchManager, writerSchManager, table); err != nil {
-			return err
+	for _, w := range s.writers {
+		writerPlugin, _ := GetPlugin(w.connector.Type)
+		if writerPlugin.SchemaFactory == nil {
+			return s.logger.Errorf("can not find writer schema factory for %s", writerPlugin.Name)
+		}
+		writerSchManager := writerPlugin.SchemaFactory(context.Background(), &SchemaOption{
+			Connector: w.connector,
+			Logger:    s.logger,
+		})
+		migrated := make(map[string]bool)
+		for _, table := range tables {
+			// merged tables share their destination, it's migrated from the first of them.
+			if migrated[table.DestinationTable] {
+				continue
+			}
+			migrated[table.DestinationTable] = true
+			if err := s.migrateTable(readerSchManager, writerSchManager, w.connector, table); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func (s *Task) migrateTable(readerSchManager SchemaManager, writerSchManager SchemaManager, table model.TableDefine) error {
+func (s *Task) migrateTable(readerSchManager SchemaManager, writerSchManager SchemaManager, writer *model.Connector, table model.TableDefine) error {
 	readerTableSchema := readerSchManager.Get(s.state.Reader.Database, table.SourceTable)
-	writerTableSchema := writerSchManager.Get(s.state.Writer.Database, table.DestinationTable)
+	writerTableSchema := writerSchManager.Get(writer.Database, table.DestinationTable)
+	writerPolicy := s.state.Task.GetWriterPolicy()
+	policy := writerPolicy.TableApplyPolicy(table.SourceTable)
 	if writerTableSchema != nil && len(writerTableSchema.Columns) > 0 {
+		if policy.SoftDelete() && !writerTableSchema.Exists(policy.SoftDeleteColumn) {
+			s.logger.Error("table %s has no soft delete column %s, deletes will be applied as normal", table.DestinationTable, policy.SoftDeleteColumn)
+		}
+		if writerPolicy.MetadataColumns && !writerTableSchema.Exists(MetaOpColumn) {
+			s.logger.Error("table %s has no metadata columns, they will not be written", table.DestinationTable)
+		}
+		if m := s.tables.mapping(table.SourceTable); m != nil {
+			for _, col := range m.addedColumns() {
+				if !writerTableSchema.Exists(col) {
+					s.logger.Error("table %s has no mapped column %s, it will not be written", table.DestinationTable, col)
+				}
+			}
+		}
+		if col, ok := writerTableSchema.GetFieldByName(table.Shard); ok && !col.IsPrimaryKey {
+			s.logger.Error("shard column %s is not a primary key of table %s, keys of merged tables may collide", table.Shard, table.DestinationTable)
+		}
+		if policy.History() && !writerTableSchema.Exists(HistoryIDColumn) {
+			s.logger.Error("table %s is not a history table, it has no %s column", table.DestinationTable, HistoryIDColumn)
+		}
 		s.logger.Info("skip migrate table %s, because of already exists on writer connection", table.DestinationTable)
 		return nil
 	}
+	if m := s.tables.mapping(table.SourceTable); m != nil {
+		mapped := m.schema(*readerTableSchema)
+		readerTableSchema = &mapped
+	}
+	if m := s.tables.tableMasks(table.SourceTable); m != nil {
+		masked := m.schema(*readerTableSchema)
+		readerTableSchema = &masked
+	}
 	if len(readerTableSchema.GetPrimaryKeys()) < 1 {
 		return s.logger.Errorf("can not find primary key for table %s", table.SourceTable)
 	}
 	readerTableSchema.Name = table.DestinationTable
+	if policy.History() {
+		readerTableSchema = HistorySchema(readerTableSchema)
+	}
+	if policy.SoftDelete() && !readerTableSchema.Exists(policy.SoftDeleteColumn) {
+		readerTableSchema.Columns = append(readerTableSchema.Columns, softDeleteColumn(policy))
+	}
+	if writerPolicy.MetadataColumns {
+		for _, col := range MetadataColumns() {
+			if !readerTableSchema.Exists(col.Name) {
+				readerTableSchema.Columns = append(readerTableSchema.Columns, col)
+			}
+		}
+	}
 	if err := writerSchManager.CreateTable(readerTableSchema); err != nil {
 		return s.logger.Errorf("can not migrate table %s, %s", table.SourceTable, err)
 	}
@@ -503,14 +732,14 @@ func (s *Task) migrateTable(readerSchManager SchemaManager, writerSchManager Sch
 }
 
 func (s *Task) startDumperTask() error {
-	tables := s.tables
+	tables := s.tables.GetTables()
 	s.logger.Info("start dumper task on task %s, tables=%+v", s.state.Task.Name, tables)
 	var globalErr error
 	for _, table := range tables {
 		s.dumperWG.Add(1)
 		go (func() {
 			defer s.dumperWG.Done()
-			if err := s.startDumpTable(table); err != nil {
+			if err := s.startDumpTable(s.dumper, table); err != nil {
 				globalErr = err
 				s.logger.Error("failed to run dump task on table %s,%s", table, err)
 			}
@@ -522,9 +751,9 @@ func (s *Task) startDumperTask() error {
 	return globalErr
 }
 
-func (s *Task) startDumpTable(table model.TableDefine) error {
+func (s *Task) startDumpTable(dumper Dumper, table model.TableDefine) error {
 	var err error
-	if s.dumper == nil {
+	if dumper == nil {
 		return nil
 	}
 	s.logger.Info("starting dump table %s", table.SourceTable)
@@ -550,16 +779,12 @@ func (s *Task) startDumpTable(table model.TableDefine) error {
 			taskTable.UpdateDumperState(model.DumperStateCompleted)
 		}
 	})()
-	if err = s.dumper.StartDumpTable(taskTable); err != nil {
+	if err = dumper.StartDumpTable(taskTable); err != nil {
 		return err
 	}
-	for {
-		if s.threadPool.Running() > 0 {
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		break
-	}
+	s.waitDumpedBatches(table.SourceTable)
+	// rows counted after the dump, e.g. by cdc, keep their own primary keys.
+	s.metric.keys.Delete(table.SourceTable)
 	return nil
 }
 
@@ -581,10 +806,40 @@ func (s *Task) Release() error {
 }
 
 func (s *Task) getDestinationTable(sourceTableName string) string {
-	for _, t := range s.tables {
-		if t.SourceTable == sourceTableName {
-			return t.DestinationTable
-		}
+	if t, ok := s.tables.get(sourceTableName); ok {
+		return t.DestinationTable
 	}
 	return sourceTableName
 }
+
+// checkMergedTables warns about tables merged into one destination without a shard column.
+func (s *Task) checkMergedTables(tables []model.TableDefine) {
+	sources := make(map[string][]string)
+	sharded := make(map[string]bool)
+	for _, table := range tables {
+		sources[table.DestinationTable] = append(sources[table.DestinationTable], table.SourceTable)
+		if table.Shard == "" {
+			sharded[table.DestinationTable] = false
+		} else if _, ok := sharded[table.DestinationTable]; !ok {
+			sharded[table.DestinationTable] = true
+		}
+	}
+	for dest, names := range sources {
+		if len(names) > 1 && !sharded[dest] {
+			s.logger.Error("tables %s are merged into %s without a shard column, their keys may collide", strings.Join(names, ","), dest)
+		}
+	}
+}
+
+// softDeleteColumn describes the soft delete column added to migrated tables.
+func softDeleteColumn(policy model.ApplyPolicy) schemas.Column {
+	col := schemas.Column{
+		Name:     policy.SoftDeleteColumn,
+		DataType: schemas.TypeTimestamp,
+		Nullable: true,
+	}
+	if policy.SoftDeleteType == model.SoftDeleteTypeBool {
+		col.DataType = schemas.TypeBool
+	}
+	return col
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"errors"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"sync"
+	"time"
+)
+
+// taskWriter is one destination of a task. Writers apply events on their own, a table stopped on one
+// writer does not block the others, and every writer acknowledges the position it has applied.
+type taskWriter struct {
+	connector    *model.Connector
+	writer       Writer
+	flusher      *flusher
+	inflight     inflight
+	tableErrors  sync.Map
+	policyStates policyStates
+	// flushMutex serializes the flushes of the writer.
+	flushMutex sync.Mutex
+	posMutex   sync.Mutex
+	position   string
+	heldBy     string
+}
+
+// newWriters creates the writers of the task, they are not prepared.
+func (s *Task) newWriters() ([]*taskWriter, error) {
+	var writers []*taskWriter
+	for _, connector := range s.state.Writers {
+		plugin, ok := GetPlugin(connector.Type)
+		if !ok {
+			return nil, s.logger.Errorf("can not find plugin:%s", connector.Type)
+		}
+		if plugin.WriterFactory == nil {
+			return nil, s.logger.Errorf("plugin %s have not supports writer protocol", connector.Type)
+		}
+		writers = append(writers, &taskWriter{
+			connector: connector,
+			writer: plugin.WriterFactory(s.ctx, &WriterOption{
+				Connector: connector,
+				Logger:    s.logger,
+				Task:      s.state.Task,
+				Reader:    s.reader,
+			}),
+			position: s.state.Task.LastCDCPosition,
+		})
+	}
+	return writers, nil
+}
+
+func (s *Task) prepareWriters() error {
+	for _, w := range s.writers {
+		if err := w.writer.Prepare(); err != nil {
+			return s.logger.Errorf("can not prepare writer %s: %s", w.connector.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Task) startFlushers() {
+	for _, w := range s.writers {
+		w.flusher = newFlusher(func() error {
+			return s.flushWriter(w)
+		}, s.logger, time.Duration(s.state.Task.FlushInterval)*time.Second)
+		w.flusher.start()
+	}
+}
+
+func (s *Task) stopFlushers() {
+	for _, w := range s.writers {
+		if w.flusher == nil {
+			continue
+		}
+		if err := w.flusher.stop(); err != nil {
+			s.logger.Error("can not flush writer %s: %s", w.connector.Name, err)
+		}
+	}
+}
+
+// flushWriter commits the buffered events of a writer, batches which fail are handled by the fail policy
+// of their tables before the remaining ones are committed.
+func (s *Task) flushWriter(w *taskWriter) error {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+	return s.flushBuffered(w)
+}
+
+// flushBuffered flushes a writer, the caller holds its flush lock.
+func (s *Task) flushBuffered(w *taskWriter) error {
+	err := w.writer.Flush()
+	var failed *BatchError
+	for errors.As(err, &failed) {
+		handled := failed
+		s.handleBatchError(w, failed)
+		// a batch which is retried, or which the writer keeps after it's handled, is returned as it is.
+		if err = w.writer.Flush(); errors.As(err, &failed) && failed == handled {
+			return err
+		}
+	}
+	return err
+}
+
+// tableError returns the error which stopped a table on every writer, nil when a writer still accepts it.
+func (s *Task) tableError(table string) error {
+	var last error
+	for _, w := range s.writers {
+		err := w.tableError(table)
+		if err == nil {
+			return nil
+		}
+		last = err
+	}
+	return last
+}
+
+func (w *taskWriter) tableError(table string) error {
+	if err, ok := w.tableErrors.Load(table); ok && err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+// stoppedTable returns a stopped table of the writer, "" when none is stopped.
+func (w *taskWriter) stoppedTable() string {
+	var table string
+	w.tableErrors.Range(func(key, value interface{}) bool {
+		table = key.(string)
+		return false
+	})
+	return table
+}
+
+// ack acknowledges the position the writer has committed up to, "" keeps its position. A writer with stopped
+// tables keeps its position, so the events are read again after a restart. The task saves the minimum position
+// of its writers, so a stopped table holds the saved position of every writer until it's resumed.
+func (w *taskWriter) ack(position string) string {
+	w.posMutex.Lock()
+	defer w.posMutex.Unlock()
+	if table := w.stoppedTable(); table != "" {
+		w.heldBy = table
+		return w.position
+	}
+	w.heldBy = ""
+	if position != "" {
+		w.position = position
+	}
+	return w.position
+}
+
+// ackWriter flushes a writer and acknowledges the position it has committed up to, current is the position of
+// the reader and "" keeps the position of the writer. Events in flight are read before the flush, the position
+// does not pass them, and buffered events are committed by the flush.
+func (s *Task) ackWriter(w *taskWriter, current string, comparer PositionComparer) string {
+	limit, limited := w.inflight.limit(comparer)
+	if err := s.flushWriter(w); err != nil {
+		s.logger.Error("can not flush writer %s before saving position: %s", w.connector.Name, err)
+		return w.ack("")
+	}
+	if current != "" && limited && (limit == "" || comparer.ComparePosition(limit, current) < 0) {
+		return w.ack(limit)
+	}
+	return w.ack(current)
+}
+
+func (w *taskWriter) acked() string {
+	w.posMutex.Lock()
+	defer w.posMutex.Unlock()
+	return w.position
+}
+
+// held returns the stopped table which holds the position of the writer.
+func (w *taskWriter) held() string {
+	w.posMutex.Lock()
+	defer w.posMutex.Unlock()
+	return w.heldBy
+}
+
+// ackedPosition returns the minimum position acknowledged by the writers. Positions of readers which
+// can not compare them only advance when all writers agree.
+func (s *Task) ackedPosition(current string) string {
+	// events of tables found while cdc runs are buffered until they're snapshotted, the writers keep their
+	// positions. They're checked first, the buffered events are in flight before the table is no longer pending.
+	if s.hasPendingTables() {
+		current = ""
+	}
+	var position string
+	comparer, ok := s.reader.(PositionComparer)
+	for i, w := range s.writers {
+		held := w.held()
+		pos := s.ackWriter(w, current, comparer)
+		if table := w.held(); table != "" && table != held {
+			s.logger.Error("position of writer %s is held at %s, table %s is stopped, the task position does not advance until it's resumed", w.connector.Name, pos, table)
+		}
+		if i == 0 || pos == position {
+			position = pos
+			continue
+		}
+		if !ok {
+			position = s.state.Task.LastCDCPosition
+			continue
+		}
+		if pos == "" || comparer.ComparePosition(pos, position) < 0 {
+			position = pos
+		}
+	}
+	if position == "" {
+		return s.state.Task.LastCDCPosition
+	}
+	return position
+}
@@ -0,0 +1,440 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dop251/goja"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTransformTimeout = 200 * time.Millisecond
+	transformMaxCallStack   = 1024
+	transformMaxEvents      = 1000
+)
+
+var defaultTypes = types.NewDefaultTypeMap()
+
+// Transformer runs the JavaScript UDF of a task on events. The script defines `transform(event)`, or
+// per table functions in `tables`, e.g. `var tables = {orders: function(event) {...}}`, which win over
+// transform. The event is {op, table, destination, position, record, old_record}, the function may
+// modify it in place, return it or another event, return null or false to drop it, or an array to
+// split it into several events.
+//
+// Every call is interrupted after the timeout and the call stack of scripts is limited. Memory is not
+// limited: goja allocates on the Go heap without accounting per runtime, and the allocation counters of
+// Go are process wide, so scripts are trusted not to build huge values within the timeout.
+// Runtimes are reused, scripts should not keep state in globals.
+type Transformer struct {
+	program  *goja.Program
+	timeout  time.Duration
+	runtimes sync.Pool
+}
+
+func NewTransformer(script string, timeout time.Duration) (*Transformer, error) {
+	program, err := goja.Compile("transform.js", script, true)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultTransformTimeout
+	}
+	t := &Transformer{
+		program: program,
+		timeout: timeout,
+	}
+	vm, err := t.newRuntime()
+	if err != nil {
+		return nil, err
+	}
+	_, isTransform := goja.AssertFunction(vm.Get("transform"))
+	tables := vm.Get("tables")
+	if !isTransform && (tables == nil || goja.IsUndefined(tables) || goja.IsNull(tables)) {
+		return nil, errors.New("script defines neither transform nor tables")
+	}
+	t.runtimes.Put(vm)
+	return t, nil
+}
+
+func (t *Transformer) newRuntime() (*goja.Runtime, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(transformMaxCallStack)
+	if err := t.guard(vm, func() error {
+		_, err := vm.RunProgram(t.program)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// guard runs fn and interrupts the runtime when it exceeds the timeout. The watchdog is stopped before
+// guard returns and an interrupt it raised after fn completed is cleared, so the runtime can be reused.
+func (t *Transformer) guard(vm *goja.Runtime, fn func() error) error {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go (func() {
+		defer close(stopped)
+		timer := time.NewTimer(t.timeout)
+		defer timer.Stop()
+		select {
+		case <-stop:
+		case <-timer.C:
+			vm.Interrupt(fmt.Sprintf("transform timeout after %s", t.timeout))
+		}
+	})()
+	err := fn()
+	close(stop)
+	<-stopped
+	vm.ClearInterrupt()
+	return err
+}
+
+// Transform returns the events the script makes of e, events of tables without a function are kept.
+func (t *Transformer) Transform(e Event) ([]Event, error) {
+	var vm *goja.Runtime
+	if v := t.runtimes.Get(); v != nil {
+		vm = v.(*goja.Runtime)
+	} else {
+		var err error
+		if vm, err = t.newRuntime(); err != nil {
+			return nil, err
+		}
+	}
+	fn, ok := t.function(vm, e.SourceSchema.Name)
+	if !ok {
+		t.runtimes.Put(vm)
+		return []Event{e}, nil
+	}
+	var events []Event
+	err := t.guard(vm, func() error {
+		obj := eventToJS(vm, e)
+		res, err := fn(goja.Undefined(), obj)
+		if err != nil {
+			return err
+		}
+		events, err = eventsFromJS(vm, res, obj, e)
+		return err
+	})
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		// an interrupted runtime may be left in any state, it's not reused.
+		return nil, err
+	}
+	t.runtimes.Put(vm)
+	return events, err
+}
+
+func (t *Transformer) function(vm *goja.Runtime, table string) (goja.Callable, bool) {
+	if tables, ok := vm.Get("tables").(*goja.Object); ok {
+		if fn, ok := goja.AssertFunction(tables.Get(table)); ok {
+			return fn, true
+		}
+	}
+	return goja.AssertFunction(vm.Get("transform"))
+}
+
+func eventToJS(vm *goja.Runtime, e Event) *goja.Object {
+	obj := vm.NewObject()
+	_ = obj.Set("op", eventTypeNames[e.Type])
+	_ = obj.Set("table", e.SourceSchema.Name)
+	_ = obj.Set("destination", e.DestinationTableName)
+	_ = obj.Set("position", e.LastPOS)
+	_ = obj.Set("record", recordToJS(vm, e.Record))
+	if e.OldRecord != nil {
+		_ = obj.Set("old_record", recordToJS(vm, *e.OldRecord))
+	} else {
+		_ = obj.Set("old_record", goja.Null())
+	}
+	return obj
+}
+
+func recordToJS(vm *goja.Runtime, record EventRecord) *goja.Object {
+	obj := vm.NewObject()
+	for _, col := range record.Columns {
+		_ = obj.Set(col.Name, valueToJS(vm, col.Value.V))
+	}
+	return obj
+}
+
+func valueToJS(vm *goja.Runtime, v interface{}) goja.Value {
+	switch v := v.(type) {
+	case nil:
+		return goja.Null()
+	case time.Time:
+		date, err := vm.New(vm.Get("Date"), vm.ToValue(v.UnixMilli()))
+		if err == nil {
+			return date
+		}
+	}
+	return vm.ToValue(v)
+}
+
+func eventsFromJS(vm *goja.Runtime, res goja.Value, obj *goja.Object, origin Event) ([]Event, error) {
+	if res == nil || goja.IsUndefined(res) {
+		res = obj
+	}
+	if goja.IsNull(res) || (res.ExportType() != nil && res.ExportType().Kind() == reflect.Bool && !res.ToBoolean()) {
+		return nil, nil
+	}
+	o, ok := res.(*goja.Object)
+	if !ok {
+		return nil, fmt.Errorf("transform returned %s, an event, an array of events, null or false is expected", res.String())
+	}
+	if o.ClassName() != "Array" {
+		e, err := eventFromJS(o, origin)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{e}, nil
+	}
+	length := int(o.Get("length").ToInteger())
+	if length > transformMaxEvents {
+		return nil, fmt.Errorf("transform returned %d events, the limit is %d", length, transformMaxEvents)
+	}
+	events := make([]Event, 0, length)
+	for i := 0; i < length; i++ {
+		item, ok := o.Get(strconv.Itoa(i)).(*goja.Object)
+		if !ok {
+			return nil, fmt.Errorf("transform returned an invalid event at %d", i)
+		}
+		e, err := eventFromJS(item, origin)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func eventFromJS(obj *goja.Object, origin Event) (Event, error) {
+	e := Event{
+		Type:                 origin.Type,
+		SourceSchema:         origin.SourceSchema,
+		DestinationTableName: origin.DestinationTableName,
+		LastPOS:              origin.LastPOS,
+		CommitTime:           origin.CommitTime,
+	}
+	if op := obj.Get("op"); op != nil && !goja.IsUndefined(op) {
+		t, ok := eventTypeByName(op.String())
+		if !ok {
+			return e, fmt.Errorf("unknown op %s", op.String())
+		}
+		e.Type = t
+	}
+	if dest := obj.Get("destination"); dest != nil && !goja.IsUndefined(dest) && !goja.IsNull(dest) && dest.String() != "" {
+		e.DestinationTableName = dest.String()
+	}
+	record, ok := obj.Get("record").(*goja.Object)
+	if !ok {
+		return e, errors.New("event has no record")
+	}
+	e.Record = recordFromJS(record, origin.Record)
+	if old, ok := obj.Get("old_record").(*goja.Object); ok {
+		var origOld EventRecord
+		if origin.OldRecord != nil {
+			origOld = *origin.OldRecord
+		}
+		r := recordFromJS(old, origOld)
+		e.OldRecord = &r
+	}
+	return e, nil
+}
+
+// recordFromJS converts a record back, unchanged values keep their original data, changed values are
+// encoded as the type of the original column, new columns take the type of the JavaScript value.
+func recordFromJS(obj *goja.Object, origin EventRecord) EventRecord {
+	var record EventRecord
+	for _, key := range obj.Keys() {
+		v := obj.Get(key).Export()
+		if orig, err := origin.FieldByName(key); err == nil {
+			if sameValue(orig.Value.V, v) {
+				record.Set(key, orig.Value)
+				continue
+			}
+			if orig.Value.T != schemas.TypeNull && orig.Value.T != schemas.TypeUnknown {
+				if td, err := defaultTypes.Encode(orig.Value.T, v); err == nil {
+					record.Set(key, td)
+					continue
+				}
+			}
+		}
+		record.Set(key, types.NewTypedData(inferType(v), v))
+	}
+	return record
+}
+
+// sameValue compares a value with its exported value, dates are compared in milliseconds
+// because JavaScript dates have no finer precision.
+func sameValue(orig interface{}, v interface{}) bool {
+	if t, ok := orig.(time.Time); ok {
+		if vt, ok := v.(time.Time); ok {
+			return t.UnixMilli() == vt.UnixMilli()
+		}
+		return false
+	}
+	return reflect.DeepEqual(orig, v)
+}
+
+func inferType(v interface{}) schemas.Type {
+	switch v.(type) {
+	case nil:
+		return schemas.TypeNull
+	case bool:
+		return schemas.TypeBool
+	case int64, int, int32:
+		return schemas.TypeInt
+	case float64, float32:
+		return schemas.TypeDecimal
+	case string:
+		return schemas.TypeString
+	case time.Time:
+		return schemas.TypeTimestamp
+	case []byte:
+		return schemas.TypeBlob
+	case map[string]interface{}, []interface{}:
+		return schemas.TypeJSON
+	}
+	return schemas.TypeUnknown
+}
+
+func eventTypeByName(name string) (EventType, bool) {
+	for t, n := range eventTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return EventTypeUnknown, false
+}
+
+// transform filters, maps, runs the transformer of the task and masks events of a table. Events which
+// can not be transformed are kept as dead letters and skipped with the skip fail policy, otherwise the
+// error stops the table.
+func (s *Task) transform(table string, events []Event) ([]Event, error) {
+	if s.transformer == nil && s.tables.mapping(table) == nil && s.tables.filter(table) == nil && s.tables.tableMasks(table) == nil {
+		return events, nil
+	}
+	var transformed []Event
+	for _, e := range events {
+		res, err := s.transformEvent(e)
+		if err != nil {
+			if s.state.Task.GetWriterPolicy().TableFailPolicy(table).OnFail != model.EventFailPolicySkip {
+				return nil, s.logger.Errorf("can not transform event of table %s: %s", table, err)
+			}
+			s.logger.Error("skipped event of table %s, can not transform it: %s", table, err)
+			s.deadLetters("", []Event{e}, err, 1)
+			continue
+		}
+		transformed = append(transformed, res...)
+	}
+	return transformed, nil
+}
+
+// transformEvent filters and maps an event, runs the transformer on it and masks the results,
+// the source event is kept by dead letters.
+func (s *Task) transformEvent(e Event) ([]Event, error) {
+	table := e.SourceSchema.Name
+	if f := s.tables.filter(table); f != nil {
+		filtered, keep, err := f.event(e)
+		if err != nil || !keep {
+			return nil, err
+		}
+		e = filtered
+	}
+	if m := s.tables.mapping(table); m != nil {
+		mapped, err := m.event(e)
+		if err != nil {
+			return nil, err
+		}
+		e = mapped
+	}
+	events := []Event{e}
+	if s.transformer != nil {
+		var err error
+		if events, err = s.transformer.Transform(e); err != nil {
+			return nil, err
+		}
+	}
+	if m := s.tables.tableMasks(table); m != nil {
+		for i, ev := range events {
+			masked, err := m.event(ev)
+			if err != nil {
+				return nil, err
+			}
+			events[i] = masked
+		}
+	}
+	return events, nil
+}
+
+// TransformSample is an event in the form scripts see it, it's used to test scripts on sample events.
+type TransformSample struct {
+	Op          string                 `json:"op"`
+	Table       string                 `json:"table"`
+	Destination string                 `json:"destination"`
+	Position    string                 `json:"position"`
+	Record      map[string]interface{} `json:"record"`
+	OldRecord   map[string]interface{} `json:"old_record"`
+}
+
+// TransformSample runs the script on a sample event and returns the events it makes.
+func (t *Transformer) TransformSample(sample TransformSample) ([]TransformSample, error) {
+	e := Event{
+		Record:               sampleRecord(sample.Record),
+		SourceSchema:         schemas.Table{Name: sample.Table},
+		DestinationTableName: sample.Destination,
+		LastPOS:              sample.Position,
+	}
+	if e.DestinationTableName == "" {
+		e.DestinationTableName = sample.Table
+	}
+	var ok bool
+	if e.Type, ok = eventTypeByName(sample.Op); !ok {
+		return nil, fmt.Errorf("unknown op %s", sample.Op)
+	}
+	if sample.OldRecord != nil {
+		old := sampleRecord(sample.OldRecord)
+		e.OldRecord = &old
+	}
+	events, err := t.Transform(e)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]TransformSample, 0, len(events))
+	for _, ev := range events {
+		s := TransformSample{
+			Op:          eventTypeNames[ev.Type],
+			Table:       ev.SourceSchema.Name,
+			Destination: ev.DestinationTableName,
+			Position:    ev.LastPOS,
+			Record:      sampleValues(ev.Record),
+		}
+		if ev.OldRecord != nil {
+			s.OldRecord = sampleValues(*ev.OldRecord)
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+func sampleRecord(values map[string]interface{}) EventRecord {
+	var record EventRecord
+	for k, v := range values {
+		record.Set(k, types.NewTypedData(inferType(v), v))
+	}
+	return record
+}
+
+func sampleValues(record EventRecord) map[string]interface{} {
+	values := make(map[string]interface{}, len(record.Columns))
+	for _, col := range record.Columns {
+		values[col.Name] = col.Value.V
+	}
+	return values
+}
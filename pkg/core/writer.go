@@ -1,17 +1,58 @@
 package core
 
 import (
+	"fmt"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/model"
+	"sync"
 )
 
 type WriterOption struct {
 	Connector *model.Connector
 	Logger    *FileLogger
+	Task      *model.Task
+	// Reader is the reader of the task, nil for dumps. Writers may use the optional position
+	// interfaces it implements, such as PositionSequencer.
+	Reader Reader
 }
 
 type Writer interface {
 	Prepare() error
 	Execute(e Event) error
 	ExecuteBatch(sourceSchema *schemas.Table, records []Event) error
+	// Flush commits the events buffered before it's called, writers without buffers return nil.
+	Flush() error
+}
+
+// BatchError is returned by a BatchWriter when a buffered batch of a table can not be committed. The writer
+// keeps the batch and returns the same error until the task retries or drops it, events are still buffered.
+type BatchError struct {
+	Table   string
+	Events  []Event
+	Err     error
+	handled sync.Once
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("can not commit %d buffered events of table %s: %s", len(e.Events), e.Table, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchWriter is implemented by writers which return a BatchError, the failed batch is handled by
+// the fail policy of its table.
+type BatchWriter interface {
+	// RetryBatch commits the failed batch of a table again.
+	RetryBatch(table string) error
+	// DropBatch drops the failed batch of a table after it's skipped or the table is stopped,
+	// so the other tables are committed. It's a no-op when the batch is committed.
+	DropBatch(table string)
+}
+
+// WriterReleaser is implemented by writers which hold connections of their own, they're released once
+// the writer is no longer used.
+type WriterReleaser interface {
+	Release() error
 }
@@ -49,5 +49,5 @@ func DB() *gorm.DB {
 }
 
 func ApplyMigration() {
-	_ = DB().AutoMigrate(&Connector{}, &Task{}, &TaskTable{})
+	_ = DB().AutoMigrate(&Connector{}, &Task{}, &TaskTable{}, &DeadLetter{}, &StreamLoadLabel{})
 }
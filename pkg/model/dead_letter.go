@@ -0,0 +1,50 @@
+package model
+
+// DeadLetter is an event the writer could not apply, it's kept until it's replayed or discarded.
+type DeadLetter struct {
+	Base
+	TaskID           string `gorm:"column:task_id;type:varchar(255);index" json:"task_id"`
+	Writer           string `gorm:"column:writer;type:varchar(255)" json:"writer"`
+	Table            string `gorm:"column:table;type:varchar(255)" json:"table"`
+	DestinationTable string `gorm:"column:destination_table;type:varchar(255)" json:"destination_table"`
+	Operation        string `gorm:"column:operation;type:varchar(255)" json:"operation"`
+	Schema           string `gorm:"column:schema;type:text" json:"schema"`
+	Record           string `gorm:"column:record;type:text" json:"record"`
+	OldRecord        string `gorm:"column:old_record;type:text" json:"old_record"`
+	Position         string `gorm:"column:position;type:varchar(255)" json:"position"`
+	Error            string `gorm:"column:error;type:text" json:"error"`
+	Attempts         int    `gorm:"column:attempts;type:int" json:"attempts"`
+}
+
+func (s *DeadLetter) TableName() string {
+	return "dead_letters"
+}
+
+func CreateDeadLetters(letters []DeadLetter) error {
+	return DB().CreateInBatches(letters, 100).Error
+}
+
+func GetDeadLetters(taskID string, offset int, limit int) ([]DeadLetter, int64, error) {
+	var letters []DeadLetter
+	var total int64
+	query := DB().Model(&DeadLetter{}).Where("task_id = ?", taskID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&letters).Error; err != nil {
+		return nil, 0, err
+	}
+	return letters, total, nil
+}
+
+func GetDeadLetter(taskID string, id string) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := DB().Where("task_id = ? AND id = ?", taskID, id).First(&letter).Error; err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func DeleteDeadLetter(letter *DeadLetter) error {
+	return DB().Delete(letter).Error
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StreamLoadLabel is the latest stream load of a source table on a writer. It's saved before the load
+// starts and marked as committed after it, so a load interrupted by a crash is retried with its label.
+type StreamLoadLabel struct {
+	Base
+	TaskID    string `gorm:"column:task_id;type:varchar(255);index" json:"task_id"`
+	Writer    string `gorm:"column:writer;type:varchar(255)" json:"writer"`
+	Table     string `gorm:"column:table;type:varchar(255)" json:"table"`
+	Label     string `gorm:"column:label;type:varchar(255)" json:"label"`
+	FirstPOS  string `gorm:"column:first_pos;type:varchar(255)" json:"first_pos"`
+	LastPOS   string `gorm:"column:last_pos;type:varchar(255)" json:"last_pos"`
+	Count     int    `gorm:"column:count;type:int" json:"count"`
+	Committed bool   `gorm:"column:committed;type:bool" json:"committed"`
+}
+
+func (s *StreamLoadLabel) TableName() string {
+	return "stream_load_labels"
+}
+
+// GetStreamLoadLabel returns the latest load of a table on a writer, nil when it has none.
+func GetStreamLoadLabel(taskID string, writer string, table string) (*StreamLoadLabel, error) {
+	var label StreamLoadLabel
+	err := DB().Where(`task_id = ? AND writer = ? AND "table" = ?`, taskID, writer, table).First(&label).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &label, nil
+}
+
+// SaveStreamLoadLabel replaces the latest load of a table on a writer.
+func SaveStreamLoadLabel(label *StreamLoadLabel) error {
+	if label.ID == "" {
+		label.ID = uuid.New().String()
+		return DB().Create(label).Error
+	}
+	return DB().Save(label).Error
+}
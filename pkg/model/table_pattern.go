@@ -0,0 +1,92 @@
+package model
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// TablePatternRegexPrefix starts a regular expression, e.g. re:^audit_\d{6}$.
+	TablePatternRegexPrefix = "re:"
+	// TablePatternExcludePrefix starts an exclusion, e.g. !orders_tmp_*.
+	TablePatternExcludePrefix = "!"
+	// TablePatternTable is replaced by the source table in destinations of patterns, e.g. archive_{table}.
+	TablePatternTable = "{table}"
+)
+
+var tablePatterns sync.Map
+
+// IsPattern reports whether the source of the table is a wildcard pattern such as orders_*
+// or a regular expression.
+func (s TableDefine) IsPattern() bool {
+	if s.IsExclusion() {
+		return false
+	}
+	return strings.HasPrefix(s.SourceTable, TablePatternRegexPrefix) || strings.ContainsAny(s.SourceTable, "*?[")
+}
+
+// IsExclusion reports whether the table excludes the tables its pattern matches.
+func (s TableDefine) IsExclusion() bool {
+	return strings.HasPrefix(s.SourceTable, TablePatternExcludePrefix)
+}
+
+// Match reports whether the source of the table, a name or a pattern, matches a table name.
+func (s TableDefine) Match(name string) bool {
+	return matchPattern(strings.TrimPrefix(s.SourceTable, TablePatternExcludePrefix), name)
+}
+
+func matchPattern(pattern string, name string) bool {
+	if strings.HasPrefix(pattern, TablePatternRegexPrefix) {
+		re, ok := tablePatterns.Load(pattern)
+		if !ok {
+			compiled, err := regexp.Compile(strings.TrimPrefix(pattern, TablePatternRegexPrefix))
+			if err != nil {
+				return false
+			}
+			re, _ = tablePatterns.LoadOrStore(pattern, compiled)
+		}
+		return re.(*regexp.Regexp).MatchString(name)
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+	return pattern == name
+}
+
+// ValidateTablePattern returns the error of an invalid pattern.
+func ValidateTablePattern(pattern string) error {
+	pattern = strings.TrimPrefix(pattern, TablePatternExcludePrefix)
+	if strings.HasPrefix(pattern, TablePatternRegexPrefix) {
+		_, err := regexp.Compile(strings.TrimPrefix(pattern, TablePatternRegexPrefix))
+		return err
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// matchTable returns the define of the first pattern matching name, the source of the define is the
+// name and {table} in its destination is replaced by the name.
+func matchTable(defines []TableDefine, name string) (TableDefine, bool) {
+	for _, define := range defines {
+		if define.IsExclusion() && define.Match(name) {
+			return TableDefine{}, false
+		}
+	}
+	for _, define := range defines {
+		if !define.IsPattern() || !define.Match(name) {
+			continue
+		}
+		table := define
+		table.SourceTable = name
+		if define.DestinationTable == "" || define.DestinationTable == define.SourceTable {
+			table.DestinationTable = name
+		} else {
+			table.DestinationTable = strings.ReplaceAll(define.DestinationTable, TablePatternTable, name)
+		}
+		return table, true
+	}
+	return TableDefine{}, false
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -10,9 +12,28 @@ type TaskMetric struct {
 	LastSyncPosition string
 }
 
+// TableDefine maps a source table onto its destination. Columns are referenced by their source names,
+// Types maps destination columns onto types such as bigint, varchar(64) or decimal(10,2), and Computed
+// holds expressions such as concat(first,' ',last). Filter is a condition such as region = 'EU',
+// rows which do not match it are not replicated. Masks maps destination columns onto masks such as
+// hash, redact, partial, tokenize or encrypt. Shard names a column added to the primary key which holds
+// the database and table of each row, tables merged into one destination keep their keys apart with it.
 type TableDefine struct {
-	SourceTable      string
-	DestinationTable string
+	SourceTable      string                 `json:"source"`
+	DestinationTable string                 `json:"destination"`
+	Rename           map[string]string      `json:"rename,omitempty"`
+	Exclude          []string               `json:"exclude,omitempty"`
+	Types            map[string]string      `json:"types,omitempty"`
+	Constants        map[string]interface{} `json:"constants,omitempty"`
+	Computed         map[string]string      `json:"computed,omitempty"`
+	Filter           string                 `json:"filter,omitempty"`
+	Masks            map[string]string      `json:"masks,omitempty"`
+	Shard            string                 `json:"shard,omitempty"`
+}
+
+// Mapped reports whether the table changes the columns of the source table.
+func (s TableDefine) Mapped() bool {
+	return len(s.Rename) > 0 || len(s.Exclude) > 0 || len(s.Types) > 0 || len(s.Constants) > 0 || len(s.Computed) > 0 || s.Shard != ""
 }
 
 const (
@@ -45,37 +66,59 @@ const (
 
 type Task struct {
 	Base
-	Name            string     `gorm:"column:name;type:varchar(255)" json:"name"`
-	Reader          string     `gorm:"column:reader;type:varchar(255)" json:"reader"`
-	Tables          string     `gorm:"column:tables;type:text" json:"tables"`
-	Writer          string     `gorm:"column:writer;type:text" json:"writer"`
-	Extras          string     `gorm:"column:extras;type:text" json:"extras"`
-	WriterPolicy    string     `gorm:"column:writer_policy;type:varchar(255)" json:"writer_policy"`
-	DumperEnabled   bool       `gorm:"column:dumper_enabled;type:bool" json:"dumper_enabled"`
-	MigrateEnabled  bool       `gorm:"column:migrate_enabled;type:bool" json:"migrate_enabled"`
-	CDCEnabled      bool       `gorm:"column:cdc_enabled;type:bool" json:"cdc_enabled"`
-	CDCStatus       string     `gorm:"column:cdc_status;type:varchar(255)" json:"cdc_status"`
-	DebugEnabled    bool       `gorm:"column:debug_enabled;type:bool" json:"debug_enabled"`
-	Message         string     `gorm:"column:message;type:text" json:"message"`
-	LogMode         string     `gorm:"column:log_mode;type:varchar(255)" json:"log_mode"`
-	BatchSize       int        `gorm:"column:batch_size;type:int" json:"batch_size"`
-	LastCDCPosition string     `gorm:"column:last_cdc_position;type:varchar(255)" json:"last_cdc_position"`
-	LastCDCAt       *time.Time `gorm:"column:last_cdc_at;type:timestamp" json:"last_cdc_at"`
-	LastStarted     *time.Time `gorm:"column:last_started;type:timestamp" json:"last_started"`
-	CDCDelayTime    int        `gorm:"column:cdc_delay_time;type:int" json:"cdc_delay_time"`
-	ThreadNumber    int        `gorm:"column:thread_number;type:int" json:"thread_number"`
-	Status          string     `gorm:"column:status;type:varchar(255)" json:"status"`
+	Name             string     `gorm:"column:name;type:varchar(255)" json:"name"`
+	Reader           string     `gorm:"column:reader;type:varchar(255)" json:"reader"`
+	Tables           string     `gorm:"column:tables;type:text" json:"tables"`
+	Writer           string     `gorm:"column:writer;type:text" json:"writer"`
+	Extras           string     `gorm:"column:extras;type:text" json:"extras"`
+	WriterPolicy     string     `gorm:"column:writer_policy;type:text" json:"writer_policy"`
+	DumperEnabled    bool       `gorm:"column:dumper_enabled;type:bool" json:"dumper_enabled"`
+	MigrateEnabled   bool       `gorm:"column:migrate_enabled;type:bool" json:"migrate_enabled"`
+	CDCEnabled       bool       `gorm:"column:cdc_enabled;type:bool" json:"cdc_enabled"`
+	CDCStatus        string     `gorm:"column:cdc_status;type:varchar(255)" json:"cdc_status"`
+	DebugEnabled     bool       `gorm:"column:debug_enabled;type:bool" json:"debug_enabled"`
+	Message          string     `gorm:"column:message;type:text" json:"message"`
+	LogMode          string     `gorm:"column:log_mode;type:varchar(255)" json:"log_mode"`
+	BatchSize        int        `gorm:"column:batch_size;type:int" json:"batch_size"`
+	CDCBatchSize     int        `gorm:"column:cdc_batch_size;type:int" json:"cdc_batch_size"`
+	LastCDCPosition  string     `gorm:"column:last_cdc_position;type:varchar(255)" json:"last_cdc_position"`
+	LastCDCAt        *time.Time `gorm:"column:last_cdc_at;type:timestamp" json:"last_cdc_at"`
+	LastStarted      *time.Time `gorm:"column:last_started;type:timestamp" json:"last_started"`
+	CDCDelayTime     int        `gorm:"column:cdc_delay_time;type:int" json:"cdc_delay_time"`
+	FlushInterval    int        `gorm:"column:flush_interval;type:int" json:"flush_interval"`
+	ThreadNumber     int        `gorm:"column:thread_number;type:int" json:"thread_number"`
+	Transform        string     `gorm:"column:transform;type:text" json:"transform"`
+	TransformTimeout int        `gorm:"column:transform_timeout;type:int" json:"transform_timeout"`
+	Status           string     `gorm:"column:status;type:varchar(255)" json:"status"`
 }
 
 func (s *Task) TableName() string {
 	return "tasks"
 }
 
-func (s *Task) GetTables() []TableDefine {
-	tables := strings.Split(s.Tables, ",")
+// GetTableDefines returns the table defines of the task, including patterns. Tables is a json array of
+// table defines or a comma separated list of source:destination tables.
+func (s *Task) GetTableDefines() ([]TableDefine, error) {
 	var defines []TableDefine
+	if strings.HasPrefix(strings.TrimSpace(s.Tables), "[") {
+		if err := json.Unmarshal([]byte(s.Tables), &defines); err != nil {
+			return nil, err
+		}
+		for i := range defines {
+			if defines[i].DestinationTable == "" {
+				defines[i].DestinationTable = defines[i].SourceTable
+			}
+		}
+		return defines, nil
+	}
+	tables := strings.Split(s.Tables, ",")
 	for _, table := range tables {
 		table = strings.TrimSpace(table)
+		// regular expressions may contain colons, they have no destination.
+		if strings.HasPrefix(table, TablePatternRegexPrefix) || strings.HasPrefix(table, TablePatternExcludePrefix) {
+			defines = append(defines, TableDefine{SourceTable: table, DestinationTable: table})
+			continue
+		}
 		ts := strings.Split(table, ":")
 		source := ts[0]
 		destination := ts[0]
@@ -87,9 +130,109 @@ func (s *Task) GetTables() []TableDefine {
 			DestinationTable: destination,
 		})
 	}
+	return defines, nil
+}
+
+// tableDefines returns the table defines of a task checked by Validate, tasks are validated when they're
+// saved and before they're started, so the tables of a malformed task are empty.
+func (s *Task) tableDefines() []TableDefine {
+	defines, err := s.GetTableDefines()
+	if err != nil {
+		return nil
+	}
 	return defines
 }
 
+// GetTables returns the tables the task names, tables matching its patterns are resolved with ResolveTables.
+func (s *Task) GetTables() []TableDefine {
+	return s.ResolveTables(nil)
+}
+
+// ResolveTables returns the tables the task names and the tables of names which match its patterns
+// and none of its exclusions.
+func (s *Task) ResolveTables(names []string) []TableDefine {
+	defines := s.tableDefines()
+	var tables []TableDefine
+	seen := make(map[string]bool)
+	for _, define := range defines {
+		if define.IsPattern() || define.IsExclusion() {
+			continue
+		}
+		tables = append(tables, define)
+		seen[define.SourceTable] = true
+	}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		if table, ok := matchTable(defines, name); ok {
+			tables = append(tables, table)
+			seen[name] = true
+		}
+	}
+	return tables
+}
+
+// HasTablePatterns reports whether the tables of the task are resolved from the source catalog.
+func (s *Task) HasTablePatterns() bool {
+	for _, define := range s.tableDefines() {
+		if define.IsPattern() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Task) GetTable(source string) (TableDefine, bool) {
+	defines := s.tableDefines()
+	for _, table := range defines {
+		if table.SourceTable == source && !table.IsPattern() && !table.IsExclusion() {
+			return table, true
+		}
+	}
+	return matchTable(defines, source)
+}
+
+// GetWriters returns the writer connector ids, Writer is a json array of ids or a single id.
+func (s *Task) GetWriters() ([]string, error) {
+	var writers []string
+	if strings.HasPrefix(strings.TrimSpace(s.Writer), "[") {
+		if err := json.Unmarshal([]byte(s.Writer), &writers); err != nil {
+			return nil, err
+		}
+		return writers, nil
+	}
+	for _, w := range strings.Split(s.Writer, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			writers = append(writers, w)
+		}
+	}
+	return writers, nil
+}
+
+// Validate returns the error of malformed tables, writers or writer policy of the task.
+func (s *Task) Validate() error {
+	defines, err := s.GetTableDefines()
+	if err != nil {
+		return fmt.Errorf("invalid tables: %w", err)
+	}
+	for _, define := range defines {
+		if !define.IsPattern() && !define.IsExclusion() {
+			continue
+		}
+		if err := ValidateTablePattern(define.SourceTable); err != nil {
+			return fmt.Errorf("invalid table pattern %s: %w", define.SourceTable, err)
+		}
+	}
+	if _, err := s.GetWriters(); err != nil {
+		return fmt.Errorf("invalid writer: %w", err)
+	}
+	if _, err := s.ParseWriterPolicy(); err != nil {
+		return fmt.Errorf("invalid writer policy: %w", err)
+	}
+	return nil
+}
+
 func (s *Task) Preload() {
 	tables := s.GetTables()
 	for _, table := range tables {
@@ -1 +1,151 @@
 package model
+
+import "encoding/json"
+
+// conflict behaviors of WriterPolicy.OnConflict.
+const (
+	WriterOnConflictUpsert  = "upsert"
+	WriterOnConflictReplace = "replace"
+	WriterOnConflictIgnore  = "ignore"
+)
+
+// apply modes of ApplyPolicy.ApplyMode.
+const (
+	ApplyModeNormal     = "normal"
+	ApplyModeSoftDelete = "soft_delete"
+	ApplyModeHistory    = "history"
+)
+
+// column types of ApplyPolicy.SoftDeleteType.
+const (
+	SoftDeleteTypeTimestamp = "timestamp"
+	SoftDeleteTypeBool      = "bool"
+)
+
+const (
+	defaultSoftDeleteColumn = "deleted_at"
+	defaultFailMaxRetries   = 3
+	defaultFailBackoff      = 1000
+)
+
+// FailPolicy decides what happens to an event the writer can not apply. Failed events are retried
+// MaxRetries times with an exponential backoff starting at Backoff milliseconds, then they are skipped
+// with EventFailPolicySkip, or the table is stopped.
+type FailPolicy struct {
+	OnFail     string `json:"on_fail,omitempty"`
+	MaxRetries *int   `json:"max_retries,omitempty"`
+	Backoff    int    `json:"backoff,omitempty"`
+}
+
+// ApplyPolicy decides how events are applied on the destination. With soft_delete, deletes set
+// SoftDeleteColumn (the delete time, or true with the bool type) instead of removing rows. With history,
+// every event is appended as a new version of the row.
+type ApplyPolicy struct {
+	ApplyMode        string `json:"apply_mode,omitempty"`
+	SoftDeleteColumn string `json:"soft_delete_column,omitempty"`
+	SoftDeleteType   string `json:"soft_delete_type,omitempty"`
+}
+
+type TablePolicy struct {
+	FailPolicy
+	ApplyPolicy
+}
+
+// WriterPolicy is the json stored in Task.WriterPolicy.
+type WriterPolicy struct {
+	OnConflict string `json:"on_conflict,omitempty"`
+	FailPolicy
+	ApplyPolicy
+	// MetadataColumns appends the _cdc_* columns (operation, source commit time, position, sync time
+	// and task name) to every written row.
+	MetadataColumns bool `json:"metadata_columns,omitempty"`
+	// Tables overrides the policies by source table name.
+	Tables map[string]TablePolicy `json:"tables,omitempty"`
+}
+
+// ParseWriterPolicy returns the writer policy of the task with defaults, or the error of a malformed policy.
+func (s *Task) ParseWriterPolicy() (WriterPolicy, error) {
+	var policy WriterPolicy
+	if s.WriterPolicy != "" {
+		if err := json.Unmarshal([]byte(s.WriterPolicy), &policy); err != nil {
+			return WriterPolicy{OnConflict: WriterOnConflictUpsert}, err
+		}
+	}
+	if policy.OnConflict == "" {
+		policy.OnConflict = WriterOnConflictUpsert
+	}
+	return policy, nil
+}
+
+// GetWriterPolicy returns the writer policy of a task checked by Validate, tasks are validated when
+// they're saved and before they're started, so a malformed policy gives the defaults.
+func (s *Task) GetWriterPolicy() WriterPolicy {
+	policy, _ := s.ParseWriterPolicy()
+	return policy
+}
+
+// TableFailPolicy returns the fail policy of a table with defaults, fields set on the table override the task.
+func (s WriterPolicy) TableFailPolicy(table string) FailPolicy {
+	policy := s.FailPolicy
+	if t, ok := s.Tables[table]; ok {
+		if t.OnFail != "" {
+			policy.OnFail = t.OnFail
+		}
+		if t.MaxRetries != nil {
+			policy.MaxRetries = t.MaxRetries
+		}
+		if t.Backoff > 0 {
+			policy.Backoff = t.Backoff
+		}
+	}
+	if policy.OnFail == "" {
+		policy.OnFail = EventFailPolicyRetry
+	}
+	if policy.MaxRetries == nil {
+		retries := 0
+		if policy.OnFail == EventFailPolicyRetry {
+			retries = defaultFailMaxRetries
+		}
+		policy.MaxRetries = &retries
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultFailBackoff
+	}
+	return policy
+}
+
+// TableApplyPolicy returns the apply policy of a table with defaults, fields set on the table override the task.
+func (s WriterPolicy) TableApplyPolicy(table string) ApplyPolicy {
+	policy := s.ApplyPolicy
+	if t, ok := s.Tables[table]; ok {
+		if t.ApplyMode != "" {
+			policy.ApplyMode = t.ApplyMode
+		}
+		if t.SoftDeleteColumn != "" {
+			policy.SoftDeleteColumn = t.SoftDeleteColumn
+		}
+		if t.SoftDeleteType != "" {
+			policy.SoftDeleteType = t.SoftDeleteType
+		}
+	}
+	if policy.ApplyMode == "" {
+		policy.ApplyMode = ApplyModeNormal
+	}
+	if policy.SoftDeleteColumn == "" {
+		policy.SoftDeleteColumn = defaultSoftDeleteColumn
+	}
+	if policy.SoftDeleteType == "" {
+		policy.SoftDeleteType = SoftDeleteTypeTimestamp
+	}
+	return policy
+}
+
+// SoftDelete reports whether deletes are applied as updates of the soft delete column.
+func (s ApplyPolicy) SoftDelete() bool {
+	return s.ApplyMode == ApplyModeSoftDelete
+}
+
+// History reports whether events are appended as versioned rows.
+func (s ApplyPolicy) History() bool {
+	return s.ApplyMode == ApplyModeHistory
+}
@@ -0,0 +1,131 @@
+package common_sql
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"strings"
+	"time"
+)
+
+// BatchFlushInterval is the max age of buffered cdc events before they are applied.
+const BatchFlushInterval = time.Second
+
+// maxBatchParams keeps a batch statement under the placeholder limits of mysql and postgres.
+const maxBatchParams = 60000
+
+// CompactEvents keeps only the latest event of every primary key,
+// events are returned in the order of their latest occurrence.
+func CompactEvents(sch *schemas.Table, events []core.Event) []core.Event {
+	pks := sch.GetPrimaryKeyNames()
+	latest := make(map[string]int, len(events))
+	keys := make([]string, len(events))
+	for i, e := range events {
+		keys[i] = primaryKey(pks, e.Record)
+		latest[keys[i]] = i
+	}
+	compacted := make([]core.Event, 0, len(latest))
+	for i, e := range events {
+		if latest[keys[i]] == i {
+			compacted = append(compacted, e)
+		}
+	}
+	return compacted
+}
+
+func primaryKey(pks []string, record core.EventRecord) string {
+	var keys []string
+	for _, pk := range pks {
+		f, err := record.FieldByName(pk)
+		if err != nil {
+			keys = append(keys, "")
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%v", f.Value.V))
+	}
+	return strings.Join(keys, "\x00")
+}
+
+// SplitRecords splits records into chunks which fit in one statement.
+func SplitRecords(records []core.EventRecord, columns int) [][]core.EventRecord {
+	size := len(records)
+	if columns > 0 && size*columns > maxBatchParams {
+		size = maxBatchParams / columns
+	}
+	var chunks [][]core.EventRecord
+	for start := 0; start < len(records); start += size {
+		end := start + size
+		if end > len(records) {
+			end = len(records)
+		}
+		chunks = append(chunks, records[start:end])
+	}
+	return chunks
+}
+
+// BatchDelete generates a DELETE ... WHERE pk IN (...) statement for records.
+func BatchDelete(connector *model.Connector, sch *schemas.Table, typeMap *types.Map, records []core.EventRecord) (string, []interface{}, error) {
+	where, values, err := primaryKeyIn(connector, sch, typeMap, records)
+	if err != nil {
+		return "", nil, err
+	}
+	q := sqlQuotes[connector.Type]
+	rawSQL := fmt.Sprintf("DELETE FROM %s%s%s WHERE %s", q, sch.Name, q, where)
+	return rawSQL, values, nil
+}
+
+// BatchSoftDelete generates an UPDATE ... SET column = value WHERE pk IN (...) statement for records.
+func BatchSoftDelete(connector *model.Connector, sch *schemas.Table, typeMap *types.Map, records []core.EventRecord, column string, value interface{}) (string, []interface{}, error) {
+	where, values, err := primaryKeyIn(connector, sch, typeMap, records)
+	if err != nil {
+		return "", nil, err
+	}
+	q := sqlQuotes[connector.Type]
+	rawSQL := fmt.Sprintf("UPDATE %s%s%s SET %s%s%s = ? WHERE %s", q, sch.Name, q, q, column, q, where)
+	return rawSQL, append([]interface{}{value}, values...), nil
+}
+
+// primaryKeyIn generates the pk IN (...) condition of records.
+func primaryKeyIn(connector *model.Connector, sch *schemas.Table, typeMap *types.Map, records []core.EventRecord) (string, []interface{}, error) {
+	q := sqlQuotes[connector.Type]
+	quote := func(name string) string {
+		return q + name + q
+	}
+	pks := sch.GetPrimaryKeyNames()
+	if len(pks) < 1 {
+		return "", nil, fmt.Errorf("can not find primary key for table %s", sch.Name)
+	}
+	var columns []string
+	for _, pk := range pks {
+		columns = append(columns, quote(pk))
+	}
+	values := make([]interface{}, 0, len(pks)*len(records))
+	placeHolders := make([]string, 0, len(records))
+	for _, record := range records {
+		var rowPlaceHolders []string
+		for _, pk := range pks {
+			f, err := record.FieldByName(pk)
+			if err != nil {
+				return "", nil, fmt.Errorf("can not find primary key %s of table %s", pk, sch.Name)
+			}
+			val, err := typeMap.Decode(f.Value)
+			if err != nil {
+				return "", nil, err
+			}
+			rowPlaceHolders = append(rowPlaceHolders, "?")
+			values = append(values, val)
+		}
+		if len(pks) > 1 {
+			placeHolders = append(placeHolders, fmt.Sprintf("(%s)", strings.Join(rowPlaceHolders, ",")))
+		} else {
+			placeHolders = append(placeHolders, rowPlaceHolders[0])
+		}
+	}
+	key := columns[0]
+	if len(pks) > 1 {
+		key = fmt.Sprintf("(%s)", strings.Join(columns, ","))
+	}
+	return fmt.Sprintf("%s IN (%s)", key, strings.Join(placeHolders, ",")), values, nil
+}
@@ -0,0 +1,18 @@
+package common_sql
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core/expr"
+	"github.com/imiskolee/anycdc/pkg/model"
+)
+
+// TableFilter returns the row filter of a table, nil when the table has none.
+func TableFilter(task *model.Task, table string) (*expr.Expr, error) {
+	if task == nil {
+		return nil, nil
+	}
+	define, ok := task.GetTable(table)
+	if !ok || define.Filter == "" {
+		return nil, nil
+	}
+	return expr.Parse(define.Filter)
+}
@@ -0,0 +1,71 @@
+package common_sql
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"strings"
+)
+
+// TableHistory reports whether events of a source table are appended as history rows.
+func TableHistory(task *model.Task, table string) bool {
+	if task == nil {
+		return false
+	}
+	return task.GetWriterPolicy().TableApplyPolicy(table).History()
+}
+
+// CloseHistory generates the UPDATEs which close the current versions of the source keys in events,
+// rows are the history rows of events and are never closed by them, so a retried batch keeps its versions.
+func (s *SQLGenerator) CloseHistory(events []core.Event, rows []core.Event) ([]string, [][]interface{}, error) {
+	var ids []interface{}
+	var marks []string
+	for _, row := range rows {
+		id, err := row.Record.FieldByName(core.HistoryIDColumn)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id.Value.V)
+		marks = append(marks, "?")
+	}
+	closed := make(map[string]bool)
+	var sqls []string
+	var params [][]interface{}
+	for i, e := range events {
+		key := core.HistoryKey(e)
+		if closed[key] {
+			continue
+		}
+		closed[key] = true
+		validFrom, err := rows[i].Record.FieldByName(core.HistoryValidFromColumn)
+		if err != nil {
+			return nil, nil, err
+		}
+		values := []interface{}{validFrom.Value.V}
+		var whereClauses []string
+		for _, pk := range e.SourceSchema.GetPrimaryKeyNames() {
+			v, err := e.Record.FieldByName(pk)
+			if err != nil {
+				return nil, nil, err
+			}
+			vv, err := s.typeMap.Decode(v.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", s.quote(pk)))
+			values = append(values, vv)
+		}
+		whereClauses = append(whereClauses,
+			fmt.Sprintf("%s IS NULL", s.quote(core.HistoryValidToColumn)),
+			fmt.Sprintf("%s NOT IN (%s)", s.quote(core.HistoryIDColumn), strings.Join(marks, ", ")),
+		)
+		values = append(values, ids...)
+		sqls = append(sqls, fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s",
+			s.quote(s.schema.Name),
+			s.quote(core.HistoryValidToColumn),
+			strings.Join(whereClauses, " AND "),
+		))
+		params = append(params, values)
+	}
+	return sqls, params, nil
+}
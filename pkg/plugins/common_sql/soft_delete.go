@@ -0,0 +1,71 @@
+package common_sql
+
+import (
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"strings"
+	"time"
+)
+
+// SoftDeleteValue returns the value which marks a row as deleted.
+func SoftDeleteValue(col schemas.Column) interface{} {
+	switch col.DataType {
+	case schemas.TypeBool:
+		return true
+	case schemas.TypeInt, schemas.TypeUint:
+		return 1
+	}
+	return time.Now()
+}
+
+// SoftDeleteReset returns the value of rows which are not deleted, it's set on upserts,
+// so a row inserted again after a delete is alive.
+func SoftDeleteReset(col schemas.Column) types.TypedData {
+	switch col.DataType {
+	case schemas.TypeBool:
+		return types.NewTypedData(schemas.TypeBool, false)
+	case schemas.TypeInt, schemas.TypeUint:
+		return types.NewTypedData(col.DataType, 0)
+	}
+	return types.NewNullData()
+}
+
+// SoftDelete generates an UPDATE which sets column to value on the row of the event.
+func (s *SQLGenerator) SoftDelete(e core.Event, column string, value interface{}) (string, []interface{}, error) {
+	var whereClauses []string
+	values := []interface{}{value}
+	for _, col := range s.schema.GetPrimaryKeyNames() {
+		v, err := e.Record.FieldByName(col)
+		if err != nil {
+			return "", nil, err
+		}
+		whereClauses = append(whereClauses, fmt.Sprintf("%s = ?", s.quote(col)))
+		vv, err := s.typeMap.Decode(v.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		values = append(values, vv)
+	}
+	sql := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s",
+		s.quote(e.DestinationTableName),
+		s.quote(column),
+		strings.Join(whereClauses, " AND "),
+	)
+	return sql, values, nil
+}
+
+// TableSoftDeleteColumn returns the soft delete column of a source table on the destination schema,
+// ok is false when soft delete is disabled or the column does not exist.
+func TableSoftDeleteColumn(task *model.Task, table string, sch *schemas.Table) (schemas.Column, bool) {
+	if task == nil {
+		return schemas.Column{}, false
+	}
+	policy := task.GetWriterPolicy().TableApplyPolicy(table)
+	if !policy.SoftDelete() {
+		return schemas.Column{}, false
+	}
+	return sch.GetFieldByName(policy.SoftDeleteColumn)
+}
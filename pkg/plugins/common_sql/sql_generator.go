@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/expr"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/core/types"
 	"github.com/imiskolee/anycdc/pkg/model"
@@ -14,6 +15,7 @@ var sqlQuotes = map[string]string{
 	model.ConnectorTypeMySQL:     "`",
 	model.ConnectorTypePostgres:  `"`,
 	model.ConnectorTypeStarRocks: "`",
+	model.ConnectorTypeDoris:     "`",
 }
 
 type SQLGenerator struct {
@@ -40,21 +42,24 @@ func (s *SQLGenerator) DML(e core.Event) (string, []interface{}, error) {
 	return "", nil, errors.New("invalid event type")
 }
 
-func (s *SQLGenerator) Dumper(batchSize int, lastRecord *core.EventRecord) (string, []interface{}, error) {
+func (s *SQLGenerator) Dumper(batchSize int, lastRecord *core.EventRecord, filter *expr.Expr) (string, []interface{}, error) {
 	var whereClauses []string
 	var whereValues []interface{}
 	var orderByClauses []string
 	primaryKeys := s.schema.GetPrimaryKeyNames()
+	bind := func(v interface{}) string {
+		whereValues = append(whereValues, v)
+		if s.connector.Type == model.ConnectorTypePostgres {
+			return fmt.Sprintf("$%d", len(whereValues))
+		}
+		return "?"
+	}
 
 	for _, pk := range primaryKeys {
 		orderByClauses = append(orderByClauses, fmt.Sprintf("%s ASC", s.quote(pk)))
 	}
 	if lastRecord != nil {
-		quote := "?"
-		for idx, pk := range primaryKeys {
-			if s.connector.Type == model.ConnectorTypePostgres {
-				quote = fmt.Sprintf("$%d", idx+1)
-			}
+		for _, pk := range primaryKeys {
 			fieldValue, err := lastRecord.FieldByName(pk)
 			if err != nil {
 				return "", nil, err
@@ -63,13 +68,16 @@ func (s *SQLGenerator) Dumper(batchSize int, lastRecord *core.EventRecord) (stri
 			if err != nil {
 				return "", nil, err
 			}
-			whereClauses = append(whereClauses, fmt.Sprintf("%s >= %s", s.quote(pk), quote))
-			whereValues = append(whereValues, v)
+			whereClauses = append(whereClauses, fmt.Sprintf("%s >= %s", s.quote(pk), bind(v)))
 		}
-	} else {
+	}
+	if filter != nil {
+		if where, ok := filter.SQL(expr.SQLDialect{Quote: s.quote, Bind: bind}); ok {
+			whereClauses = append(whereClauses, where)
+		}
+	}
+	if len(whereClauses) == 0 {
 		whereClauses = []string{"1 = 1"}
-		whereValues = nil
-
 	}
 
 	sql := fmt.Sprintf(`SELECT * FROM %s WHERE %s ORDER BY %s LIMIT %d`,
@@ -142,7 +150,7 @@ func (s *SQLGenerator) toInsert(e core.Event) (string, []interface{}, error) {
 		)
 		values = append(values, updateValues...)
 		break
-	case model.ConnectorTypeStarRocks:
+	case model.ConnectorTypeStarRocks, model.ConnectorTypeDoris:
 		insertSQL = fmt.Sprintf(
 			"INSERT INTO %s (%s) VALUES (%s)",
 			s.quote(s.schema.Name),
@@ -0,0 +1,20 @@
+package doris
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/plugins/mysql"
+)
+
+const (
+	pluginName = "doris"
+)
+
+func init() {
+	core.RegisterPlugin(pluginName, core.Plugin{
+		Name:             pluginName,
+		WriterFactory:    mysql.NewWriter,
+		SchemaFactory:    newSchema,
+		DumperFactory:    mysql.NewDumper,
+		ConnectorFactory: mysql.NewConnector,
+	})
+}
@@ -0,0 +1,201 @@
+package doris
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"github.com/imiskolee/anycdc/pkg/plugins/mysql"
+	"sort"
+	"strings"
+)
+
+const maxVarcharLength = 65533
+
+type extra struct {
+	Buckets         int               `json:"buckets"`
+	ReplicationNum  int               `json:"replication_num"`
+	DisableSequence bool              `json:"disable_sequence"`
+	Properties      map[string]string `json:"properties"`
+}
+
+// schema reads tables through the mysql protocol and creates merge-on-write Unique Key tables.
+type schema struct {
+	core.SchemaManager
+	opt *core.SchemaOption
+}
+
+func newSchema(ctx context.Context, opt interface{}) core.SchemaManager {
+	return &schema{
+		SchemaManager: mysql.NewSchema(ctx, opt),
+		opt:           opt.(*core.SchemaOption),
+	}
+}
+
+func (s *schema) CreateTable(table *schemas.Table) error {
+	e, err := parseExtra(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	db, err := mysql.Connect(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	sql, err := createTableSQL(table, e)
+	if err != nil {
+		return err
+	}
+	s.opt.Logger.Info("Migrate Table SQL:%s", sql)
+	return db.Exec(sql).Error
+}
+
+func parseExtra(connector *model.Connector) (extra, error) {
+	var e extra
+	if connector.Extra == "" {
+		return e, nil
+	}
+	if err := json.Unmarshal([]byte(connector.Extra), &e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+func createTableSQL(table *schemas.Table, e extra) (string, error) {
+	pks := table.GetPrimaryKeyNames()
+	if len(pks) < 1 {
+		return "", fmt.Errorf("can not find primary key for table %s", table.Name)
+	}
+	var keys []string
+	for _, pk := range pks {
+		keys = append(keys, quote(pk))
+	}
+	// key columns must be the first columns of a Unique Key table.
+	var columns []string
+	for _, col := range table.GetPrimaryKeys() {
+		columns = append(columns, fieldDefine(col))
+	}
+	for _, col := range table.Columns {
+		if !col.IsPrimaryKey {
+			columns = append(columns, fieldDefine(col))
+		}
+	}
+	buckets := "AUTO"
+	if e.Buckets > 0 {
+		buckets = fmt.Sprint(e.Buckets)
+	}
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) UNIQUE KEY(%s) DISTRIBUTED BY HASH(%s) BUCKETS %s",
+		quote(table.Name),
+		strings.Join(columns, ","),
+		strings.Join(keys, ","),
+		strings.Join(keys, ","),
+		buckets,
+	)
+	properties := map[string]string{
+		"enable_unique_key_merge_on_write": "true",
+	}
+	// the writer sends an increasing sequence with every row, so older versions never overwrite newer ones.
+	if !e.DisableSequence {
+		properties["function_column.sequence_type"] = "bigint"
+	}
+	for k, v := range e.Properties {
+		properties[k] = v
+	}
+	if e.ReplicationNum > 0 {
+		properties["replication_num"] = fmt.Sprint(e.ReplicationNum)
+	}
+	var names []string
+	for k := range properties {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var props []string
+	for _, k := range names {
+		props = append(props, fmt.Sprintf("%q=%q", k, properties[k]))
+	}
+	sql += " PROPERTIES(" + strings.Join(props, ",") + ")"
+	return sql + ";", nil
+}
+
+func quote(name string) string {
+	return "`" + name + "`"
+}
+
+func fieldDefine(f schemas.Column) string {
+	nullable := ""
+	if !f.Nullable || f.IsPrimaryKey {
+		nullable = "NOT NULL"
+	}
+	return fmt.Sprintf("%s %s %s", quote(f.Name), fieldType(f), nullable)
+}
+
+func fieldType(f schemas.Column) string {
+	switch f.DataType {
+	case schemas.TypeInt:
+		switch f.SecondlyType {
+		case schemas.SecondlyTypeSmallInt:
+			return "smallint"
+		case schemas.SecondlyTypeBigInt:
+			return "bigint"
+		}
+		return "int"
+	case schemas.TypeUint:
+		switch f.SecondlyType {
+		case schemas.SecondlyTypeSmallInt:
+			return "int"
+		case schemas.SecondlyTypeBigInt:
+			return "largeint"
+		}
+		return "bigint"
+	case schemas.TypeDecimal:
+		switch f.SecondlyType {
+		case schemas.SecondlyTypeFloat:
+			return "float"
+		case schemas.SecondlyTypeReal:
+			return "double"
+		}
+		precision, scale := f.NumericPrecision, f.NumericScale
+		if precision <= 0 || precision > 38 {
+			precision = 38
+		}
+		if scale > precision {
+			scale = precision
+		}
+		return fmt.Sprintf("decimal(%d,%d)", precision, scale)
+	case schemas.TypeString:
+		switch f.SecondlyType {
+		case schemas.SecondlyTypeChar:
+			if f.ColumnLength > 0 && f.ColumnLength <= 255 {
+				return fmt.Sprintf("char(%d)", f.ColumnLength)
+			}
+		case schemas.SecondlyTypeVarChar:
+			if f.ColumnLength > 0 && f.ColumnLength <= maxVarcharLength {
+				return fmt.Sprintf("varchar(%d)", f.ColumnLength)
+			}
+		}
+		// string can not be a key column.
+		if f.IsPrimaryKey {
+			return fmt.Sprintf("varchar(%d)", maxVarcharLength)
+		}
+		return "string"
+	case schemas.TypeBlob:
+		return "string"
+	case schemas.TypeBool:
+		return "boolean"
+	case schemas.TypeDate:
+		return "date"
+	case schemas.TypeTimestamp:
+		return "datetime(6)"
+	case schemas.TypeTime:
+		return "varchar(32)"
+	case schemas.TypeJSON:
+		return "json"
+	case schemas.TypeUUID:
+		return "varchar(36)"
+	}
+	if f.IsPrimaryKey {
+		return fmt.Sprintf("varchar(%d)", maxVarcharLength)
+	}
+	return "string"
+}
@@ -0,0 +1,40 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/imiskolee/anycdc/pkg/model"
+)
+
+type extra struct {
+	// Refresh is the refresh policy of write requests: true, false or wait_for, empty means server default.
+	Refresh string `json:"refresh"`
+	// UseAlias creates versioned indices from an index template behind an alias named after the table.
+	UseAlias         bool `json:"use_alias"`
+	NumberOfShards   int  `json:"number_of_shards"`
+	NumberOfReplicas *int `json:"number_of_replicas"`
+}
+
+func parseExtra(connector *model.Connector) (extra, error) {
+	var e extra
+	if connector.Extra == "" {
+		return e, nil
+	}
+	if err := json.Unmarshal([]byte(connector.Extra), &e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+func connect(connector *model.Connector) (*elasticsearch.Client, error) {
+	return elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{
+			fmt.Sprintf("http://%s:%d", connector.Host, connector.Port),
+		},
+		Username:            connector.Username,
+		Password:            connector.Password,
+		MaxRetries:          10,
+		CompressRequestBody: false,
+	})
+}
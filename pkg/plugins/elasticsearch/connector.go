@@ -3,8 +3,6 @@ package elasticsearch
 import (
 	"context"
 	"errors"
-	"fmt"
-	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/imiskolee/anycdc/pkg/core"
 )
 
@@ -19,15 +17,7 @@ func newConnector(ctx context.Context, opt interface{}) core.Connector {
 }
 
 func (s *connector) Test() error {
-	client, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{
-			fmt.Sprintf("http://%s:%d", s.opt.Connector.Host, s.opt.Connector.Port),
-		},
-		Username:            s.opt.Connector.Username,
-		Password:            s.opt.Connector.Password,
-		MaxRetries:          10,
-		CompressRequestBody: false,
-	})
+	client, err := connect(s.opt.Connector)
 	if err != nil {
 		return err
 	}
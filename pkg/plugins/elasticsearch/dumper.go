@@ -0,0 +1,398 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pitKeepAlive = "5m"
+
+type searchHit struct {
+	ID     string                 `json:"_id"`
+	Source map[string]interface{} `json:"_source"`
+	Sort   []interface{}          `json:"sort"`
+}
+
+type searchResponse struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type dumper struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	opt           *core.DumperOption
+	client        *elasticsearch.Client
+	schemaManager core.SchemaManager
+	stopped       bool
+}
+
+func newDumper(ctx context.Context, opts interface{}) core.Dumper {
+	c, cancel := context.WithCancel(ctx)
+	d := &dumper{
+		ctx:    c,
+		cancel: cancel,
+		opt:    opts.(*core.DumperOption),
+	}
+	d.schemaManager = core.NewCachedSchemaManager(newSchema(ctx, &core.SchemaOption{
+		Connector: d.opt.Connector,
+		Logger:    d.opt.Logger,
+	}))
+	return d
+}
+
+func (d *dumper) Prepare() error {
+	client, err := connect(d.opt.Connector)
+	if err != nil {
+		return d.opt.Logger.Errorf("can not connector to %s, %s", d.opt.Connector.Name, err)
+	}
+	d.client = client
+	return nil
+}
+
+func (d *dumper) Stop() error {
+	d.stopped = true
+	d.cancel()
+	return nil
+}
+
+// dumperKey is the position a dump resumes from, the sort values of the last dumped document.
+// Indices ordered by _shard_doc keep their point in time, the sort values are only valid in it.
+type dumperKey struct {
+	SearchAfter []interface{} `json:"search_after"`
+	PitID       string        `json:"pit_id,omitempty"`
+}
+
+// StartDumpTable iterates the index with a point in time ordered by the primary keys, and resumes
+// after the sort values of the last dumped document. Indices without primary keys in the mapping meta
+// use _id as primary key, they are ordered by _shard_doc, so they resume in the point in time of the
+// stopped dump while it's alive, and restart from the beginning once it expired.
+func (d *dumper) StartDumpTable(table *model.TaskTable) error {
+	sch := d.schemaManager.Get(d.opt.Connector.Database, table.Table)
+	if sch == nil || len(sch.Columns) == 0 {
+		return d.opt.Logger.Errorf("start dumper failed,can not find mapping for index %s", table.Table)
+	}
+	pks := sch.GetPrimaryKeyNames()
+	sortedByKeys := !(len(pks) == 1 && pks[0] == "_id")
+	var sort []interface{}
+	if sortedByKeys {
+		for _, pk := range pks {
+			sort = append(sort, map[string]interface{}{pk: "asc"})
+		}
+	} else {
+		sort = append(sort, map[string]interface{}{"_shard_doc": "asc"})
+	}
+	query := map[string]interface{}{"match_all": map[string]interface{}{}}
+	var key dumperKey
+	if table.LastDumperKey != "" {
+		var last map[string]interface{}
+		var err error
+		if key, last, err = parseDumperKey(table.LastDumperKey); err != nil {
+			return err
+		}
+		if last != nil {
+			if sortedByKeys {
+				query = afterQuery(pks, last)
+			} else {
+				d.opt.Logger.Info("index %s has no primary keys, dump restarts from the beginning", table.Table)
+			}
+		}
+	}
+	pit := key.PitID
+	if !sortedByKeys && pit != "" && !d.pitAlive(pit, table.Table) {
+		d.opt.Logger.Info("point in time of index %s expired, dump restarts from the beginning", table.Table)
+		key = dumperKey{}
+		pit = ""
+	}
+	if pit == "" {
+		var err error
+		if pit, err = d.openPIT(table.Table); err != nil {
+			return d.opt.Logger.Errorf("start dumper failed,can not open point in time for index %s, %s", table.Table, err)
+		}
+	}
+	completed := false
+	defer (func() {
+		// a stopped dump of an index without primary keys resumes in its point in time.
+		if sortedByKeys || completed {
+			d.closePIT(pit)
+		}
+	})()
+	total := 0
+	batchSize := d.opt.BatchSize
+	searchAfter := key.SearchAfter
+	var lastLogAt time.Time
+	for {
+		if d.stopped {
+			d.opt.Logger.Info("stop dump, because of received stop signal")
+			return nil
+		}
+		now := time.Now()
+		body := map[string]interface{}{
+			"size":             batchSize,
+			"query":            query,
+			"sort":             sort,
+			"track_total_hits": false,
+			"pit": map[string]interface{}{
+				"id":         pit,
+				"keep_alive": pitKeepAlive,
+			},
+		}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
+		}
+		result, err := d.search(body)
+		if err != nil {
+			return d.opt.Logger.Errorf("dump failed,can not query batch %v", err)
+		}
+		if result.PitID != "" {
+			pit = result.PitID
+		}
+		hits := result.Hits.Hits
+		var batch []core.EventRecord
+		for _, hit := range hits {
+			record, err := hitToRecord(sch, hit)
+			if err != nil {
+				return d.opt.Logger.Errorf("dump failed,can not convert document %s, %v", hit.ID, err)
+			}
+			batch = append(batch, record)
+		}
+		if len(batch) > 0 {
+			if err := d.opt.Subscriber.DumperEvent(sch, batch); err != nil {
+				return d.opt.Logger.Errorf("dump failed,can not handle batch %v", err)
+			}
+			total += len(batch)
+			searchAfter = hits[len(hits)-1].Sort
+			if subscriber, ok := d.opt.Subscriber.(core.DumperKeySubscriber); ok {
+				key := dumperKey{SearchAfter: searchAfter}
+				if !sortedByKeys {
+					key.PitID = pit
+				}
+				subscriber.DumperKey(sch.Name, key)
+			}
+		}
+		if now.Sub(lastLogAt) > 30*time.Second {
+			lastLogAt = now
+			d.opt.Logger.Info("successful dump table %s, records %d", table.Table, total)
+		}
+		if len(hits) < batchSize {
+			d.opt.Logger.Info("successful dump records %d on table %s,", total, table.Table)
+			break
+		}
+	}
+	completed = true
+	return nil
+}
+
+// parseDumperKey parses a saved dumperKey, keys saved by earlier versions are the primary keys
+// of the last document, they're returned as last.
+func parseDumperKey(value string) (key dumperKey, last map[string]interface{}, err error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&last); err != nil {
+		return key, nil, err
+	}
+	if _, ok := last["search_after"]; !ok {
+		return key, last, nil
+	}
+	decoder = json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	err = decoder.Decode(&key)
+	return key, nil, err
+}
+
+// pitAlive reports whether a point in time can still be searched.
+func (d *dumper) pitAlive(pit string, index string) bool {
+	_, err := d.search(map[string]interface{}{
+		"size":             0,
+		"track_total_hits": false,
+		"pit": map[string]interface{}{
+			"id":         pit,
+			"keep_alive": pitKeepAlive,
+		},
+	})
+	if err != nil {
+		d.opt.Logger.Info("can not search point in time of index %s, %s", index, err)
+	}
+	return err == nil
+}
+
+func (d *dumper) openPIT(index string) (string, error) {
+	resp, err := d.client.OpenPointInTime([]string{index}, pitKeepAlive, d.client.OpenPointInTime.WithContext(d.ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return "", errors.New(resp.String())
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+func (d *dumper) closePIT(pit string) {
+	err := do(d.client.ClosePointInTime(d.client.ClosePointInTime.WithBody(jsonBody(map[string]interface{}{
+		"id": pit,
+	}))))
+	if err != nil {
+		d.opt.Logger.Error("can not close point in time, %s", err)
+	}
+}
+
+func (d *dumper) search(body map[string]interface{}) (*searchResponse, error) {
+	resp, err := d.client.Search(d.client.Search.WithContext(d.ctx), d.client.Search.WithBody(jsonBody(body)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return nil, errors.New(resp.String())
+	}
+	var result searchResponse
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// afterQuery matches documents sorted after the last dumped primary keys,
+// (a > x) OR (a = x AND b > y) for composite keys.
+func afterQuery(pks []string, last map[string]interface{}) map[string]interface{} {
+	var should []interface{}
+	for i, pk := range pks {
+		var must []interface{}
+		for _, prev := range pks[:i] {
+			must = append(must, map[string]interface{}{"term": map[string]interface{}{prev: last[prev]}})
+		}
+		must = append(must, map[string]interface{}{"range": map[string]interface{}{pk: map[string]interface{}{"gt": last[pk]}}})
+		should = append(should, map[string]interface{}{"bool": map[string]interface{}{"filter": must}})
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+func hitToRecord(sch *schemas.Table, hit searchHit) (core.EventRecord, error) {
+	var record core.EventRecord
+	for _, col := range sch.Columns {
+		if col.Name == "_id" {
+			record.Set(col.Name, types.NewTypedData(schemas.TypeString, hit.ID))
+			continue
+		}
+		v, ok := hit.Source[col.Name]
+		if !ok {
+			continue
+		}
+		val, err := convertSourceValue(col, v)
+		if err != nil {
+			return record, fmt.Errorf("field %s convert fail, %s", col.Name, err)
+		}
+		record.Set(col.Name, val)
+	}
+	return record, nil
+}
+
+func convertSourceValue(col schemas.Column, v interface{}) (types.TypedData, error) {
+	if v == nil {
+		return types.NewNullData(), nil
+	}
+	switch col.DataType {
+	case schemas.TypeInt:
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return types.NewNullData(), err
+			}
+			return typMap.Encode(col.DataType, i)
+		}
+	case schemas.TypeUint:
+		if n, ok := v.(json.Number); ok {
+			i, err := strconv.ParseUint(n.String(), 10, 64)
+			if err != nil {
+				return types.NewNullData(), err
+			}
+			return typMap.Encode(col.DataType, i)
+		}
+	case schemas.TypeDecimal:
+		if n, ok := v.(json.Number); ok {
+			return typMap.Encode(col.DataType, n.String())
+		}
+	case schemas.TypeDate, schemas.TypeTimestamp:
+		switch val := v.(type) {
+		case json.Number:
+			ms, err := val.Int64()
+			if err != nil {
+				return types.NewNullData(), err
+			}
+			return typMap.Encode(col.DataType, time.UnixMilli(ms).UTC())
+		case string:
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+				if t, err := time.Parse(layout, val); err == nil {
+					return typMap.Encode(col.DataType, t)
+				}
+			}
+		}
+	case schemas.TypeBlob:
+		if s, ok := v.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return types.NewNullData(), err
+			}
+			return typMap.Encode(col.DataType, b)
+		}
+	case schemas.TypeJSON:
+		return typMap.Encode(col.DataType, normalizeNumbers(v))
+	case schemas.TypeString, schemas.TypeUUID:
+		if _, ok := v.(string); !ok {
+			j, err := json.Marshal(v)
+			if err != nil {
+				return types.NewNullData(), err
+			}
+			return typMap.Encode(col.DataType, string(j))
+		}
+	}
+	return typMap.Encode(col.DataType, normalizeNumbers(v))
+}
+
+// normalizeNumbers turns json.Number back into int64 or float64 values.
+func normalizeNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, vv := range val {
+			val[k] = normalizeNumbers(vv)
+		}
+	case []interface{}:
+		for i, vv := range val {
+			val[i] = normalizeNumbers(vv)
+		}
+	}
+	return v
+}
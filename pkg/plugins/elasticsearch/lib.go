@@ -7,6 +7,8 @@ const pluginName = "elasticsearch"
 func init() {
 	core.RegisterPlugin(pluginName, core.Plugin{
 		Name:          pluginName,
+		SchemaFactory: newSchema,
 		WriterFactory: newWriter,
+		DumperFactory: newDumper,
 	})
 }
@@ -0,0 +1,443 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dateFormat      = "strict_date||strict_date_optional_time||epoch_millis"
+	timeFormat      = "HH:mm:ss.SSSSSS||HH:mm:ss"
+	timestampFormat = "strict_date_optional_time||epoch_millis"
+)
+
+type indexMeta struct {
+	PrimaryKeys []string `json:"primary_keys"`
+	Columns     []string `json:"columns"`
+}
+
+type mappingProperty struct {
+	Type          string                     `json:"type,omitempty"`
+	Format        string                     `json:"format,omitempty"`
+	ScalingFactor float64                    `json:"scaling_factor,omitempty"`
+	Dynamic       *bool                      `json:"dynamic,omitempty"`
+	Properties    map[string]mappingProperty `json:"properties,omitempty"`
+}
+
+type indexMapping struct {
+	Meta       indexMeta                  `json:"_meta"`
+	Properties map[string]mappingProperty `json:"properties"`
+}
+
+type schema struct {
+	opt *core.SchemaOption
+}
+
+func newSchema(ctx context.Context, opt interface{}) core.SchemaManager {
+	return &schema{opt: opt.(*core.SchemaOption)}
+}
+
+// Get reads the index mapping back into a table, the table name can be an index or an alias.
+func (s *schema) Get(dbname string, tableName string) *schemas.Table {
+	client, err := connect(s.opt.Connector)
+	if err != nil {
+		s.opt.Logger.Error("can not connect to elasticsearch:%s %v", s.opt.Connector.Name, err)
+		return nil
+	}
+	resp, err := client.Indices.GetMapping(client.Indices.GetMapping.WithIndex(tableName))
+	if err != nil {
+		s.opt.Logger.Error("can not get mapping for index %s, %s", tableName, err)
+		return &schemas.Table{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &schemas.Table{}
+	}
+	if resp.IsError() {
+		s.opt.Logger.Error("can not get mapping for index %s, %s", tableName, resp.String())
+		return &schemas.Table{}
+	}
+	var result map[string]struct {
+		Mappings indexMapping `json:"mappings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		s.opt.Logger.Error("can not parse mapping for index %s, %s", tableName, err)
+		return &schemas.Table{}
+	}
+	index, ok := result[tableName]
+	if !ok {
+		// an alias may point to several indices, the write index has the current mapping.
+		_, write, err := aliasIndices(client, tableName)
+		if err != nil {
+			s.opt.Logger.Error("can not get the write index of alias %s, %s", tableName, err)
+			return &schemas.Table{}
+		}
+		if index, ok = result[write]; !ok {
+			return &schemas.Table{}
+		}
+	}
+	return withDocumentID(mappingToTable(tableName, index.Mappings))
+}
+
+// withDocumentID uses _id as primary key when the mapping has no primary keys.
+func withDocumentID(sch *schemas.Table) *schemas.Table {
+	if len(sch.GetPrimaryKeys()) > 0 {
+		return sch
+	}
+	t := *sch
+	t.Columns = append([]schemas.Column{{
+		Name:         "_id",
+		DataType:     schemas.TypeString,
+		SecondlyType: schemas.SecondlyTypeVarChar,
+		ColumnLength: 255,
+		IsPrimaryKey: true,
+	}}, sch.Columns...)
+	for i := range t.Columns {
+		t.Columns[i].Index = uint(i)
+	}
+	return &t
+}
+
+func (s *schema) CreateTable(table *schemas.Table) error {
+	e, err := parseExtra(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	client, err := connect(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"mappings": tableToMapping(table),
+	}
+	if settings := indexSettings(e); len(settings) > 0 {
+		body["settings"] = settings
+	}
+	if !e.UseAlias {
+		s.opt.Logger.Info("Migrate Index %s", table.Name)
+		return createIndex(client, table.Name, body)
+	}
+	template := map[string]interface{}{
+		"index_patterns": []string{table.Name + "_v*"},
+		"template":       body,
+		"_meta": map[string]interface{}{
+			"managed_by": "anycdc",
+		},
+	}
+	s.opt.Logger.Info("Migrate Index Template %s", table.Name)
+	if err := do(client.Indices.PutIndexTemplate(table.Name, jsonBody(template))); err != nil {
+		return err
+	}
+	exists, err := indexExists(client, table.Name)
+	if err != nil || exists {
+		return err
+	}
+	return createIndex(client, versionedIndex(table.Name, 1), map[string]interface{}{
+		"aliases": map[string]interface{}{
+			table.Name: map[string]interface{}{"is_write_index": true},
+		},
+	})
+}
+
+// Reindex copies the indices behind the alias of a table into the next version, created from the index template
+// of the table, then points the alias to it as the write index. Documents written while it copies are not copied,
+// so the task which writes the table should be stopped first.
+func (s *schema) Reindex(table string) (string, error) {
+	client, err := connect(s.opt.Connector)
+	if err != nil {
+		return "", err
+	}
+	indices, current, err := aliasIndices(client, table)
+	if err != nil {
+		return "", err
+	}
+	if current == "" {
+		return "", fmt.Errorf("%s is not an alias, the connector should use use_alias", table)
+	}
+	version := 1
+	if idx := strings.LastIndex(current, "_v"); idx > 0 {
+		if v, err := strconv.Atoi(current[idx+2:]); err == nil {
+			version = v
+		}
+	}
+	next := versionedIndex(table, version+1)
+	if err := createIndex(client, next, map[string]interface{}{}); err != nil {
+		return "", err
+	}
+	s.opt.Logger.Info("Reindex %s into %s", table, next)
+	if err := do(client.Reindex(jsonBody(map[string]interface{}{
+		"source": map[string]interface{}{"index": table},
+		"dest":   map[string]interface{}{"index": next},
+	}), client.Reindex.WithWaitForCompletion(true))); err != nil {
+		return "", err
+	}
+	actions := []interface{}{
+		map[string]interface{}{"add": map[string]interface{}{"index": next, "alias": table, "is_write_index": true}},
+	}
+	for _, index := range indices {
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": index, "alias": table}})
+	}
+	if err := do(client.Indices.UpdateAliases(jsonBody(map[string]interface{}{"actions": actions}))); err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+func versionedIndex(name string, version int) string {
+	return fmt.Sprintf("%s_v%d", name, version)
+}
+
+func indexSettings(e extra) map[string]interface{} {
+	settings := make(map[string]interface{})
+	if e.NumberOfShards > 0 {
+		settings["number_of_shards"] = e.NumberOfShards
+	}
+	if e.NumberOfReplicas != nil {
+		settings["number_of_replicas"] = *e.NumberOfReplicas
+	}
+	return settings
+}
+
+func createIndex(client *elasticsearch.Client, name string, body map[string]interface{}) error {
+	err := do(client.Indices.Create(name, client.Indices.Create.WithBody(jsonBody(body))))
+	if err != nil && strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return nil
+	}
+	return err
+}
+
+func indexExists(client *elasticsearch.Client, name string) (bool, error) {
+	resp, err := client.Indices.Exists([]string{name})
+	if err != nil {
+		return false, err
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, errors.New("can not check index " + name + ": " + resp.Status())
+}
+
+// aliasIndices returns the indices an alias points to and its write index, which is the only index when none
+// is marked. Both are empty when name is not an alias.
+func aliasIndices(client *elasticsearch.Client, alias string) ([]string, string, error) {
+	resp, err := client.Indices.GetAlias(client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.IsError() {
+		return nil, "", errors.New("can not get alias " + alias + ": " + resp.String())
+	}
+	var result aliasResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+	return result.indices(alias)
+}
+
+// aliasResponse is the response of GET _alias/<name> by index.
+type aliasResponse map[string]struct {
+	Aliases map[string]struct {
+		IsWriteIndex bool `json:"is_write_index"`
+	} `json:"aliases"`
+}
+
+// indices returns the indices of an alias sorted by name and its write index.
+func (r aliasResponse) indices(alias string) ([]string, string, error) {
+	var indices []string
+	var write string
+	for index, a := range r {
+		indices = append(indices, index)
+		if a.Aliases[alias].IsWriteIndex {
+			write = index
+		}
+	}
+	sort.Strings(indices)
+	if write == "" {
+		if len(indices) != 1 {
+			return nil, "", fmt.Errorf("alias %s points to %d indices without a write index", alias, len(indices))
+		}
+		write = indices[0]
+	}
+	return indices, write, nil
+}
+
+func do(resp *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return errors.New(resp.String())
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func jsonBody(v interface{}) io.Reader {
+	j, _ := json.Marshal(v)
+	return bytes.NewReader(j)
+}
+
+func tableToMapping(table *schemas.Table) indexMapping {
+	mapping := indexMapping{
+		Meta: indexMeta{
+			PrimaryKeys: table.GetPrimaryKeyNames(),
+		},
+		Properties: make(map[string]mappingProperty),
+	}
+	for _, col := range table.Columns {
+		mapping.Meta.Columns = append(mapping.Meta.Columns, col.Name)
+		// _id is a metadata field of the document.
+		if col.Name == "_id" {
+			continue
+		}
+		mapping.Properties[col.Name] = columnToProperty(col)
+	}
+	return mapping
+}
+
+func columnToProperty(col schemas.Column) mappingProperty {
+	switch col.DataType {
+	case schemas.TypeInt:
+		switch col.SecondlyType {
+		case schemas.SecondlyTypeSmallInt:
+			return mappingProperty{Type: "short"}
+		case schemas.SecondlyTypeBigInt:
+			return mappingProperty{Type: "long"}
+		}
+		return mappingProperty{Type: "integer"}
+	case schemas.TypeUint:
+		return mappingProperty{Type: "unsigned_long"}
+	case schemas.TypeDecimal:
+		if col.SecondlyType == schemas.SecondlyTypeDecimal {
+			return mappingProperty{Type: "scaled_float", ScalingFactor: math.Pow10(col.NumericScale)}
+		}
+		return mappingProperty{Type: "double"}
+	case schemas.TypeString:
+		switch col.SecondlyType {
+		case schemas.SecondlyTypeVarChar, schemas.SecondlyTypeChar:
+			return mappingProperty{Type: "keyword"}
+		}
+		return mappingProperty{Type: "text"}
+	case schemas.TypeUUID:
+		return mappingProperty{Type: "keyword"}
+	case schemas.TypeBool:
+		return mappingProperty{Type: "boolean"}
+	case schemas.TypeDate:
+		return mappingProperty{Type: "date", Format: dateFormat}
+	case schemas.TypeTime:
+		return mappingProperty{Type: "date", Format: timeFormat}
+	case schemas.TypeTimestamp:
+		return mappingProperty{Type: "date", Format: timestampFormat}
+	case schemas.TypeJSON:
+		dynamic := true
+		return mappingProperty{Type: "object", Dynamic: &dynamic}
+	case schemas.TypeBlob:
+		return mappingProperty{Type: "binary"}
+	}
+	return mappingProperty{Type: "keyword"}
+}
+
+func mappingToTable(name string, mapping indexMapping) *schemas.Table {
+	table := schemas.Table{
+		Name: name,
+	}
+	columns := mapping.Meta.Columns
+	seen := make(map[string]bool)
+	for _, c := range columns {
+		seen[c] = true
+	}
+	var extras []string
+	for c := range mapping.Properties {
+		if !seen[c] {
+			extras = append(extras, c)
+		}
+	}
+	sort.Strings(extras)
+	columns = append(columns, extras...)
+	pks := make(map[string]bool)
+	for _, pk := range mapping.Meta.PrimaryKeys {
+		pks[pk] = true
+	}
+	for _, c := range columns {
+		prop, ok := mapping.Properties[c]
+		if !ok && c != "_id" {
+			continue
+		}
+		col := propertyToColumn(c, prop)
+		col.Index = uint(len(table.Columns))
+		col.IsPrimaryKey = pks[c]
+		col.Nullable = !col.IsPrimaryKey
+		table.Columns = append(table.Columns, col)
+	}
+	return &table
+}
+
+func propertyToColumn(name string, prop mappingProperty) schemas.Column {
+	col := schemas.Column{Name: name}
+	switch prop.Type {
+	case "byte", "short":
+		col.DataType, col.SecondlyType = schemas.TypeInt, schemas.SecondlyTypeSmallInt
+	case "integer":
+		col.DataType = schemas.TypeInt
+	case "long":
+		col.DataType, col.SecondlyType = schemas.TypeInt, schemas.SecondlyTypeBigInt
+	case "unsigned_long":
+		col.DataType, col.SecondlyType = schemas.TypeUint, schemas.SecondlyTypeBigInt
+	case "double", "float", "half_float":
+		col.DataType, col.SecondlyType = schemas.TypeDecimal, schemas.SecondlyTypeFloat
+	case "scaled_float":
+		col.DataType, col.SecondlyType = schemas.TypeDecimal, schemas.SecondlyTypeDecimal
+		col.NumericPrecision = 38
+		if prop.ScalingFactor > 1 {
+			col.NumericScale = int(math.Round(math.Log10(prop.ScalingFactor)))
+		}
+	case "keyword", "constant_keyword", "wildcard", "":
+		col.DataType, col.SecondlyType = schemas.TypeString, schemas.SecondlyTypeVarChar
+		col.ColumnLength = 255
+		if prop.Type == "" && len(prop.Properties) > 0 {
+			col.DataType, col.SecondlyType, col.ColumnLength = schemas.TypeJSON, schemas.SecondlyTypeUnknown, 0
+		}
+	case "text", "match_only_text":
+		col.DataType, col.SecondlyType = schemas.TypeString, schemas.SecondlyTypeText
+	case "boolean":
+		col.DataType = schemas.TypeBool
+	case "date", "date_nanos":
+		switch prop.Format {
+		case dateFormat:
+			col.DataType = schemas.TypeDate
+		case timeFormat:
+			col.DataType = schemas.TypeTime
+		default:
+			col.DataType = schemas.TypeTimestamp
+		}
+	case "object", "nested", "flattened":
+		col.DataType = schemas.TypeJSON
+	case "binary":
+		col.DataType, col.SecondlyType = schemas.TypeBlob, schemas.SecondlyTypeBlob
+	default:
+		col.DataType, col.SecondlyType = schemas.TypeString, schemas.SecondlyTypeText
+	}
+	return col
+}
@@ -0,0 +1,36 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAliasIndices(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		indices []string
+		write   string
+		err     bool
+	}{
+		{"write index", `{"t_v9":{"aliases":{"t":{}}},"t_v10":{"aliases":{"t":{"is_write_index":true}}}}`, []string{"t_v10", "t_v9"}, "t_v10", false},
+		{"older write index", `{"t_v9":{"aliases":{"t":{"is_write_index":true}}},"t_v10":{"aliases":{"t":{"is_write_index":false}}}}`, []string{"t_v10", "t_v9"}, "t_v9", false},
+		{"single index", `{"t_v1":{"aliases":{"t":{}}}}`, []string{"t_v1"}, "t_v1", false},
+		{"no write index", `{"t_v1":{"aliases":{"t":{}}},"t_v2":{"aliases":{"t":{}}}}`, nil, "", true},
+	}
+	for _, c := range cases {
+		var r aliasResponse
+		if err := json.Unmarshal([]byte(c.body), &r); err != nil {
+			t.Fatal(err)
+		}
+		indices, write, err := r.indices("t")
+		if (err != nil) != c.err {
+			t.Errorf("%s: error %v, want error %v", c.name, err, c.err)
+			continue
+		}
+		if !reflect.DeepEqual(indices, c.indices) || write != c.write {
+			t.Errorf("%s: indices %v write %s, want %v write %s", c.name, indices, write, c.indices, c.write)
+		}
+	}
+}
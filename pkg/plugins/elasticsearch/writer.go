@@ -10,13 +10,42 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/imiskolee/anycdc/pkg/core"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"io"
 	"net/http"
 	"strings"
+	"time"
+)
+
+const (
+	bulkMaxRetries = 5
+	bulkRetryDelay = 500 * time.Millisecond
 )
 
 type writer struct {
 	opt    *core.WriterOption
 	client *elasticsearch.Client
+	extra  extra
+}
+
+// bulkAction is a single action of the bulk request, doc is nil for deletes.
+type bulkAction struct {
+	action string
+	id     string
+	doc    map[string]interface{}
+}
+
+type bulkResponse struct {
+	Errors bool                            `json:"errors"`
+	Items  []map[string]bulkResponseResult `json:"items"`
+}
+
+type bulkResponseResult struct {
+	ID     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
 }
 
 func newWriter(ctx context.Context, opt interface{}) core.Writer {
@@ -26,15 +55,12 @@ func newWriter(ctx context.Context, opt interface{}) core.Writer {
 }
 
 func (s *writer) Prepare() error {
-	client, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{
-			fmt.Sprintf("http://%s:%d", s.opt.Connector.Host, s.opt.Connector.Port),
-		},
-		Username:            s.opt.Connector.Username,
-		Password:            s.opt.Connector.Password,
-		MaxRetries:          10,
-		CompressRequestBody: false,
-	})
+	e, err := parseExtra(s.opt.Connector)
+	if err != nil {
+		return s.opt.Logger.Errorf("can not parse connector extra: %s", err)
+	}
+	s.extra = e
+	client, err := connect(s.opt.Connector)
 	if err != nil {
 		return err
 	}
@@ -50,74 +76,210 @@ func (s *writer) Prepare() error {
 }
 
 func (s *writer) Execute(e core.Event) error {
-	record, err := s.convertObject(&e.SourceSchema, e.Record)
+	e = core.WithMetadata(s.opt.Task, e)
+	actions, err := s.toActions(&e.SourceSchema, e)
 	if err != nil {
 		return s.opt.Logger.Errorf("can not convert object:%s", err)
 	}
-	jsonStr, err := json.Marshal(record)
-	if err != nil {
-		return err
-	}
-	resp, err := s.client.Index(e.DestinationTableName, bytes.NewReader(jsonStr))
-	if err != nil {
-		return err
-	}
-	if resp.IsError() {
-		return errors.New(resp.String())
+	for _, action := range actions {
+		var resp *esapi.Response
+		switch action.action {
+		case "index":
+			body, err := json.Marshal(action.doc)
+			if err != nil {
+				return err
+			}
+			opts := []func(*esapi.IndexRequest){s.client.Index.WithDocumentID(action.id)}
+			if s.extra.Refresh != "" {
+				opts = append(opts, s.client.Index.WithRefresh(s.extra.Refresh))
+			}
+			resp, err = s.client.Index(e.DestinationTableName, bytes.NewReader(body), opts...)
+			if err != nil {
+				return s.opt.Logger.Errorf("can not index document %s: %s", action.id, err)
+			}
+		case "delete":
+			var opts []func(*esapi.DeleteRequest)
+			if s.extra.Refresh != "" {
+				opts = append(opts, s.client.Delete.WithRefresh(s.extra.Refresh))
+			}
+			resp, err = s.client.Delete(e.DestinationTableName, action.id, opts...)
+			if err != nil {
+				return s.opt.Logger.Errorf("can not delete document %s: %s", action.id, err)
+			}
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.IsError() && !(action.action == "delete" && resp.StatusCode == http.StatusNotFound) {
+			return s.opt.Logger.Errorf("can not %s document %s: %s", action.action, action.id, resp.Status())
+		}
 	}
 	return nil
 }
 
 func (s *writer) ExecuteBatch(sourceSchema *schemas.Table, records []core.Event) error {
-	var buf bytes.Buffer
-
+	if len(records) < 1 {
+		return nil
+	}
+	index := records[0].DestinationTableName
+	var actions []bulkAction
 	for _, record := range records {
-		r, err := s.convertObject(sourceSchema, record.Record)
+		a, err := s.toActions(sourceSchema, core.WithMetadata(s.opt.Task, record))
 		if err != nil {
 			return s.opt.Logger.Errorf("can not convert object: %s", err)
 		}
-		delete(r, "_id")
-		if err := json.NewEncoder(&buf).Encode(map[string]interface{}{
-			"create": map[string]interface{}{
-				"_index": records[0].DestinationTableName,
-				"_id":    r["_id"],
+		actions = append(actions, a...)
+	}
+	delay := bulkRetryDelay
+	for i := 0; ; i++ {
+		rejected, err := s.bulk(index, actions)
+		if err != nil {
+			return err
+		}
+		if len(rejected) == 0 {
+			return nil
+		}
+		if i >= bulkMaxRetries {
+			return s.opt.Logger.Errorf("can not do bulk to server, %d items still rejected after %d retries", len(rejected), i)
+		}
+		s.opt.Logger.Info("bulk on index %s has %d rejected items, retry after %s", index, len(rejected), delay)
+		time.Sleep(delay)
+		delay *= 2
+		actions = rejected
+	}
+}
+
+// Flush is a no-op, events are indexed when they arrive.
+func (s *writer) Flush() error {
+	return nil
+}
+
+// bulk sends actions in one request, it returns the actions rejected by a full queue which can be retried.
+func (s *writer) bulk(index string, actions []bulkAction) ([]bulkAction, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, action := range actions {
+		if err := encoder.Encode(map[string]interface{}{
+			action.action: map[string]interface{}{
+				"_index": index,
+				"_id":    action.id,
 			},
 		}); err != nil {
-			return err
+			return nil, err
 		}
-		_ = json.NewEncoder(&buf).Encode(r)
+		if action.doc != nil {
+			if err := encoder.Encode(action.doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	opts := []func(*esapi.BulkRequest){s.client.Bulk.WithIndex(index)}
+	if s.extra.Refresh != "" {
+		opts = append(opts, s.client.Bulk.WithRefresh(s.extra.Refresh))
 	}
-	resp, err := s.client.Bulk(&buf, func(o *esapi.BulkRequest) {
-		o.Refresh = "true"
-	})
+	resp, err := s.client.Bulk(&buf, opts...)
 	if err != nil {
-		return s.opt.Logger.Errorf("can not execute bulk: %s", err)
+		return nil, s.opt.Logger.Errorf("can not execute bulk: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return actions, nil
 	}
 	if resp.IsError() {
-		return s.opt.Logger.Errorf("can not do bulk to server:%d", resp.StatusCode)
+		return nil, s.opt.Logger.Errorf("can not do bulk to server:%d", resp.StatusCode)
 	}
-	return nil
+	var result bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, s.opt.Logger.Errorf("can not parse bulk response: %s", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var rejected []bulkAction
+	var failures []string
+	for i, item := range result.Items {
+		if i >= len(actions) {
+			break
+		}
+		for op, res := range item {
+			if res.Status < 300 || (op == "delete" && res.Status == http.StatusNotFound) {
+				continue
+			}
+			if res.Status == http.StatusTooManyRequests {
+				rejected = append(rejected, actions[i])
+				continue
+			}
+			reason := http.StatusText(res.Status)
+			if res.Error != nil {
+				reason = res.Error.Type + ": " + res.Error.Reason
+			}
+			failures = append(failures, fmt.Sprintf("%s %s [%d] %s", op, res.ID, res.Status, reason))
+		}
+	}
+	if len(failures) > 0 {
+		return nil, s.opt.Logger.Errorf("bulk on index %s has %d failed items: %s", index, len(failures), strings.Join(failures, "; "))
+	}
+	return rejected, nil
+}
+
+// toActions maps an event to bulk actions keyed by the primary key, an update which
+// changes the primary key also deletes the old document.
+func (s *writer) toActions(sourceSchema *schemas.Table, e core.Event) ([]bulkAction, error) {
+	id, err := documentID(sourceSchema, e.Record)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Type {
+	case core.EventTypeDelete:
+		return []bulkAction{{action: "delete", id: id}}, nil
+	case core.EventTypeInsert, core.EventTypeUpdate:
+		doc, err := s.convertObject(e.Record)
+		if err != nil {
+			return nil, err
+		}
+		actions := []bulkAction{{action: "index", id: id, doc: doc}}
+		if e.Type == core.EventTypeUpdate && e.OldRecord != nil {
+			oldID, err := documentID(sourceSchema, *e.OldRecord)
+			if err == nil && oldID != id {
+				actions = append([]bulkAction{{action: "delete", id: oldID}}, actions...)
+			}
+		}
+		return actions, nil
+	}
+	return nil, errors.New("invalid event type")
 }
 
-func (s *writer) convertObject(sourceSchema *schemas.Table, record core.EventRecord) (map[string]interface{}, error) {
-	fields := record.Columns
+func (s *writer) convertObject(record core.EventRecord) (map[string]interface{}, error) {
 	r := make(map[string]interface{})
-	for _, field := range fields {
+	for _, field := range record.Columns {
+		// _id is a metadata field and can not be part of the source document.
+		if field.Name == "_id" {
+			continue
+		}
 		vv, err := typMap.Decode(field.Value)
 		if err != nil {
 			return nil, err
 		}
 		r[field.Name] = vv
 	}
+	return r, nil
+}
+
+func documentID(sourceSchema *schemas.Table, record core.EventRecord) (string, error) {
+	pks := sourceSchema.GetPrimaryKeyNames()
+	if len(pks) < 1 {
+		return "", errors.New("can not find primary key for table " + sourceSchema.Name)
+	}
 	var pk []string
-	for _, col := range sourceSchema.GetPrimaryKeyNames() {
+	for _, col := range pks {
 		f, err := record.FieldByName(col)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		vv, err := typMap.Decode(f.Value)
+		if err != nil {
+			return "", err
 		}
-		vv, _ := typMap.Decode(f.Value)
 		pk = append(pk, fmt.Sprint(vv))
 	}
-	r["_id"] = strings.Join(pk, ":")
-	return r, nil
+	return strings.Join(pk, ":"), nil
 }
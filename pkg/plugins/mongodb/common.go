@@ -0,0 +1,80 @@
+package mongodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type extra struct {
+	AuthSource string `json:"auth_source"`
+	ReplicaSet string `json:"replica_set"`
+}
+
+var (
+	clients      = map[string]*mongo.Client{}
+	clientsMutex sync.Mutex
+)
+
+func parseExtra(connector *model.Connector) (extra, error) {
+	var e extra
+	if connector.Extra == "" {
+		return e, nil
+	}
+	if err := json.Unmarshal([]byte(connector.Extra), &e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+func buildURI(connector *model.Connector) (string, error) {
+	e, err := parseExtra(connector)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{
+		Scheme: "mongodb",
+		Host:   fmt.Sprintf("%s:%d", connector.Host, connector.Port),
+		Path:   "/",
+	}
+	if connector.Username != "" {
+		u.User = url.UserPassword(connector.Username, connector.Password)
+	}
+	query := url.Values{}
+	if e.AuthSource != "" {
+		query.Set("authSource", e.AuthSource)
+	}
+	if e.ReplicaSet != "" {
+		query.Set("replicaSet", e.ReplicaSet)
+	} else {
+		query.Set("directConnection", "true")
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func Connect(connector *model.Connector) (*mongo.Client, error) {
+	uri, err := buildURI(connector)
+	if err != nil {
+		return nil, err
+	}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if client, ok := clients[uri]; ok {
+		return client, nil
+	}
+	client, err := mongo.Connect(options.Client().
+		ApplyURI(uri).
+		SetConnectTimeout(10 * time.Second).
+		SetServerSelectionTimeout(10 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+	clients[uri] = client
+	return client, nil
+}
@@ -0,0 +1,28 @@
+package mongodb
+
+import (
+	"context"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"time"
+)
+
+type connector struct {
+	opt *core.ConnectorOption
+}
+
+func newConnector(ctx context.Context, opt interface{}) core.Connector {
+	return &connector{
+		opt: opt.(*core.ConnectorOption),
+	}
+}
+
+func (s *connector) Test() error {
+	client, err := Connect(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.Ping(ctx, readpref.Primary())
+}
@@ -0,0 +1,176 @@
+package mongodb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// convertDocument flattens the top-level fields of a document into an event record,
+// nested documents and arrays are kept as JSON.
+func convertDocument(doc bson.Raw, sch *schemas.Table) (core.EventRecord, error) {
+	var record core.EventRecord
+	elements, err := doc.Elements()
+	if err != nil {
+		return record, err
+	}
+	for _, element := range elements {
+		val, err := convertValue(element.Value())
+		if err != nil {
+			return record, errors.New("field " + element.Key() + " convert fail," + err.Error())
+		}
+		if sch != nil {
+			if f, ok := sch.GetFieldByName(element.Key()); ok && f.DataType == schemas.TypeString {
+				val = toStringData(val)
+			}
+		}
+		record.Set(element.Key(), val)
+	}
+	return record, nil
+}
+
+// toStringData keeps values of mixed-type fields writable into the string column inferred for them.
+func toStringData(val types.TypedData) types.TypedData {
+	switch val.T {
+	case schemas.TypeString, schemas.TypeNull:
+		return val
+	case schemas.TypeJSON:
+		j, _ := json.Marshal(val.V)
+		return types.NewTypedData(schemas.TypeString, string(j))
+	case schemas.TypeTimestamp:
+		if t, ok := val.V.(time.Time); ok {
+			return types.NewTypedData(schemas.TypeString, t.Format(time.RFC3339Nano))
+		}
+	}
+	return types.NewTypedData(schemas.TypeString, fmt.Sprint(val.V))
+}
+
+func convertValue(rv bson.RawValue) (types.TypedData, error) {
+	switch rv.Type {
+	case bson.TypeNull, bson.TypeUndefined:
+		return types.NewNullData(), nil
+	case bson.TypeObjectID:
+		return typMap.Encode(schemas.TypeString, rv.ObjectID().Hex())
+	case bson.TypeString:
+		return typMap.Encode(schemas.TypeString, rv.StringValue())
+	case bson.TypeSymbol:
+		return typMap.Encode(schemas.TypeString, rv.Symbol())
+	case bson.TypeInt32:
+		return typMap.Encode(schemas.TypeInt, int64(rv.Int32()))
+	case bson.TypeInt64:
+		return typMap.Encode(schemas.TypeInt, rv.Int64())
+	case bson.TypeDouble:
+		return typMap.Encode(schemas.TypeDecimal, rv.Double())
+	case bson.TypeDecimal128:
+		return typMap.Encode(schemas.TypeDecimal, rv.Decimal128().String())
+	case bson.TypeBoolean:
+		return typMap.Encode(schemas.TypeBool, rv.Boolean())
+	case bson.TypeDateTime:
+		return typMap.Encode(schemas.TypeTimestamp, time.UnixMilli(rv.DateTime()).UTC())
+	case bson.TypeTimestamp:
+		t, _ := rv.Timestamp()
+		return typMap.Encode(schemas.TypeTimestamp, time.Unix(int64(t), 0).UTC())
+	case bson.TypeBinary:
+		subtype, data := rv.Binary()
+		if subtype == bson.TypeBinaryUUID && len(data) == 16 {
+			return typMap.Encode(schemas.TypeUUID, data)
+		}
+		return typMap.Encode(schemas.TypeBlob, data)
+	case bson.TypeEmbeddedDocument, bson.TypeArray:
+		j, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: rv}}, false, false)
+		if err != nil {
+			return types.NewNullData(), err
+		}
+		var wrapped struct {
+			V interface{} `json:"v"`
+		}
+		if err := json.Unmarshal(j, &wrapped); err != nil {
+			return types.NewNullData(), err
+		}
+		return typMap.Encode(schemas.TypeJSON, wrapped.V)
+	}
+	return typMap.Encode(schemas.TypeString, rv.String())
+}
+
+// columnOf describes the destination column for a single bson value.
+func columnOf(name string, rv bson.RawValue) schemas.Column {
+	col := schemas.Column{
+		Name:     name,
+		Nullable: true,
+	}
+	switch rv.Type {
+	case bson.TypeNull, bson.TypeUndefined:
+		col.DataType = schemas.TypeNull
+	case bson.TypeObjectID:
+		col.DataType = schemas.TypeString
+		col.SecondlyType = schemas.SecondlyTypeVarChar
+		col.ColumnLength = 24
+	case bson.TypeInt32, bson.TypeInt64:
+		col.DataType = schemas.TypeInt
+		col.SecondlyType = schemas.SecondlyTypeBigInt
+	case bson.TypeDouble:
+		col.DataType = schemas.TypeDecimal
+		col.SecondlyType = schemas.SecondlyTypeFloat
+	case bson.TypeDecimal128:
+		col.DataType = schemas.TypeDecimal
+		col.SecondlyType = schemas.SecondlyTypeDecimal
+		col.NumericPrecision = 38
+		col.NumericScale = 10
+	case bson.TypeBoolean:
+		col.DataType = schemas.TypeBool
+	case bson.TypeDateTime, bson.TypeTimestamp:
+		col.DataType = schemas.TypeTimestamp
+	case bson.TypeBinary:
+		subtype, data := rv.Binary()
+		if subtype == bson.TypeBinaryUUID && len(data) == 16 {
+			col.DataType = schemas.TypeUUID
+		} else {
+			col.DataType = schemas.TypeBlob
+			col.SecondlyType = schemas.SecondlyTypeBlob
+		}
+	case bson.TypeEmbeddedDocument, bson.TypeArray:
+		col.DataType = schemas.TypeJSON
+	default:
+		col.DataType = schemas.TypeString
+		col.SecondlyType = schemas.SecondlyTypeText
+	}
+	return col
+}
+
+// mergeColumn widens a sampled column so that it can hold values of both types.
+func mergeColumn(a schemas.Column, b schemas.Column) schemas.Column {
+	if a.DataType == schemas.TypeNull {
+		b.Index = a.Index
+		return b
+	}
+	if b.DataType == schemas.TypeNull || (a.DataType == b.DataType && a.SecondlyType == b.SecondlyType) {
+		return a
+	}
+	if isNumeric(a) && isNumeric(b) {
+		if a.SecondlyType == schemas.SecondlyTypeDecimal || b.SecondlyType == schemas.SecondlyTypeDecimal {
+			a.DataType = schemas.TypeDecimal
+			a.SecondlyType = schemas.SecondlyTypeDecimal
+			a.NumericPrecision = 38
+			a.NumericScale = 10
+			return a
+		}
+		a.DataType = schemas.TypeDecimal
+		a.SecondlyType = schemas.SecondlyTypeFloat
+		return a
+	}
+	a.DataType = schemas.TypeString
+	a.SecondlyType = schemas.SecondlyTypeText
+	a.ColumnLength = 0
+	a.NumericPrecision = 0
+	a.NumericScale = 0
+	return a
+}
+
+func isNumeric(c schemas.Column) bool {
+	return c.DataType == schemas.TypeInt || c.DataType == schemas.TypeDecimal
+}
@@ -0,0 +1,158 @@
+package mongodb
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testDocument(t *testing.T, d bson.D) bson.Raw {
+	t.Helper()
+	doc, err := bson.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestConvertDocument(t *testing.T) {
+	oid, _ := bson.ObjectIDFromHex("5f1d7f3e9b1e8a3c4d2e1f00")
+	at := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	doc := testDocument(t, bson.D{
+		{Key: "_id", Value: oid},
+		{Key: "name", Value: "Ada"},
+		{Key: "age", Value: int32(36)},
+		{Key: "visits", Value: int64(1 << 40)},
+		{Key: "score", Value: 1.5},
+		{Key: "active", Value: true},
+		{Key: "created_at", Value: bson.NewDateTimeFromTime(at)},
+		{Key: "address", Value: bson.D{{Key: "city", Value: "London"}}},
+		{Key: "tags", Value: bson.A{"a", "b"}},
+		{Key: "deleted_at", Value: nil},
+		{Key: "code", Value: int32(7)},
+	})
+	sch := &schemas.Table{Name: "users", Columns: []schemas.Column{
+		{Name: "code", DataType: schemas.TypeString},
+	}}
+	record, err := convertDocument(doc, sch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		column string
+		typ    schemas.Type
+		want   interface{}
+	}{
+		{"_id", schemas.TypeString, "5f1d7f3e9b1e8a3c4d2e1f00"},
+		{"name", schemas.TypeString, "Ada"},
+		{"age", schemas.TypeInt, int64(36)},
+		{"visits", schemas.TypeInt, int64(1 << 40)},
+		{"score", schemas.TypeDecimal, 1.5},
+		{"active", schemas.TypeBool, true},
+		{"created_at", schemas.TypeTimestamp, at},
+		{"address", schemas.TypeJSON, map[string]interface{}{"city": "London"}},
+		{"tags", schemas.TypeJSON, []interface{}{"a", "b"}},
+		{"deleted_at", schemas.TypeNull, nil},
+		// fields inferred as strings keep values of other types writable.
+		{"code", schemas.TypeString, "7"},
+	}
+	for _, c := range cases {
+		field, err := record.FieldByName(c.column)
+		if err != nil {
+			t.Errorf("column %s is missing", c.column)
+			continue
+		}
+		if field.Value.T != c.typ || !reflect.DeepEqual(field.Value.V, c.want) {
+			t.Errorf("column %s = %v %#v, want %v %#v", c.column, field.Value.T, field.Value.V, c.typ, c.want)
+		}
+	}
+}
+
+type testSubscriber struct {
+	events []core.Event
+}
+
+func (s *testSubscriber) ReaderEvent(e core.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+type testSchemaManager struct{}
+
+func (testSchemaManager) Get(dbname string, tableName string) *schemas.Table {
+	return nil
+}
+
+func (testSchemaManager) CreateTable(table *schemas.Table) error {
+	return nil
+}
+
+func TestChangeEvent(t *testing.T) {
+	full := bson.D{{Key: "_id", Value: int64(1)}, {Key: "name", Value: "Ada"}}
+	key := bson.D{{Key: "_id", Value: int64(1)}}
+	ns := bson.D{{Key: "db", Value: "shop"}, {Key: "coll", Value: "users"}}
+	cases := []struct {
+		op      string
+		full    bson.D
+		skipped bool
+		typ     core.EventType
+		columns []string
+	}{
+		{"insert", full, false, core.EventTypeInsert, []string{"_id", "name"}},
+		{"update", full, false, core.EventTypeUpdate, []string{"_id", "name"}},
+		{"replace", full, false, core.EventTypeUpdate, []string{"_id", "name"}},
+		{"update", nil, true, 0, nil},
+		{"delete", nil, false, core.EventTypeDelete, []string{"_id"}},
+		{"drop", nil, true, 0, nil},
+	}
+	for _, c := range cases {
+		subscriber := &testSubscriber{}
+		r := &reader{
+			opt: &core.ReaderOption{
+				Subscriber: subscriber,
+				Logger:     core.NewFileLog("", core.LevelInfo),
+				Task:       &model.Task{},
+			},
+			schemaManager: testSchemaManager{},
+		}
+		ev := bson.D{
+			{Key: "operationType", Value: c.op},
+			{Key: "ns", Value: ns},
+			{Key: "documentKey", Value: key},
+			{Key: "clusterTime", Value: bson.Timestamp{T: 1714566600, I: 1}},
+		}
+		if c.full != nil {
+			ev = append(ev, bson.E{Key: "fullDocument", Value: c.full})
+		}
+		if err := r.handler(testDocument(t, ev), "token"); err != nil {
+			t.Fatalf("%s: %s", c.op, err)
+		}
+		if c.skipped {
+			if len(subscriber.events) != 0 {
+				t.Errorf("%s: event should be skipped", c.op)
+			}
+			continue
+		}
+		if len(subscriber.events) != 1 {
+			t.Fatalf("%s: got %d events", c.op, len(subscriber.events))
+		}
+		e := subscriber.events[0]
+		var columns []string
+		for _, col := range e.Record.Columns {
+			columns = append(columns, col.Name)
+		}
+		if e.Type != c.typ || !reflect.DeepEqual(columns, c.columns) {
+			t.Errorf("%s: type %v columns %v, want %v %v", c.op, e.Type, columns, c.typ, c.columns)
+		}
+		if e.SourceSchema.Name != "users" || e.LastPOS != "token" || e.CommitTime.Unix() != 1714566600 {
+			t.Errorf("%s: table %s position %s time %s", c.op, e.SourceSchema.Name, e.LastPOS, e.CommitTime)
+		}
+		if pk := e.SourceSchema.GetPrimaryKeyNames(); !reflect.DeepEqual(pk, []string{"_id"}) {
+			t.Errorf("%s: primary keys %v", c.op, pk)
+		}
+	}
+}
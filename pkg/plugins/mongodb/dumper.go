@@ -0,0 +1,200 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+type dumper struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	opt           *core.DumperOption
+	db            *mongo.Database
+	schemaManager core.SchemaManager
+	stopped       bool
+}
+
+func newDumper(ctx context.Context, opts interface{}) core.Dumper {
+	c, cancel := context.WithCancel(ctx)
+	d := &dumper{
+		ctx:    c,
+		cancel: cancel,
+		opt:    opts.(*core.DumperOption),
+	}
+	d.schemaManager = core.NewCachedSchemaManager(newSchema(ctx, &core.SchemaOption{
+		Connector: d.opt.Connector,
+		Logger:    d.opt.Logger,
+	}))
+	return d
+}
+
+func (d *dumper) Prepare() error {
+	client, err := Connect(d.opt.Connector)
+	if err != nil {
+		return d.opt.Logger.Errorf("can not connector to %s, %s", d.opt.Connector.Name, err)
+	}
+	d.db = client.Database(d.opt.Connector.Database)
+	return nil
+}
+
+func (d *dumper) Stop() error {
+	d.stopped = true
+	d.cancel()
+	return nil
+}
+
+func (d *dumper) StartDumpTable(table *model.TaskTable) error {
+	sch := d.schemaManager.Get(d.opt.Connector.Database, table.Table)
+	if sch == nil {
+		return d.opt.Logger.Errorf("start dumper failed,can not find schema for table %s", table.Table)
+	}
+	if len(sch.Columns) == 0 {
+		d.opt.Logger.Info("skip dump table %s, because of the collection is empty", table.Table)
+		return nil
+	}
+	var lastID interface{}
+	if table.LastDumperKey != "" {
+		var err error
+		if lastID, err = parseDumperKey(table.LastDumperKey); err != nil {
+			return d.opt.Logger.Errorf("can not parse dumper key of table %s, %s", table.Table, err)
+		}
+	}
+	total := 0
+	batchSize := d.opt.BatchSize
+	var lastLogAt time.Time
+	for {
+		if d.stopped {
+			d.opt.Logger.Info("stop dump, because of received stop signal")
+			return nil
+		}
+		now := time.Now()
+		batch, last, err := d.queryBatch(sch, batchSize, lastID)
+		if err != nil {
+			return d.opt.Logger.Errorf("dump failed,can not query batch %v", err)
+		}
+		if len(batch) > 0 {
+			if err := d.opt.Subscriber.DumperEvent(sch, batch); err != nil {
+				return d.opt.Logger.Errorf("dump failed,can not handle batch %v", err)
+			}
+			total += len(batch)
+			lastID = last
+			if subscriber, ok := d.opt.Subscriber.(core.DumperKeySubscriber); ok {
+				key, err := newDumperKey(last)
+				if err != nil {
+					return d.opt.Logger.Errorf("dump failed,can not encode dumper key %v", err)
+				}
+				subscriber.DumperKey(sch.Name, key)
+			}
+		}
+		if now.Sub(lastLogAt) > 30*time.Second {
+			lastLogAt = now
+			d.opt.Logger.Info("successful dump table %s, records %d", table.Table, total)
+		}
+		if len(batch) < batchSize {
+			d.opt.Logger.Info("successful dump records %d on table %s,", total, table.Table)
+			break
+		}
+	}
+	return nil
+}
+
+// queryBatch scans the collection ordered by _id, it returns the raw _id of the last document
+// so the next batch keeps the original bson type.
+func (d *dumper) queryBatch(sch *schemas.Table, batchSize int, lastID interface{}) ([]core.EventRecord, bson.RawValue, error) {
+	filter := bson.D{}
+	if lastID != nil {
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: lastID}}}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+	cursor, err := d.db.Collection(sch.Name).Find(d.ctx, filter, opts)
+	if err != nil {
+		return nil, bson.RawValue{}, d.opt.Logger.Errorf("failed run batch on table %s,%s", sch.Name, err)
+	}
+	defer cursor.Close(d.ctx)
+	var ret []core.EventRecord
+	var last bson.RawValue
+	for cursor.Next(d.ctx) {
+		record, err := convertDocument(cursor.Current, sch)
+		if err != nil {
+			return nil, bson.RawValue{}, err
+		}
+		ret = append(ret, record)
+		last = cursor.Current.Lookup("_id")
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, bson.RawValue{}, err
+	}
+	// the value refers to the buffer of the cursor.
+	last.Value = append([]byte(nil), last.Value...)
+	return ret, last, nil
+}
+
+// dumperKey is the _id of the last dumped document, the value is canonical extended json
+// with the bson type, so an object id and a string of 24 hex digits are resumed as they are.
+type dumperKey struct {
+	ID       json.RawMessage `json:"_id"`
+	BSONType bson.Type       `json:"bson_type"`
+}
+
+func newDumperKey(id bson.RawValue) (dumperKey, error) {
+	doc, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}}, true, false)
+	if err != nil {
+		return dumperKey{}, err
+	}
+	var key dumperKey
+	if err := json.Unmarshal(doc, &key); err != nil {
+		return dumperKey{}, err
+	}
+	key.BSONType = id.Type
+	return key, nil
+}
+
+// parseDumperKey restores the _id saved as last dumper key. Keys saved without the bson type are the
+// primary keys of the last record, object ids are hex strings in them.
+func parseDumperKey(value string) (interface{}, error) {
+	var key dumperKey
+	if err := json.Unmarshal([]byte(value), &key); err != nil {
+		return nil, err
+	}
+	if key.BSONType == 0 {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, err
+		}
+		return parseRecordKey(record["_id"]), nil
+	}
+	var doc bson.Raw
+	if err := bson.UnmarshalExtJSON([]byte(`{"_id":`+string(key.ID)+`}`), true, &doc); err != nil {
+		return nil, err
+	}
+	id := doc.Lookup("_id")
+	if id.Type != key.BSONType {
+		return nil, fmt.Errorf("_id %s is %s, expected %s", key.ID, id.Type, key.BSONType)
+	}
+	return id, nil
+}
+
+// parseRecordKey restores the _id of a key saved before the bson type was kept.
+func parseRecordKey(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		if id, err := bson.ObjectIDFromHex(val); err == nil && len(val) == 24 {
+			return id
+		}
+		return val
+	case float64:
+		if val == float64(int64(val)) {
+			return int64(val)
+		}
+		return val
+	}
+	return v
+}
@@ -0,0 +1,78 @@
+package mongodb
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"reflect"
+	"testing"
+)
+
+func rawID(t *testing.T, v interface{}) bson.RawValue {
+	t.Helper()
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: v}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.Raw(doc).Lookup("_id")
+}
+
+func TestDumperKey(t *testing.T) {
+	oid, _ := bson.ObjectIDFromHex("5f1d7f3e9b1e8a3c4d2e1f00")
+	cases := []struct {
+		name string
+		id   interface{}
+	}{
+		{"object id", oid},
+		{"hex string", "5f1d7f3e9b1e8a3c4d2e1f00"},
+		{"string", "order-1"},
+		{"int32", int32(7)},
+		{"int64", int64(9007199254740993)},
+		{"double", 1.5},
+		{"document", bson.D{{Key: "a", Value: int32(1)}}},
+	}
+	for _, c := range cases {
+		id := rawID(t, c.id)
+		key, err := newDumperKey(id)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		// the key is saved as json by the task.
+		saved, err := json.Marshal(key)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		parsed, err := parseDumperKey(string(saved))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		got, ok := parsed.(bson.RawValue)
+		if !ok || !got.Equal(id) {
+			t.Errorf("%s: %s resumed as %v", c.name, saved, parsed)
+		}
+	}
+}
+
+func TestParseRecordDumperKey(t *testing.T) {
+	oid, _ := bson.ObjectIDFromHex("5f1d7f3e9b1e8a3c4d2e1f00")
+	cases := []struct {
+		key  string
+		want interface{}
+	}{
+		{`{"_id":"5f1d7f3e9b1e8a3c4d2e1f00"}`, oid},
+		{`{"_id":"order-1"}`, "order-1"},
+		{`{"_id":7}`, int64(7)},
+		{`{"_id":1.5}`, 1.5},
+	}
+	for _, c := range cases {
+		got, err := parseDumperKey(c.key)
+		if err != nil {
+			t.Fatalf("%s: %s", c.key, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s resumed as %#v, want %#v", c.key, got, c.want)
+		}
+	}
+	if _, err := parseDumperKey(`{"_id":"5f1d7f3e9b1e8a3c4d2e1f00","bson_type":7}`); err == nil {
+		t.Error("a string saved as an object id should be rejected")
+	}
+}
@@ -0,0 +1,16 @@
+package mongodb
+
+import "github.com/imiskolee/anycdc/pkg/core"
+
+const pluginName = "mongodb"
+
+func init() {
+	core.RegisterPlugin(pluginName, core.Plugin{
+		Name:             pluginName,
+		SchemaFactory:    newSchema,
+		ReaderFactory:    newReader,
+		WriterFactory:    newWriter,
+		DumperFactory:    newDumper,
+		ConnectorFactory: newConnector,
+	})
+}
@@ -0,0 +1,311 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	Ns            struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey  bson.Raw       `bson:"documentKey"`
+	FullDocument bson.Raw       `bson:"fullDocument"`
+	ClusterTime  bson.Timestamp `bson:"clusterTime"`
+}
+
+type reader struct {
+	opt           *core.ReaderOption
+	ctx           context.Context
+	cancel        context.CancelFunc
+	client        *mongo.Client
+	db            *mongo.Database
+	schemaManager core.SchemaManager
+	latestToken   string
+	realToken     string
+	retries       int
+	running       bool
+	reopen        atomic.Bool
+	lastEventAt   *time.Time
+	lastSaveAt    time.Time
+}
+
+func newReader(ctx context.Context, opts interface{}) core.Reader {
+	c, cancel := context.WithCancel(ctx)
+	o := opts.(*core.ReaderOption)
+	return &reader{
+		ctx:        c,
+		cancel:     cancel,
+		opt:        o,
+		lastSaveAt: time.Now(),
+		schemaManager: core.NewCachedSchemaManager(newSchema(ctx, &core.SchemaOption{
+			Connector: o.Connector,
+			Logger:    o.Logger,
+		})),
+	}
+}
+
+func (r *reader) Prepare() error {
+	client, err := Connect(r.opt.Connector)
+	if err != nil {
+		return r.opt.Logger.Errorf("can not prepare reader connection: %v", err)
+	}
+	r.client = client
+	r.db = client.Database(r.opt.Connector.Database)
+	return nil
+}
+
+func (r *reader) pipeline() mongo.Pipeline {
+	var collections []string
+	for _, v := range r.opt.GetTables() {
+		collections = append(collections, v.SourceTable)
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: collections}}},
+			{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update", "replace", "delete"}}}},
+		}}},
+	}
+}
+
+func (r *reader) watch(token string) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(5 * time.Second)
+	if token != "" {
+		opts.SetResumeAfter(bson.D{{Key: "_data", Value: token}})
+	}
+	return r.db.Watch(r.ctx, r.pipeline(), opts)
+}
+
+func (r *reader) Start() error {
+	r.opt.Logger.Info("starting reader for task %s", r.opt.Task.Name)
+	r.running = true
+	defer (func() {
+		_ = r.Stop()
+	})()
+	r.latestToken = r.opt.Task.LastCDCPosition
+	stream, err := r.watch(r.latestToken)
+	if err != nil {
+		return r.opt.Logger.Errorf("can not open change stream: %v", err)
+	}
+	if r.latestToken == "" {
+		r.latestToken = resumeTokenData(stream.ResumeToken())
+	}
+	r.realToken = r.latestToken
+	var loopError error
+	for {
+		if r.retries > 10 {
+			loopError = errors.New("reader stopped,because of too many fails")
+			break
+		}
+		select {
+		case <-r.ctx.Done():
+			_ = stream.Close(context.Background())
+			return nil
+		default:
+		}
+		// the pipeline matches the collections, it's reopened from the real position when they change.
+		if r.reopen.Swap(false) && stream != nil {
+			_ = stream.Close(context.Background())
+			stream = nil
+		}
+		if stream == nil {
+			time.Sleep(time.Duration(r.retries) * time.Second)
+			if stream, err = r.watch(r.realToken); err != nil {
+				r.opt.Logger.Error("can not reopen change stream: %s", err)
+				stream = nil
+				r.retries++
+				continue
+			}
+		}
+		if !stream.TryNext(r.ctx) {
+			if err := stream.Err(); err != nil {
+				if r.ctx.Err() != nil {
+					continue
+				}
+				r.opt.Logger.Error("failed receive change event: %s", err)
+				_ = stream.Close(context.Background())
+				stream = nil
+				r.retries++
+				continue
+			}
+			r.advance(resumeTokenData(stream.ResumeToken()))
+			continue
+		}
+		token := resumeTokenData(stream.ResumeToken())
+		for i := 0; i < 10; i++ {
+			if loopError = r.handler(stream.Current, token); loopError != nil {
+				r.opt.Logger.Error("failed handler change event: %s", loopError)
+				time.Sleep(time.Duration(i+10) * time.Second)
+				continue
+			}
+			break
+		}
+		if loopError != nil {
+			break
+		}
+		r.retries = 0
+		r.advance(token)
+	}
+	if stream != nil {
+		_ = stream.Close(context.Background())
+	}
+	return loopError
+}
+
+// advance moves the real position, the persisted one follows it after the cdc delay time.
+func (r *reader) advance(token string) {
+	if token == "" {
+		return
+	}
+	r.realToken = token
+	now := time.Now()
+	if now.Sub(r.lastSaveAt) > time.Duration(r.opt.Task.CDCDelayTime)*time.Minute {
+		r.lastSaveAt = now
+		r.latestToken = token
+	}
+}
+
+func (r *reader) handler(raw bson.Raw, token string) error {
+	var ev changeEvent
+	if err := bson.Unmarshal(raw, &ev); err != nil {
+		return r.opt.Logger.Errorf("can not decode change event %s", err)
+	}
+	var e core.Event
+	sch := r.schemaManager.Get(ev.Ns.DB, ev.Ns.Coll)
+	switch ev.OperationType {
+	case "insert", "update", "replace":
+		if ev.FullDocument == nil {
+			r.opt.Logger.Info("skip %s event on %s, because of the document has been deleted", ev.OperationType, ev.Ns.Coll)
+			return nil
+		}
+		sch = r.ensureSchema(sch, ev.Ns.Coll, ev.FullDocument)
+		record, err := convertDocument(ev.FullDocument, sch)
+		if err != nil {
+			return r.opt.Logger.Errorf("can not parse %s event into event record %s", ev.OperationType, err)
+		}
+		e.Type = core.EventTypeUpdate
+		if ev.OperationType == "insert" {
+			e.Type = core.EventTypeInsert
+		}
+		e.Record = record
+	case "delete":
+		sch = r.ensureSchema(sch, ev.Ns.Coll, ev.DocumentKey)
+		record, err := convertDocument(ev.DocumentKey, sch)
+		if err != nil {
+			return r.opt.Logger.Errorf("can not parse delete event into event record %s", err)
+		}
+		e.Type = core.EventTypeDelete
+		e.Record = record
+	default:
+		return nil
+	}
+	e.SourceSchema = *sch
+	e.LastPOS = token
+	e.CommitTime = time.Unix(int64(ev.ClusterTime.T), 0)
+	if err := r.opt.Subscriber.ReaderEvent(e); err != nil {
+		return r.opt.Logger.Errorf("can not consume event %s", err)
+	}
+	if r.lastEventAt == nil {
+		r.lastEventAt = new(time.Time)
+	}
+	*r.lastEventAt = time.Unix(int64(ev.ClusterTime.T), 0)
+	return nil
+}
+
+// ensureSchema falls back to the event document when the sampled schema has no _id,
+// which happens when the collection was empty while sampling.
+func (r *reader) ensureSchema(sch *schemas.Table, coll string, doc bson.Raw) *schemas.Table {
+	if sch != nil && sch.Exists("_id") {
+		return sch
+	}
+	t, err := inferTable(coll, doc)
+	if err != nil {
+		return &schemas.Table{Name: coll}
+	}
+	return t
+}
+
+func resumeTokenData(token bson.Raw) string {
+	if token == nil {
+		return ""
+	}
+	v, err := token.LookupErr("_data")
+	if err != nil {
+		return ""
+	}
+	s, _ := v.StringValueOK()
+	return s
+}
+
+func (r *reader) Stop() error {
+	if !r.running {
+		return nil
+	}
+	r.running = false
+	r.cancel()
+	time.Sleep(1 * time.Second)
+	return nil
+}
+
+func (r *reader) LatestPosition() core.ReaderPosition {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := r.db.Watch(ctx, r.pipeline(), options.ChangeStream().SetMaxAwaitTime(time.Second))
+	if err != nil {
+		r.opt.Logger.Error("can not get latest position %s", err)
+		return core.ReaderPosition{}
+	}
+	defer stream.Close(context.Background())
+	stream.TryNext(ctx)
+	return core.ReaderPosition{
+		Position: resumeTokenData(stream.ResumeToken()),
+	}
+}
+
+func (r *reader) CurrentPosition() core.ReaderPosition {
+	return core.ReaderPosition{
+		Position:    r.latestToken,
+		LastEventAt: r.lastEventAt,
+	}
+}
+
+// ComparePosition compares resume tokens, their hex encoded data sorts in the order of the oplog.
+func (r *reader) ComparePosition(a string, b string) int {
+	return strings.Compare(a, b)
+}
+
+// PositionSequence returns the cluster time of a resume token, its hex encoded data starts with the
+// timestamp type (82) and the 8 bytes of the timestamp. Events of one transaction share their cluster time.
+func (r *reader) PositionSequence(pos string) (int64, bool) {
+	if len(pos) < 18 || !strings.HasPrefix(pos, "82") {
+		return 0, false
+	}
+	ts, err := strconv.ParseUint(pos[2:18], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int64(ts), true
+}
+
+func (r *reader) Release() error {
+	return nil
+}
+
+// AddTables watches more collections, the change stream is reopened with them.
+func (r *reader) AddTables(tables []string) error {
+	r.reopen.Store(true)
+	return nil
+}
@@ -0,0 +1,132 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+const (
+	sampleSize            = 100
+	errCodeNamespaceExist = 48
+)
+
+type schema struct {
+	opt *core.SchemaOption
+}
+
+func newSchema(ctx context.Context, opt interface{}) core.SchemaManager {
+	return &schema{opt: opt.(*core.SchemaOption)}
+}
+
+// Get infers the table schema by sampling documents of the collection.
+func (s *schema) Get(dbname string, tableName string) *schemas.Table {
+	client, err := Connect(s.opt.Connector)
+	if err != nil {
+		s.opt.Logger.Error("can not connect to mongodb:%s %v", s.opt.Connector.Name, err)
+		return nil
+	}
+	if dbname == "" {
+		dbname = s.opt.Connector.Database
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	cursor, err := client.Database(dbname).Collection(tableName).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: sampleSize}}}},
+	})
+	if err != nil {
+		s.opt.Logger.Error("can not sample collection %s, %s", tableName, err)
+		return &schemas.Table{}
+	}
+	defer cursor.Close(ctx)
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		s.opt.Logger.Error("can not sample collection %s, %s", tableName, err)
+		return &schemas.Table{}
+	}
+	table, err := inferTable(tableName, docs...)
+	if err != nil {
+		s.opt.Logger.Error("can not infer schema of collection %s, %s", tableName, err)
+		return &schemas.Table{}
+	}
+	return table
+}
+
+// inferTable merges the top-level fields of documents into a table, _id is the primary key.
+func inferTable(name string, docs ...bson.Raw) (*schemas.Table, error) {
+	table := schemas.Table{
+		Name: name,
+	}
+	positions := make(map[string]int)
+	for _, doc := range docs {
+		elements, err := doc.Elements()
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			col := columnOf(element.Key(), element.Value())
+			idx, ok := positions[col.Name]
+			if !ok {
+				col.Index = uint(len(table.Columns))
+				positions[col.Name] = len(table.Columns)
+				table.Columns = append(table.Columns, col)
+				continue
+			}
+			table.Columns[idx] = mergeColumn(table.Columns[idx], col)
+		}
+	}
+	for i := range table.Columns {
+		col := &table.Columns[i]
+		if col.DataType == schemas.TypeNull {
+			col.DataType = schemas.TypeString
+			col.SecondlyType = schemas.SecondlyTypeText
+		}
+		if col.Name != "_id" {
+			continue
+		}
+		col.IsPrimaryKey = true
+		col.Nullable = false
+		// primary keys can not be text on most destinations.
+		if col.DataType == schemas.TypeString && col.ColumnLength == 0 {
+			col.SecondlyType = schemas.SecondlyTypeVarChar
+			col.ColumnLength = 255
+		}
+	}
+	return &table, nil
+}
+
+func (s *schema) CreateTable(table *schemas.Table) error {
+	client, err := Connect(s.opt.Connector)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.Database(s.opt.Connector.Database).CreateCollection(ctx, table.Name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.HasErrorCode(errCodeNamespaceExist) {
+		return nil
+	}
+	return err
+}
+
+// ListTables lists the collections of the database.
+func (s *schema) ListTables(dbname string) ([]string, error) {
+	client, err := Connect(s.opt.Connector)
+	if err != nil {
+		return nil, err
+	}
+	if dbname == "" {
+		dbname = s.opt.Connector.Database
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	return client.Database(dbname).ListCollectionNames(ctx, bson.D{{Key: "type", Value: "collection"}})
+}
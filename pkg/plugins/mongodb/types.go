@@ -0,0 +1,31 @@
+package mongodb
+
+import (
+	"encoding/json"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+)
+
+var typMap *types.Map = types.NewDefaultTypeMap()
+
+func init() {
+	typMap.RegisterDecoder(schemas.TypeJSON, jsonDecode)
+}
+
+// jsonDecode keeps JSON columns as embedded documents instead of strings.
+func jsonDecode(v interface{}) (interface{}, error) {
+	var raw []byte
+	switch val := v.(type) {
+	case string:
+		raw = []byte(val)
+	case []byte:
+		raw = val
+	default:
+		return v, nil
+	}
+	var dest interface{}
+	if err := json.Unmarshal(raw, &dest); err != nil {
+		return nil, err
+	}
+	return dest, nil
+}
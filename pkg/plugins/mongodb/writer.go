@@ -0,0 +1,150 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"time"
+)
+
+const writeTimeout = 60 * time.Second
+
+type writer struct {
+	opt    *core.WriterOption
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func newWriter(ctx context.Context, opt interface{}) core.Writer {
+	return &writer{
+		opt: opt.(*core.WriterOption),
+	}
+}
+
+func (w *writer) Prepare() error {
+	client, err := Connect(w.opt.Connector)
+	if err != nil {
+		return w.opt.Logger.Errorf("can not connect to mongodb connector: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return w.opt.Logger.Errorf("can not ping mongodb connector: %v", err)
+	}
+	w.client = client
+	w.db = client.Database(w.opt.Connector.Database)
+	return nil
+}
+
+func (w *writer) Execute(e core.Event) error {
+	e = core.WithMetadata(w.opt.Task, e)
+	model, err := w.toWriteModel(&e.SourceSchema, e)
+	if err != nil {
+		return w.opt.Logger.Errorf("can not convert event: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	coll := w.db.Collection(e.DestinationTableName)
+	switch m := model.(type) {
+	case *mongo.ReplaceOneModel:
+		_, err = coll.ReplaceOne(ctx, m.Filter, m.Replacement, options.Replace().SetUpsert(true))
+	case *mongo.DeleteOneModel:
+		_, err = coll.DeleteOne(ctx, m.Filter)
+	}
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot execute on collection %s: %v", e.DestinationTableName, err)
+	}
+	return nil
+}
+
+func (w *writer) ExecuteBatch(sourceSchema *schemas.Table, records []core.Event) error {
+	if len(records) < 1 {
+		return nil
+	}
+	collection := records[0].DestinationTableName
+	models := make([]mongo.WriteModel, 0, len(records))
+	for _, record := range records {
+		model, err := w.toWriteModel(sourceSchema, core.WithMetadata(w.opt.Task, record))
+		if err != nil {
+			return w.opt.Logger.Errorf("can not convert event: %v", err)
+		}
+		models = append(models, model)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	_, err := w.db.Collection(collection).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot execute bulk write on collection %s: %v", collection, err)
+	}
+	w.opt.Logger.Debug("Successfully executed bulk write, records = %d", len(models))
+	return nil
+}
+
+// Flush is a no-op, events are written when they arrive.
+func (w *writer) Flush() error {
+	return nil
+}
+
+func (w *writer) toWriteModel(sch *schemas.Table, e core.Event) (mongo.WriteModel, error) {
+	id, err := documentID(sch, e.Record)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.D{{Key: "_id", Value: id}}
+	switch e.Type {
+	case core.EventTypeInsert, core.EventTypeUpdate:
+		doc, err := toDocument(e.Record)
+		if err != nil {
+			return nil, err
+		}
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true), nil
+	case core.EventTypeDelete:
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
+	}
+	return nil, errors.New("invalid event type")
+}
+
+// documentID maps the source primary key to _id, composite keys become a subdocument.
+func documentID(sch *schemas.Table, record core.EventRecord) (interface{}, error) {
+	pks := sch.GetPrimaryKeyNames()
+	if len(pks) < 1 {
+		return nil, errors.New("can not find primary key for table " + sch.Name)
+	}
+	var id bson.D
+	for _, pk := range pks {
+		f, err := record.FieldByName(pk)
+		if err != nil {
+			return nil, err
+		}
+		v, err := typMap.Decode(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(pks) == 1 {
+			return v, nil
+		}
+		id = append(id, bson.E{Key: pk, Value: v})
+	}
+	return id, nil
+}
+
+func toDocument(record core.EventRecord) (bson.D, error) {
+	doc := make(bson.D, 0, len(record.Columns))
+	for _, field := range record.Columns {
+		if field.Name == "_id" {
+			continue
+		}
+		v, err := typMap.Decode(field.Value)
+		if err != nil {
+			return nil, errors.New("field " + field.Name + " decode fail," + err.Error())
+		}
+		doc = append(doc, bson.E{Key: field.Name, Value: v})
+	}
+	return doc, nil
+}
@@ -44,7 +44,7 @@ func batchUpsert(connector *model.Connector, sch *schemas.Table, typeMap *types.
 				val = nil
 			}
 
-			if connector.Type == model.ConnectorTypeStarRocks {
+			if connector.Type == model.ConnectorTypeStarRocks || connector.Type == model.ConnectorTypeDoris {
 				if val == nil {
 					if !column.Nullable {
 						switch column.DataType {
@@ -77,7 +77,7 @@ func batchUpsert(connector *model.Connector, sch *schemas.Table, typeMap *types.
 			strings.Join(placeHolders, ","),
 			strings.Join(updateClause, ","),
 		)
-	} else if connector.Type == model.ConnectorTypeStarRocks {
+	} else if connector.Type == model.ConnectorTypeStarRocks || connector.Type == model.ConnectorTypeDoris {
 		rawSQL = fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s",
 			sch.Name,
 			strings.Join(columns, ","),
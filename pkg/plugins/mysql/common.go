@@ -28,7 +28,7 @@ func Connect(connector *model.Connector) (*gorm.DB, error) {
 	})
 
 	if err != nil {
-		core.SysLogger.Error("failed connect source database (%s), err=%s", connector, err)
+		core.SysLogger.Error("failed connect source database (%s), err=%s", connector.Name, err)
 		return nil, err
 	}
 	common_sql.SetCachedConnection(dsn, db)
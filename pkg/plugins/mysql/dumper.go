@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/expr"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/core/types"
 	"github.com/imiskolee/anycdc/pkg/model"
@@ -51,6 +52,10 @@ func (d *dumper) StartDumpTable(table *model.TaskTable) error {
 	if len(primaryKeys) == 0 {
 		return d.opt.Logger.Errorf("start dumper failed,can not find primary keys for table %s", table.Table)
 	}
+	filter, err := common_sql.TableFilter(d.opt.Task, table.Table)
+	if err != nil {
+		return d.opt.Logger.Errorf("start dumper failed,can not parse filter of table %s, %s", table.Table, err)
+	}
 	var lastRecord *core.EventRecord
 	if table.LastDumperKey != "" {
 		record := make(map[string]interface{})
@@ -75,7 +80,7 @@ func (d *dumper) StartDumpTable(table *model.TaskTable) error {
 			return nil
 		}
 		now := time.Now()
-		batch, err := d.queryBatch(sch, batchSize, lastRecord)
+		batch, err := d.queryBatch(sch, batchSize, lastRecord, filter)
 		if err != nil {
 			return d.opt.Logger.Errorf("dump failed,can not query batch %v", err)
 		}
@@ -103,9 +108,9 @@ func (d *dumper) StartDumpTable(table *model.TaskTable) error {
 	return nil
 }
 
-func (d *dumper) queryBatch(sch *schemas.Table, batchSize int, lastRecord *core.EventRecord) ([]core.EventRecord, error) {
+func (d *dumper) queryBatch(sch *schemas.Table, batchSize int, lastRecord *core.EventRecord, filter *expr.Expr) ([]core.EventRecord, error) {
 	generator := common_sql.NewSQLGenerator(d.opt.Connector, sch, types.NewDefaultTypeMap())
-	sql, vals, err := generator.Dumper(batchSize, lastRecord)
+	sql, vals, err := generator.Dumper(batchSize, lastRecord, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mysql errors returned when local_infile is disabled on the server.
+const (
+	errNotAllowedCommand        = 1148
+	errClientLocalFilesDisabled = 3948
+)
+
+var errLocalInfileDisabled = errors.New("local_infile is disabled")
+
+// loadData streams records as tsv through LOAD DATA LOCAL INFILE, existing rows are replaced,
+// or kept with the ignore conflict policy.
+func (w *writer) loadData(sch *schemas.Table, records []core.EventRecord) error {
+	modifier := "REPLACE"
+	if w.opt.Task != nil && w.opt.Task.GetWriterPolicy().OnConflict == model.WriterOnConflictIgnore {
+		modifier = "IGNORE"
+	}
+	var columns []string
+	for _, col := range sch.Columns {
+		columns = append(columns, fmt.Sprintf("`%s`", col.Name))
+	}
+	pr, pw := io.Pipe()
+	go (func() {
+		for _, record := range records {
+			line, err := tsvLine(sch, record)
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.WriteString(pw, line); err != nil {
+				return
+			}
+		}
+		_ = pw.Close()
+	})()
+	name := "anycdc_" + uuid.New().String()
+	driver.RegisterReaderHandler(name, func() io.Reader {
+		return pr
+	})
+	defer driver.DeregisterReaderHandler(name)
+	defer pr.Close()
+
+	sql := fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' %s INTO TABLE `%s` CHARACTER SET utf8mb4 "+
+		"FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (%s)",
+		name,
+		modifier,
+		sch.Name,
+		strings.Join(columns, ","),
+	)
+	err := w.conn.Exec(sql).Error
+	var mysqlErr *driver.MySQLError
+	if errors.As(err, &mysqlErr) && (mysqlErr.Number == errNotAllowedCommand || mysqlErr.Number == errClientLocalFilesDisabled) {
+		return errLocalInfileDisabled
+	}
+	return err
+}
+
+func tsvLine(sch *schemas.Table, record core.EventRecord) (string, error) {
+	fields := make([]string, len(sch.Columns))
+	for i, col := range sch.Columns {
+		fields[i] = `\N`
+		f, err := record.FieldByName(col.Name)
+		if err != nil {
+			continue
+		}
+		val, err := dataTypes.Decode(f.Value)
+		if err != nil {
+			return "", err
+		}
+		if val == nil {
+			continue
+		}
+		fields[i] = tsvEscape(tsvValue(col, val))
+	}
+	return strings.Join(fields, "\t") + "\n", nil
+}
+
+func tsvValue(col schemas.Column, val interface{}) string {
+	switch v := val.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return string(v)
+	case time.Time:
+		switch col.DataType {
+		case schemas.TypeDate:
+			return v.Format(time.DateOnly)
+		case schemas.TypeTime:
+			return v.Format("15:04:05.999999")
+		}
+		return v.Format("2006-01-02 15:04:05.999999")
+	}
+	return fmt.Sprint(val)
+}
+
+var tsvReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x00", `\0`,
+)
+
+func tsvEscape(s string) string {
+	return tsvReplacer.Replace(s)
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"gorm.io/gorm"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -222,6 +224,31 @@ func (r *reader) CurrentPosition() core.ReaderPosition {
 	return core.ReaderPosition{Position: string(j), LastEventAt: r.lastEventAt}
 }
 
+func (r *reader) ComparePosition(a string, b string) int {
+	var pa, pb mysql.Position
+	_ = json.Unmarshal([]byte(a), &pa)
+	_ = json.Unmarshal([]byte(b), &pb)
+	return pa.Compare(pb)
+}
+
+// PositionSequence maps a position to the index of its binlog file in the high bits and the offset in
+// the low 32 bits, offsets are below max_binlog_size, which is at most 1GB.
+func (r *reader) PositionSequence(pos string) (int64, bool) {
+	var p mysql.Position
+	if err := json.Unmarshal([]byte(pos), &p); err != nil {
+		return 0, false
+	}
+	idx := strings.LastIndex(p.Name, ".")
+	if idx < 0 {
+		return 0, false
+	}
+	file, err := strconv.ParseInt(p.Name[idx+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return file<<32 | int64(p.Pos), true
+}
+
 func (r *reader) handler(e *replication.BinlogEvent) error {
 	switch e.Header.EventType {
 	case
@@ -230,7 +257,10 @@ func (r *reader) handler(e *replication.BinlogEvent) error {
 		replication.WRITE_ROWS_EVENTv2,
 		replication.UPDATE_ROWS_EVENTv0,
 		replication.UPDATE_ROWS_EVENTv1,
-		replication.UPDATE_ROWS_EVENTv2:
+		replication.UPDATE_ROWS_EVENTv2,
+		replication.DELETE_ROWS_EVENTv0,
+		replication.DELETE_ROWS_EVENTv1,
+		replication.DELETE_ROWS_EVENTv2:
 		rowsEvent, ok := e.Event.(*replication.RowsEvent)
 		if !ok {
 			return r.opt.Logger.Errorf("can not convert %v to RowsEvent", e.Event)
@@ -241,7 +271,7 @@ func (r *reader) handler(e *replication.BinlogEvent) error {
 			return nil
 		}
 		shouldRun := false
-		for _, v := range r.opt.Task.GetTables() {
+		for _, v := range r.opt.GetTables() {
 			if v.SourceTable == tableName {
 				shouldRun = true
 				break
@@ -252,22 +282,11 @@ func (r *reader) handler(e *replication.BinlogEvent) error {
 		}
 		table := r.schemaManager.Get(string(rowsEvent.Table.Schema), string(rowsEvent.Table.Table))
 		records := r.rowsToEntry(table, rowsEvent)
-		for _, record := range records {
-			var ev core.Event
-			ev.Record = record
-			ev.Record = record
+		for _, ev := range rowEvents(e.Header.EventType, records) {
 			ev.SourceSchema = *table
-			if e.Header.EventType == replication.UPDATE_ROWS_EVENTv0 ||
-				e.Header.EventType == replication.UPDATE_ROWS_EVENTv1 ||
-				e.Header.EventType == replication.UPDATE_ROWS_EVENTv2 {
-				ev.Type = core.EventTypeUpdate
-				ev.OldRecord = new(core.EventRecord)
-				*ev.OldRecord = ev.Record
-			} else {
-				ev.Type = core.EventTypeInsert
-			}
 			pos, _ := json.Marshal(r.syncer.GetNextPosition())
 			ev.LastPOS = string(pos)
+			ev.CommitTime = time.Unix(int64(e.Header.Timestamp), 0)
 			if err := r.opt.Subscriber.ReaderEvent(ev); err != nil {
 				return err
 			}
@@ -276,6 +295,28 @@ func (r *reader) handler(e *replication.BinlogEvent) error {
 	return nil
 }
 
+// rowEvents returns the events of the rows of a rows event. Rows of update events are pairs of the row before
+// and after the update.
+func rowEvents(eventType replication.EventType, records []core.EventRecord) []core.Event {
+	var events []core.Event
+	switch eventType {
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		for i := 0; i+1 < len(records); i += 2 {
+			old := records[i]
+			events = append(events, core.Event{Type: core.EventTypeUpdate, Record: records[i+1], OldRecord: &old})
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, record := range records {
+			events = append(events, core.Event{Type: core.EventTypeDelete, Record: record})
+		}
+	default:
+		for _, record := range records {
+			events = append(events, core.Event{Type: core.EventTypeInsert, Record: record})
+		}
+	}
+	return events
+}
+
 func (s *reader) rowsToEntry(schema *schemas.Table, binlog *replication.RowsEvent) []core.EventRecord {
 	var records []core.EventRecord
 	for _, row := range binlog.Rows {
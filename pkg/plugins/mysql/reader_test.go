@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"testing"
+)
+
+func testRecord(id int64, name string) core.EventRecord {
+	var record core.EventRecord
+	record.Set("id", types.NewTypedData(schemas.TypeInt, id))
+	record.Set("name", types.NewTypedData(schemas.TypeString, name))
+	return record
+}
+
+func TestRowEventsUpdate(t *testing.T) {
+	records := []core.EventRecord{
+		testRecord(1, "before"), testRecord(1, "after"),
+		testRecord(2, "before"), testRecord(3, "after"),
+	}
+	events := rowEvents(replication.UPDATE_ROWS_EVENTv2, records)
+	if len(events) != 2 {
+		t.Fatalf("expected an event of every pair of rows, got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Type != core.EventTypeUpdate || e.OldRecord == nil {
+			t.Fatalf("event %d should be an update with its old row", i)
+		}
+		if name, _ := e.Record.FieldByName("name"); name.Value.V != "after" {
+			t.Fatalf("event %d should have the row after the update, got %v", i, name.Value.V)
+		}
+		if name, _ := e.OldRecord.FieldByName("name"); name.Value.V != "before" {
+			t.Fatalf("event %d should have the row before the update, got %v", i, name.Value.V)
+		}
+	}
+	if id, _ := events[1].OldRecord.FieldByName("id"); id.Value.V != int64(2) {
+		t.Fatalf("the old row should keep the old key, got %v", id.Value.V)
+	}
+}
+
+func TestRowEventsDelete(t *testing.T) {
+	events := rowEvents(replication.DELETE_ROWS_EVENTv1, []core.EventRecord{testRecord(1, "a"), testRecord(2, "b")})
+	if len(events) != 2 {
+		t.Fatalf("expected an event of every row, got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Type != core.EventTypeDelete || e.OldRecord != nil {
+			t.Fatalf("event %d should be a delete", i)
+		}
+	}
+}
+
+func TestRowEventsInsert(t *testing.T) {
+	events := rowEvents(replication.WRITE_ROWS_EVENTv2, []core.EventRecord{testRecord(1, "a")})
+	if len(events) != 1 || events[0].Type != core.EventTypeInsert {
+		t.Fatalf("expected an insert, got %+v", events)
+	}
+}
@@ -21,7 +21,7 @@ func NewSchema(context context.Context, opt interface{}) core.SchemaManager {
 func (s *schema) Get(dbname string, tableName string) *schemas.Table {
 	conn, err := Connect(s.opt.Connector)
 	if err != nil {
-		s.opt.Logger.Error("can not connect to db:%s %v", s.opt.Connector, err)
+		s.opt.Logger.Error("can not connect to db:%s %v", s.opt.Connector.Name, err)
 		return nil
 	}
 
@@ -162,3 +162,18 @@ func getFieldTypeDefinition(f schemas.Column) string {
 	}
 	return "text"
 }
+
+// ListTables lists the base tables of the database.
+func (s *schema) ListTables(dbname string) ([]string, error) {
+	conn, err := Connect(s.opt.Connector)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	sql := `SELECT TABLE_NAME FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME`
+	if err := conn.Raw(sql, dbname).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
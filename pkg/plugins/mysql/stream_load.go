@@ -0,0 +1,586 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ssExtra struct {
+	FEHost        string `json:"fe_host"`
+	FEPort        int    `json:"fe_port"`
+	PartialUpdate bool   `json:"partial_update"`
+}
+
+// starRocks operation types of the __op column on Primary Key tables.
+const (
+	starRocksOpUpsert = 0
+	starRocksOpDelete = 1
+)
+
+type txnResponse struct {
+	TxnId             int64  `json:"TxnId"`
+	Label             string `json:"Label"`
+	Status            string `json:"Status"`
+	Message           string `json:"Message"`
+	ExistingJobStatus string `json:"ExistingJobStatus"`
+}
+
+// streamLoadBody is the data of one load request in a transaction.
+type streamLoadBody struct {
+	columns   []string
+	jsonPaths []string
+	rows      []string
+}
+
+var httpClient *http.Client
+
+// the latest stream loads are kept in the anycdc database, tests replace them.
+var (
+	getStreamLoadLabel  = model.GetStreamLoadLabel
+	saveStreamLoadLabel = model.SaveStreamLoadLabel
+)
+
+func init() {
+	httpClient = &http.Client{
+		Timeout: 120 * time.Second,
+		// FE redirects loads to BE, keep the credentials like curl --location-trusted.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if auth := via[0].Header.Get("Authorization"); auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			return nil
+		},
+	}
+}
+
+// pushStarRocks loads events into a Primary Key table in stream load transactions, in source order,
+// deletes are sent with __op=1. All columns are loaded, partial_update is not applied in transactions.
+func (w *writer) pushStarRocks(sch *schemas.Table, events []core.Event) error {
+	return w.streamLoad(sch, events, w.loadStarRocks)
+}
+
+// loadStarRocks loads events in one transaction labeled label, a committed label is skipped
+// and a prepared one is committed.
+func (w *writer) loadStarRocks(sch *schemas.Table, events []core.Event, label string) error {
+	var ssE ssExtra
+	if err := json.Unmarshal([]byte(w.opt.Connector.Extra), &ssE); err != nil {
+		w.opt.Logger.Error("Unmarshal err: %v", err)
+		return err
+	}
+	records := make([]core.EventRecord, len(events))
+	for i, event := range events {
+		records[i] = event.Record.ConvertRecord(sch)
+	}
+	if ssE.PartialUpdate {
+		// partial updates in stream load transactions are not confirmed yet, all columns are loaded.
+		w.partialUpdateIgnored.Do(func() {
+			w.opt.Logger.Info("partial_update is ignored by StarRocks stream load transactions, all columns are loaded")
+		})
+	}
+	bodies := buildStreamLoadBodies(sch, events, records, false, []string{"__op"}, starRocksMeta)
+	w.opt.Logger.Info("Starting Push To SR, table name=%s, label=%s, records=%d", sch.Name, label, len(events))
+
+	resp, err := w.txnRequest(ssE, "begin", label, sch.Name, nil, nil)
+	if err != nil {
+		return w.opt.Logger.Errorf("Can not begin transaction %s on %s: %v", label, sch.Name, err)
+	}
+	switch resp.Status {
+	case "OK":
+	case "LABEL_ALREADY_EXISTS":
+		switch strings.ToUpper(resp.ExistingJobStatus) {
+		case "COMMITTED", "VISIBLE", "FINISHED":
+			w.opt.Logger.Info("Skipped push to SR %s, label %s already committed", sch.Name, label)
+			return nil
+		case "PREPARE", "PREPARED":
+			return w.commitTxn(ssE, label, sch.Name)
+		}
+		w.rollbackTxn(ssE, label, sch.Name)
+		return w.opt.Logger.Errorf("Can not begin transaction %s on %s, existing transaction is %s", label, sch.Name, resp.ExistingJobStatus)
+	default:
+		return w.opt.Logger.Errorf("Can not begin transaction %s on %s: %s", label, sch.Name, resp.Message)
+	}
+	for _, body := range bodies {
+		headers := map[string]string{
+			"format":           "json",
+			"jsonpaths":        "[" + strings.Join(body.jsonPaths, ",") + "]",
+			"columns":          strings.Join(body.columns, ","),
+			"strict_mode":      "true",
+			"ignore_json_size": "true",
+		}
+		resp, err := w.txnRequest(ssE, "load", label, sch.Name, []byte(strings.Join(body.rows, "\n")), headers)
+		if err == nil && resp.Status != "OK" && resp.Status != "Success" {
+			err = errors.New(resp.Message)
+		}
+		if err != nil {
+			w.rollbackTxn(ssE, label, sch.Name)
+			return w.opt.Logger.Errorf("Can not load data,%s,%s: %v", sch.Name, label, err)
+		}
+	}
+	resp, err = w.txnRequest(ssE, "prepare", label, "", nil, nil)
+	if err == nil && resp.Status != "OK" {
+		err = errors.New(resp.Message)
+	}
+	if err != nil {
+		w.rollbackTxn(ssE, label, sch.Name)
+		return w.opt.Logger.Errorf("Can not prepare transaction %s on %s: %v", label, sch.Name, err)
+	}
+	return w.commitTxn(ssE, label, sch.Name)
+}
+
+// commitTxn commits a prepared transaction, it's not rolled back on failures,
+// so the next retry with the same label can commit it.
+func (w *writer) commitTxn(ssE ssExtra, label string, table string) error {
+	resp, err := w.txnRequest(ssE, "commit", label, "", nil, nil)
+	if err == nil && resp.Status != "OK" {
+		err = errors.New(resp.Message)
+	}
+	if err != nil {
+		return w.opt.Logger.Errorf("Can not commit transaction %s on %s: %v", label, table, err)
+	}
+	w.opt.Logger.Info("Completed push to SR %s, label=%s", table, label)
+	return nil
+}
+
+func (w *writer) rollbackTxn(ssE ssExtra, label string, table string) {
+	resp, err := w.txnRequest(ssE, "rollback", label, "", nil, nil)
+	if err == nil && resp.Status != "OK" {
+		err = errors.New(resp.Message)
+	}
+	if err != nil {
+		w.opt.Logger.Error("Can not rollback transaction %s on %s: %v", label, table, err)
+	}
+}
+
+func (w *writer) txnRequest(ssE ssExtra, action string, label string, table string, body []byte, headers map[string]string) (*txnResponse, error) {
+	method := http.MethodPost
+	if action == "load" {
+		method = http.MethodPut
+	}
+	url := fmt.Sprintf("http://%s:%d/api/transaction/%s", ssE.FEHost, ssE.FEPort, action)
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(w.opt.Connector.Username, w.opt.Connector.Password)
+	request.Header.Set("label", label)
+	request.Header.Set("db", w.opt.Connector.Database)
+	if table != "" {
+		request.Header.Set("table", table)
+	}
+	request.Header.Set("Expect", "100-continue")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var ret txnResponse
+	if err := json.Unmarshal(content, &ret); err != nil {
+		return nil, fmt.Errorf("invalid response %d, %s", resp.StatusCode, string(content))
+	}
+	if ret.Message == "" && ret.Status != "OK" {
+		ret.Message = string(content)
+	}
+	return &ret, nil
+}
+
+// streamLoad loads the events of a table with load, in source order. The label and the position range of
+// a cdc load are saved before it starts and marked as committed after it. After a crash, events are read
+// again from the saved task position, and the first batch of a table is cut at the saved range: events
+// before an unfinished load were committed by earlier loads and are dropped, the events of the unfinished
+// load are loaded again with its label, so the server deduplicates them, and later events are loaded with
+// a new label. Events at the last position of a committed load are loaded again, loads are idempotent.
+// Dumped events have no position, their label is derived from their primary keys.
+func (w *writer) streamLoad(sch *schemas.Table, events []core.Event, load func(sch *schemas.Table, events []core.Event, label string) error) error {
+	comparer, _ := w.opt.Reader.(core.PositionComparer)
+	if comparer == nil || w.opt.Task == nil || events[0].LastPOS == "" {
+		return load(sch, events, w.streamLoadLabel(sch, events))
+	}
+	core.SortEvents(comparer, events)
+	table := events[0].SourceSchema.Name
+	if _, ok := w.labels.Load(table); !ok {
+		saved, err := getStreamLoadLabel(w.opt.Task.ID, w.opt.Connector.ID, table)
+		if err != nil {
+			return w.opt.Logger.Errorf("can not get the latest stream load of %s: %v", table, err)
+		}
+		if saved != nil {
+			w.labels.Store(table, saved)
+			if events, err = w.resumeLoad(sch, events, saved, comparer, load); err != nil {
+				// the saved load is read again by the retry.
+				w.labels.Delete(table)
+				return err
+			}
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return w.labeledLoad(sch, events, w.streamLoadLabel(sch, events), load)
+}
+
+// resumeLoad drops the events loaded before the saved load, loads the events of an unfinished saved load
+// with its label and returns the later events.
+func (w *writer) resumeLoad(sch *schemas.Table, events []core.Event, saved *model.StreamLoadLabel, comparer core.PositionComparer, load func(sch *schemas.Table, events []core.Event, label string) error) ([]core.Event, error) {
+	i := 0
+	for i < len(events) && comparer.ComparePosition(events[i].LastPOS, saved.FirstPOS) < 0 {
+		i++
+	}
+	start := i
+	if saved.Committed {
+		for i < len(events) && comparer.ComparePosition(events[i].LastPOS, saved.LastPOS) < 0 {
+			i++
+		}
+		start = i
+	} else {
+		for i < len(events) && comparer.ComparePosition(events[i].LastPOS, saved.LastPOS) <= 0 {
+			i++
+		}
+	}
+	if start > 0 {
+		w.opt.Logger.Info("Skipped %d events of %s loaded before label %s", start, saved.Table, saved.Label)
+	}
+	if resumed := events[start:i]; len(resumed) > 0 {
+		label := saved.Label
+		if len(resumed) != saved.Count {
+			// the events of the unfinished load are not the same, they're loaded with a new label.
+			label = w.streamLoadLabel(sch, resumed)
+		}
+		w.opt.Logger.Info("Resuming stream load %s of %s, records=%d", saved.Label, saved.Table, len(resumed))
+		if err := w.labeledLoad(sch, resumed, label, load); err != nil {
+			return nil, err
+		}
+	}
+	return events[i:], nil
+}
+
+// labeledLoad saves the label and the position range of events, loads them and marks the label as committed.
+func (w *writer) labeledLoad(sch *schemas.Table, events []core.Event, label string, load func(sch *schemas.Table, events []core.Event, label string) error) error {
+	table := events[0].SourceSchema.Name
+	saved := &model.StreamLoadLabel{TaskID: w.opt.Task.ID, Writer: w.opt.Connector.ID, Table: table}
+	if l, ok := w.labels.Load(table); ok {
+		saved = l.(*model.StreamLoadLabel)
+	}
+	saved.Label = label
+	saved.FirstPOS = events[0].LastPOS
+	saved.LastPOS = events[len(events)-1].LastPOS
+	saved.Count = len(events)
+	saved.Committed = false
+	if err := saveStreamLoadLabel(saved); err != nil {
+		return w.opt.Logger.Errorf("can not save stream load %s of %s: %v", label, table, err)
+	}
+	w.labels.Store(table, saved)
+	if err := load(sch, events, label); err != nil {
+		return err
+	}
+	saved.Committed = true
+	if err := saveStreamLoadLabel(saved); err != nil {
+		return w.opt.Logger.Errorf("can not save stream load %s of %s: %v", label, table, err)
+	}
+	return nil
+}
+
+// streamLoadLabel derives a label from the positions of cdc events,
+// dumper events have no position and use their primary keys.
+func (w *writer) streamLoadLabel(sch *schemas.Table, events []core.Event) string {
+	taskID := ""
+	if w.opt.Task != nil {
+		taskID = w.opt.Task.ID
+	}
+	first, last := events[0].LastPOS, events[len(events)-1].LastPOS
+	if first == "" && last == "" {
+		first = primaryKeyString(sch, events[0].Record.ConvertRecord(sch))
+		last = primaryKeyString(sch, events[len(events)-1].Record.ConvertRecord(sch))
+	}
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s|%s|%s|%s|%d", taskID, sch.Name, first, last, len(events))
+	return "anycdc_" + hex.EncodeToString(h.Sum(nil))
+}
+
+func primaryKeyString(sch *schemas.Table, record core.EventRecord) string {
+	var keys []string
+	for _, pk := range sch.GetPrimaryKeyNames() {
+		f, err := record.FieldByName(pk)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, fmt.Sprint(f.Value.V))
+	}
+	return strings.Join(keys, ",")
+}
+
+func starRocksMeta(e core.Event) map[string]interface{} {
+	if e.Type == core.EventTypeDelete {
+		return map[string]interface{}{"__op": starRocksOpDelete}
+	}
+	return map[string]interface{}{"__op": starRocksOpUpsert}
+}
+
+// buildStreamLoadBodies builds one body with all columns, with partial updates consecutive
+// events with the same columns share one body which only has their columns.
+func buildStreamLoadBodies(sch *schemas.Table, events []core.Event, records []core.EventRecord, partial bool, metaColumns []string, meta func(e core.Event) map[string]interface{}) []streamLoadBody {
+	if !partial {
+		return []streamLoadBody{buildStreamLoadBody(sch.Columns, events, records, metaColumns, meta)}
+	}
+	var bodies []streamLoadBody
+	start := 0
+	for i := 1; i <= len(events); i++ {
+		if i < len(events) && sameColumns(records[i], records[start]) {
+			continue
+		}
+		var columns []schemas.Column
+		for _, col := range sch.Columns {
+			if _, err := records[start].FieldByName(col.Name); err == nil {
+				columns = append(columns, col)
+			}
+		}
+		bodies = append(bodies, buildStreamLoadBody(columns, events[start:i], records[start:i], metaColumns, meta))
+		start = i
+	}
+	return bodies
+}
+
+func sameColumns(a core.EventRecord, b core.EventRecord) bool {
+	if len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if a.Columns[i].Name != b.Columns[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// buildStreamLoadBody encodes records as json lines, meta returns the values of the extra
+// load columns (like __op) of an event.
+func buildStreamLoadBody(columns []schemas.Column, events []core.Event, records []core.EventRecord, metaColumns []string, meta func(e core.Event) map[string]interface{}) streamLoadBody {
+	var body streamLoadBody
+	for _, col := range columns {
+		body.jsonPaths = append(body.jsonPaths, fmt.Sprintf("\"$.`%s`\"", col.Name))
+		body.columns = append(body.columns, fmt.Sprintf("`%s`", col.Name))
+	}
+	for _, col := range metaColumns {
+		body.jsonPaths = append(body.jsonPaths, fmt.Sprintf("\"$.%s\"", col))
+		body.columns = append(body.columns, col)
+	}
+
+	for i, event := range records {
+		data := make(map[string]interface{})
+		for _, col := range columns {
+			var val interface{}
+			f, err := event.FieldByName(col.Name)
+			if err == nil {
+				val, err = dataTypes.Decode(f.Value)
+			}
+			if val != nil {
+				if col.DataType == schemas.TypeTimestamp {
+					driverVal, ok := val.(driver.Valuer)
+					if ok {
+						val, _ = driverVal.Value()
+					}
+					if strings.Contains(fmt.Sprint(val), "0000-00-00") || fmt.Sprint(val) == "" {
+						if col.Nullable {
+							val = nil
+						} else {
+							val = "1970-01-01 00:00:00"
+						}
+					}
+				}
+				if col.DataType == schemas.TypeDate {
+					driverVal, ok := val.(driver.Valuer)
+					if ok {
+						val, _ = driverVal.Value()
+					}
+					if strings.Contains(fmt.Sprint(val), "0000-00-00") || fmt.Sprint(val) == "" {
+						if col.Nullable {
+							val = nil
+						} else {
+							val = "1970-01-01"
+						}
+					}
+				}
+			}
+
+			if val == nil {
+				if !col.Nullable {
+					switch col.DataType {
+					case schemas.TypeBool:
+						val = false
+					case schemas.TypeString:
+						val = ""
+					case schemas.TypeUint, schemas.TypeInt, schemas.TypeDecimal:
+						val = 0
+					case schemas.TypeJSON:
+						val = "{}"
+					case schemas.TypeTimestamp:
+						val = time.Unix(0, 0)
+					default:
+						val = ""
+					}
+				}
+			}
+			data[fmt.Sprintf("`%s`", col.Name)] = val
+		}
+		for k, v := range meta(events[i]) {
+			data[k] = v
+		}
+		jsonStr, _ := json.Marshal(data)
+		body.rows = append(body.rows, string(jsonStr))
+	}
+	return body
+}
+
+type dorisExtra struct {
+	FEHost          string `json:"fe_host"`
+	FEPort          int    `json:"fe_port"`
+	PartialUpdate   bool   `json:"partial_update"`
+	GroupCommit     string `json:"group_commit"`
+	DisableSequence bool   `json:"disable_sequence"`
+}
+
+const (
+	dorisDeleteColumn   = "__anycdc_delete"
+	dorisSequenceColumn = "__anycdc_seq"
+)
+
+// pushDoris loads events into a Unique Key table. Deletes are merged through __DORIS_DELETE_SIGN__
+// and every row carries a sequence value, so Doris keeps the latest version of a key even when
+// loads are applied out of order. Each load request is atomic and deduplicated by its label,
+// labels are not used with group commit.
+func (w *writer) pushDoris(sch *schemas.Table, events []core.Event) error {
+	return w.streamLoad(sch, events, w.loadDoris)
+}
+
+func (w *writer) loadDoris(sch *schemas.Table, events []core.Event, label string) error {
+	var dE dorisExtra
+	if err := json.Unmarshal([]byte(w.opt.Connector.Extra), &dE); err != nil {
+		w.opt.Logger.Error("Unmarshal err: %v", err)
+		return err
+	}
+	records := make([]core.EventRecord, len(events))
+	for i, event := range events {
+		records[i] = event.Record.ConvertRecord(sch)
+	}
+	metaColumns := []string{dorisDeleteColumn}
+	if !dE.DisableSequence {
+		metaColumns = append(metaColumns, dorisSequenceColumn)
+	}
+	bodies := buildStreamLoadBodies(sch, events, records, dE.PartialUpdate, metaColumns, func(e core.Event) map[string]interface{} {
+		meta := map[string]interface{}{dorisDeleteColumn: 0}
+		if e.Type == core.EventTypeDelete {
+			meta[dorisDeleteColumn] = 1
+		}
+		if !dE.DisableSequence {
+			meta[dorisSequenceColumn] = w.sequence(e)
+		}
+		return meta
+	})
+	w.opt.Logger.Info("Starting Push To Doris, table name=%s, label=%s, records=%d", sch.Name, label, len(events))
+
+	for i, body := range bodies {
+		headers := map[string]string{
+			"format":            "json",
+			"read_json_by_line": "true",
+			"jsonpaths":         "[" + strings.Join(body.jsonPaths, ",") + "]",
+			"columns":           strings.Join(body.columns, ","),
+			"merge_type":        "MERGE",
+			"delete":            dorisDeleteColumn + "=1",
+			"strict_mode":       "true",
+		}
+		if !dE.DisableSequence {
+			headers["function_column.sequence_col"] = dorisSequenceColumn
+		}
+		if dE.PartialUpdate {
+			headers["partial_columns"] = "true"
+		}
+		if dE.GroupCommit != "" {
+			headers["group_commit"] = dE.GroupCommit
+		} else if len(bodies) > 1 {
+			headers["label"] = fmt.Sprintf("%s_%d", label, i)
+		} else {
+			headers["label"] = label
+		}
+		resp, err := w.dorisStreamLoad(dE, sch.Name, []byte(strings.Join(body.rows, "\n")), headers)
+		if err != nil {
+			return w.opt.Logger.Errorf("Can not load data,%s,%s: %v", sch.Name, headers["label"], err)
+		}
+		switch resp.Status {
+		case "Success", "Publish Timeout":
+		case "Label Already Exists":
+			if strings.ToUpper(resp.ExistingJobStatus) != "FINISHED" {
+				return w.opt.Logger.Errorf("Can not load data,%s,%s, existing load is %s", sch.Name, headers["label"], resp.ExistingJobStatus)
+			}
+			w.opt.Logger.Info("Skipped push to Doris %s, label %s already loaded", sch.Name, headers["label"])
+		default:
+			return w.opt.Logger.Errorf("Can not load data,%s,%s: %s", sch.Name, headers["label"], resp.Message)
+		}
+	}
+	w.opt.Logger.Info("Completed push to Doris %s, label=%s", sch.Name, label)
+	return nil
+}
+
+func (w *writer) dorisStreamLoad(dE dorisExtra, table string, body []byte, headers map[string]string) (*txnResponse, error) {
+	url := fmt.Sprintf("http://%s:%d/api/%s/%s/_stream_load", dE.FEHost, dE.FEPort, w.opt.Connector.Database, table)
+	request, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(w.opt.Connector.Username, w.opt.Connector.Password)
+	request.Header.Set("Expect", "100-continue")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var ret txnResponse
+	if err := json.Unmarshal(content, &ret); err != nil {
+		return nil, fmt.Errorf("invalid response %d, %s", resp.StatusCode, string(content))
+	}
+	if ret.Message == "" {
+		ret.Message = string(content)
+	}
+	return &ret, nil
+}
+
+// sequence returns the Doris sequence of an event, derived from its source position, so a row wins over
+// the rows of earlier events whenever they are loaded. Readers which can not map their positions use
+// the commit time. Dumped rows have no position, their sequence is 0, lower than any cdc event.
+func (w *writer) sequence(e core.Event) int64 {
+	if e.LastPOS == "" {
+		return 0
+	}
+	if sequencer, ok := w.opt.Reader.(core.PositionSequencer); ok {
+		if seq, ok := sequencer.PositionSequence(e.LastPOS); ok {
+			return seq
+		}
+	}
+	return e.CommitTime.UnixMicro()
+}
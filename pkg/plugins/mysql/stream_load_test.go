@@ -0,0 +1,340 @@
+package mysql
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testComparer is a reader whose positions are numbers.
+type testComparer struct {
+	core.Reader
+}
+
+func (testComparer) ComparePosition(a string, b string) int {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return cmp.Compare(x, y)
+}
+
+var testStreamSchema = &schemas.Table{
+	Name: "t",
+	Columns: []schemas.Column{
+		{Name: "id", DataType: schemas.TypeInt, IsPrimaryKey: true},
+		{Name: "name", DataType: schemas.TypeString, Nullable: true},
+	},
+}
+
+func testStreamEvents(positions ...int) []core.Event {
+	var events []core.Event
+	for _, pos := range positions {
+		var record core.EventRecord
+		record.Set("id", types.NewTypedData(schemas.TypeInt, int64(pos)))
+		events = append(events, core.Event{
+			Type:         core.EventTypeInsert,
+			Record:       record,
+			SourceSchema: *testStreamSchema,
+			LastPOS:      strconv.Itoa(pos),
+		})
+	}
+	return events
+}
+
+func testStreamWriter(extra string) *writer {
+	return &writer{opt: &core.WriterOption{
+		Connector: &model.Connector{Base: model.Base{ID: "writer"}, Database: "db", Extra: extra},
+		Logger:    core.NewFileLog("", core.LevelInfo),
+		Task:      &model.Task{Base: model.Base{ID: "task"}},
+		Reader:    testComparer{},
+	}}
+}
+
+// testLabelStore replaces the label store with saved, it returns the saved labels.
+func testLabelStore(t *testing.T, saved *model.StreamLoadLabel) (gets *int, saves *[]model.StreamLoadLabel) {
+	get, save := getStreamLoadLabel, saveStreamLoadLabel
+	t.Cleanup(func() {
+		getStreamLoadLabel, saveStreamLoadLabel = get, save
+	})
+	gets, saves = new(int), new([]model.StreamLoadLabel)
+	getStreamLoadLabel = func(taskID string, writer string, table string) (*model.StreamLoadLabel, error) {
+		*gets++
+		if saved == nil {
+			return nil, nil
+		}
+		l := *saved
+		return &l, nil
+	}
+	saveStreamLoadLabel = func(label *model.StreamLoadLabel) error {
+		*saves = append(*saves, *label)
+		return nil
+	}
+	return gets, saves
+}
+
+type testLoad struct {
+	label     string
+	positions []string
+}
+
+func positionsOf(events []core.Event) []string {
+	var positions []string
+	for _, e := range events {
+		positions = append(positions, e.LastPOS)
+	}
+	return positions
+}
+
+func TestStreamLoadResume(t *testing.T) {
+	cases := []struct {
+		name   string
+		saved  *model.StreamLoadLabel
+		events []int
+		// loads are the expected loads, an empty label is a new label derived from the events.
+		loads []testLoad
+	}{
+		{
+			name:   "no saved load",
+			events: []int{4, 2, 3, 1},
+			loads:  []testLoad{{positions: []string{"1", "2", "3", "4"}}},
+		},
+		{
+			name:   "unfinished load",
+			saved:  &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "5", Count: 3},
+			events: []int{1, 2, 3, 4, 5, 6, 7},
+			loads: []testLoad{
+				{label: "saved", positions: []string{"3", "4", "5"}},
+				{positions: []string{"6", "7"}},
+			},
+		},
+		{
+			name:   "unfinished load with other events",
+			saved:  &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "5", Count: 3},
+			events: []int{1, 3, 5, 6},
+			loads: []testLoad{
+				{positions: []string{"3", "5"}},
+				{positions: []string{"6"}},
+			},
+		},
+		{
+			name:   "unfinished load only",
+			saved:  &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "5", Count: 3},
+			events: []int{3, 4, 5},
+			loads:  []testLoad{{label: "saved", positions: []string{"3", "4", "5"}}},
+		},
+		{
+			name:   "committed load",
+			saved:  &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "5", Count: 3, Committed: true},
+			events: []int{1, 2, 3, 4, 5, 6, 7},
+			loads:  []testLoad{{positions: []string{"5", "6", "7"}}},
+		},
+		{
+			name:   "events before a committed load",
+			saved:  &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "7", Count: 5, Committed: true},
+			events: []int{2, 3, 4, 5, 6},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, saves := testLabelStore(t, c.saved)
+			w := testStreamWriter("")
+			var loads []testLoad
+			err := w.streamLoad(testStreamSchema, testStreamEvents(c.events...), func(sch *schemas.Table, events []core.Event, label string) error {
+				loads = append(loads, testLoad{label: label, positions: positionsOf(events)})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(loads) != len(c.loads) {
+				t.Fatalf("expected %d loads, got %+v", len(c.loads), loads)
+			}
+			for i, expected := range c.loads {
+				if !reflect.DeepEqual(loads[i].positions, expected.positions) {
+					t.Errorf("load %d: expected positions %v, got %v", i, expected.positions, loads[i].positions)
+				}
+				label := expected.label
+				if label == "" {
+					var positions []int
+					for _, p := range expected.positions {
+						pos, _ := strconv.Atoi(p)
+						positions = append(positions, pos)
+					}
+					label = w.streamLoadLabel(testStreamSchema, testStreamEvents(positions...))
+				}
+				if loads[i].label != label {
+					t.Errorf("load %d: expected label %s, got %s", i, label, loads[i].label)
+				}
+			}
+			if len(loads) == 0 {
+				if len(*saves) != 0 {
+					t.Fatalf("expected no saved labels, got %+v", *saves)
+				}
+				return
+			}
+			if len(*saves) != 2*len(loads) {
+				t.Fatalf("expected every load to be saved before and after it, got %+v", *saves)
+			}
+			last, load := (*saves)[len(*saves)-1], loads[len(loads)-1]
+			if !last.Committed || last.Label != load.label || last.Count != len(load.positions) ||
+				last.FirstPOS != load.positions[0] || last.LastPOS != load.positions[len(load.positions)-1] {
+				t.Errorf("expected the last load to be saved as committed, got %+v", last)
+			}
+			if first := (*saves)[0]; first.Committed || first.Label != loads[0].label {
+				t.Errorf("expected the first load to be saved before it starts, got %+v", first)
+			}
+		})
+	}
+}
+
+func TestStreamLoadResumeFailure(t *testing.T) {
+	gets, saves := testLabelStore(t, &model.StreamLoadLabel{Label: "saved", FirstPOS: "3", LastPOS: "5", Count: 3})
+	w := testStreamWriter("")
+	var labels []string
+	failed := true
+	load := func(sch *schemas.Table, events []core.Event, label string) error {
+		labels = append(labels, label)
+		if failed {
+			return errors.New("load failed")
+		}
+		return nil
+	}
+	if err := w.streamLoad(testStreamSchema, testStreamEvents(3, 4, 5, 6), load); err == nil {
+		t.Fatalf("expected the load error")
+	}
+	if last := (*saves)[len(*saves)-1]; last.Committed {
+		t.Fatalf("a failed load should not be committed, got %+v", last)
+	}
+	failed = false
+	if err := w.streamLoad(testStreamSchema, testStreamEvents(3, 4, 5, 6), load); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *gets != 2 {
+		t.Fatalf("expected the saved load to be read again by the retry, got %d reads", *gets)
+	}
+	if len(labels) != 3 || labels[0] != "saved" || labels[1] != "saved" || labels[2] == "saved" {
+		t.Fatalf("expected the unfinished load to be retried with its label, got %v", labels)
+	}
+	if err := w.streamLoad(testStreamSchema, testStreamEvents(7), load); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *gets != 2 {
+		t.Fatalf("expected the latest load to be kept by the writer, got %d reads", *gets)
+	}
+}
+
+// testFE is a StarRocks FE answering transaction requests with responses by action,
+// OK by default.
+type testFE struct {
+	mutex     sync.Mutex
+	responses map[string]string
+	actions   []string
+	headers   http.Header
+}
+
+func (fe *testFE) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	fe.mutex.Lock()
+	defer fe.mutex.Unlock()
+	action := strings.TrimPrefix(r.URL.Path, "/api/transaction/")
+	fe.actions = append(fe.actions, action)
+	if action == "load" {
+		fe.headers = r.Header.Clone()
+	}
+	resp, ok := fe.responses[action]
+	if !ok {
+		resp = `{"Status":"OK"}`
+	}
+	_, _ = rw.Write([]byte(resp))
+}
+
+func TestLoadStarRocks(t *testing.T) {
+	cases := []struct {
+		name      string
+		responses map[string]string
+		actions   []string
+		err       bool
+	}{
+		{
+			name:    "new label",
+			actions: []string{"begin", "load", "prepare", "commit"},
+		},
+		{
+			name:      "prepared label",
+			responses: map[string]string{"begin": `{"Status":"LABEL_ALREADY_EXISTS","ExistingJobStatus":"PREPARED"}`},
+			actions:   []string{"begin", "commit"},
+		},
+		{
+			name: "prepared label commit failure",
+			responses: map[string]string{
+				"begin":  `{"Status":"LABEL_ALREADY_EXISTS","ExistingJobStatus":"PREPARED"}`,
+				"commit": `{"Status":"FAILED","Message":"commit failed"}`,
+			},
+			actions: []string{"begin", "commit"},
+			err:     true,
+		},
+		{
+			name:      "committed label",
+			responses: map[string]string{"begin": `{"Status":"LABEL_ALREADY_EXISTS","ExistingJobStatus":"VISIBLE"}`},
+			actions:   []string{"begin"},
+		},
+		{
+			name:      "aborted label",
+			responses: map[string]string{"begin": `{"Status":"LABEL_ALREADY_EXISTS","ExistingJobStatus":"ABORTED"}`},
+			actions:   []string{"begin", "rollback"},
+			err:       true,
+		},
+		{
+			name:      "load failure",
+			responses: map[string]string{"load": `{"Status":"Fail","Message":"too many filtered rows"}`},
+			actions:   []string{"begin", "load", "rollback"},
+			err:       true,
+		},
+		{
+			name:      "prepare failure",
+			responses: map[string]string{"prepare": `{"Status":"FAILED","Message":"prepare failed"}`},
+			actions:   []string{"begin", "load", "prepare", "rollback"},
+			err:       true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fe := &testFE{responses: c.responses}
+			server := httptest.NewServer(fe)
+			defer server.Close()
+			u, _ := url.Parse(server.URL)
+			port, _ := strconv.Atoi(u.Port())
+			w := testStreamWriter(fmt.Sprintf(`{"fe_host":%q,"fe_port":%d,"partial_update":true}`, u.Hostname(), port))
+
+			err := w.loadStarRocks(testStreamSchema, testStreamEvents(1, 2), "label")
+			if (err != nil) != c.err {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if !reflect.DeepEqual(fe.actions, c.actions) {
+				t.Fatalf("expected requests %v, got %v", c.actions, fe.actions)
+			}
+			if fe.headers == nil {
+				return
+			}
+			if fe.headers.Get("label") != "label" || fe.headers.Get("table") != "t" {
+				t.Errorf("expected the load of label on t, got %v", fe.headers)
+			}
+			if fe.headers.Get("partial_update") != "" {
+				t.Errorf("partial_update should not be set in transactions")
+			}
+			if columns := fe.headers.Get("columns"); columns != "`id`,`name`,__op" {
+				t.Errorf("expected all columns to be loaded, got %s", columns)
+			}
+		})
+	}
+}
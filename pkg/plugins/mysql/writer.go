@@ -1,42 +1,33 @@
 package mysql
 
 import (
-	"bytes"
 	"context"
-	"database/sql/driver"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/imiskolee/anycdc/pkg/core"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/model"
 	"github.com/imiskolee/anycdc/pkg/plugins/common_sql"
 	"gorm.io/gorm"
-	"io"
-	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type ssExtra struct {
-	FEHost string `json:"fe_host"`
-	FEPort int    `json:"fe_port"`
-}
-
 type writer struct {
 	opt           *core.WriterOption
 	conn          *gorm.DB
 	schemaManager core.SchemaManager
 	Pipeline      *core.Pipeline
 	mutex         sync.Mutex
-}
-
-var httpClient *http.Client
-
-func init() {
-	httpClient = &http.Client{
-		Timeout: 120 * time.Second,
-	}
+	flushing      *core.Pipeline
+	// failed is the batch of flushing which failed, it's kept until the task retries or drops it.
+	failed *core.BatchError
+	// labels are the latest stream loads by source table.
+	labels sync.Map
+	// localInfileDisabled is set once the server refused LOAD DATA LOCAL INFILE.
+	localInfileDisabled atomic.Bool
+	// partialUpdateIgnored logs once that StarRocks transactions load all columns.
+	partialUpdateIgnored sync.Once
 }
 
 func NewWriter(ctx context.Context, opt interface{}) core.Writer {
@@ -55,7 +46,7 @@ func (w *writer) Prepare() error {
 
 	db, err := Connect(w.opt.Connector)
 	if err != nil {
-		w.opt.Logger.Error("can not prepare connector:%s,%s", w.opt.Connector, err)
+		w.opt.Logger.Error("can not prepare connector:%v,%s", w.opt.Connector, err)
 		return err
 	}
 	db.Logger = common_sql.NewLogger(w.opt.Logger)
@@ -65,31 +56,52 @@ func (w *writer) Prepare() error {
 }
 
 func (w *writer) Execute(e core.Event) error {
+	e = core.WithMetadata(w.opt.Task, e)
 	sch := w.schemaManager.Get(w.opt.Connector.Database, e.DestinationTableName)
 	if len(sch.Columns) < 1 {
 		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", e.DestinationTableName)
 		return nil
 	}
-	if e.Type == core.EventTypeUpdate {
+	isHistory := common_sql.TableHistory(w.opt.Task, e.SourceSchema.Name)
+	if e.Type == core.EventTypeUpdate && !isHistory {
 		e.Type = core.EventTypeInsert
 	}
-	if w.opt.Connector.Type == model.ConnectorTypeStarRocks {
-		if e.Type == core.EventTypeDelete {
-			return nil
-		}
+	if w.isStreamLoad() {
 		w.appendBatch(e)
 		if time.Now().Sub(w.Pipeline.CreatedAt) > 300*time.Second || w.Pipeline.Count > 50000 {
 			return w.processBatch()
 		}
 		return nil
 	}
+	if isHistory && !w.batchEnabled() {
+		return w.applyHistory(sch, []core.Event{e})
+	}
+	softDelete, isSoftDelete := common_sql.TableSoftDeleteColumn(w.opt.Task, e.SourceSchema.Name, sch)
+	if isSoftDelete && e.Type != core.EventTypeDelete {
+		e.Record = e.Record.ConvertRecord(sch)
+		e.Record.Set(softDelete.Name, common_sql.SoftDeleteReset(softDelete))
+	}
+	if w.batchEnabled() {
+		w.appendBatch(e)
+		if time.Now().Sub(w.Pipeline.CreatedAt) > common_sql.BatchFlushInterval || w.Pipeline.Count >= w.opt.Task.CDCBatchSize {
+			return w.processBatch()
+		}
+		return nil
+	}
 	e.Record = e.Record.ConvertRecord(sch)
 	sqlGenerator := common_sql.NewSQLGenerator(
 		w.opt.Connector,
 		sch,
 		dataTypes,
 	)
-	sql, params, err := sqlGenerator.DML(e)
+	var sql string
+	var params []interface{}
+	var err error
+	if isSoftDelete && e.Type == core.EventTypeDelete {
+		sql, params, err = sqlGenerator.SoftDelete(e, softDelete.Name, common_sql.SoftDeleteValue(softDelete))
+	} else {
+		sql, params, err = sqlGenerator.DML(e)
+	}
 	if err != nil {
 		return w.opt.Logger.Errorf("cannot generateDML: %v", err)
 	}
@@ -108,159 +120,246 @@ func (w *writer) ExecuteBatch(sourceSchema *schemas.Table, records []core.Event)
 		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", tableName)
 		return nil
 	}
+	for i := range records {
+		records[i] = core.WithMetadata(w.opt.Task, records[i])
+	}
+	isHistory := common_sql.TableHistory(w.opt.Task, sourceSchema.Name)
+	if isHistory && w.isStreamLoad() {
+		rows, err := w.closeHistory(w.conn, sch, records)
+		if err != nil {
+			return w.opt.Logger.Errorf("cannot close history versions on %s: %v", tableName, err)
+		}
+		records = rows
+	}
+	switch w.opt.Connector.Type {
+	case model.ConnectorTypeStarRocks:
+		return w.pushStarRocks(sch, records)
+	case model.ConnectorTypeDoris:
+		return w.pushDoris(sch, records)
+	}
+	if isHistory {
+		return w.applyHistory(sch, records)
+	}
 	convertedRecord := make([]core.EventRecord, len(records))
 	for i, record := range records {
 		convertedRecord[i] = record.Record.ConvertRecord(sch)
 	}
-
-	if w.opt.Connector.Type == model.ConnectorTypeStarRocks {
-		ret := w.pushStarRocks(sch, convertedRecord)
-		return ret
-	}
-	sql, params, err := batchUpsert(w.opt.Connector, sch, dataTypes, convertedRecord)
-	if err != nil {
-		return w.opt.Logger.Errorf("cannot generate batch SQL: %v", err)
+	if w.opt.Connector.Type == model.ConnectorTypeMySQL && !w.localInfileDisabled.Load() {
+		err := w.loadData(sch, convertedRecord)
+		if err == nil {
+			w.opt.Logger.Debug("Successfully loaded batch data,records = %d", len(convertedRecord))
+			return nil
+		}
+		if !errors.Is(err, errLocalInfileDisabled) {
+			return w.opt.Logger.Errorf("cannot load data: %v", err)
+		}
+		w.opt.Logger.Info("local_infile is disabled on %s, fallback to batch upsert", w.opt.Connector.Name)
+		w.localInfileDisabled.Store(true)
 	}
-	err = w.conn.Exec(sql, params...).Error
-	if err != nil {
-		return w.opt.Logger.Errorf("cannot execute: %v, sql=%s,vals=%+v", err, sql, params)
+	for _, chunk := range common_sql.SplitRecords(convertedRecord, len(sch.Columns)) {
+		sql, params, err := batchUpsert(w.opt.Connector, sch, dataTypes, chunk)
+		if err != nil {
+			return w.opt.Logger.Errorf("cannot generate batch SQL: %v", err)
+		}
+		err = w.conn.Exec(sql, params...).Error
+		if err != nil {
+			return w.opt.Logger.Errorf("cannot execute: %v, sql=%s,vals=%+v", err, sql, params)
+		}
 	}
 	w.opt.Logger.Debug("Successfully executed batch SQL,records = %d", len(convertedRecord))
 	return nil
 }
 
+// processBatch freezes the pipeline and commits it table by table, committed tables are removed. A table
+// which fails is returned as a core.BatchError, it's kept until the task retries or drops it.
 func (w *writer) processBatch() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	pipeline := w.Pipeline
-	for table, batch := range pipeline.Events {
-		w.opt.Logger.Error("Starting processBatch:%s %d", table, len(batch))
-		if err := w.ExecuteBatch(&batch[0].SourceSchema, batch); err != nil {
-			return err
+	if w.failed != nil {
+		return w.failed
+	}
+	if w.flushing == nil {
+		w.flushing = w.Pipeline
+		w.Pipeline = core.NewPipeline()
+	}
+	for table, batch := range w.flushing.Events {
+		w.opt.Logger.Info("Starting processBatch:%s %d", table, len(batch))
+		if err := w.flushTable(batch); err != nil {
+			w.failed = &core.BatchError{Table: table, Events: batch, Err: err}
+			return w.failed
 		}
+		w.flushing.Remove(table)
 	}
-	w.Pipeline = core.NewPipeline()
+	w.flushing = nil
 	return nil
 }
 
-func (w *writer) pushStarRocks(sch *schemas.Table, events []core.EventRecord) error {
-	w.opt.Logger.Error("Starting Push To SR, table name=%s", sch.Name)
-	var records []string
-	var jsonPaths []string
-	var columns []string
-	for _, col := range events[0].Columns {
-		jsonPaths = append(jsonPaths, fmt.Sprintf("\"$.`%s`\"", col.Name))
-		columns = append(columns, fmt.Sprintf("`%s`", col.Name))
+// RetryBatch commits the failed batch of a table again, the remaining tables are committed by the next flush.
+func (w *writer) RetryBatch(table string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failed == nil || w.failed.Table != table {
+		return nil
+	}
+	if err := w.flushTable(w.failed.Events); err != nil {
+		return err
 	}
+	w.flushing.Remove(table)
+	w.failed = nil
+	return nil
+}
+
+// DropBatch drops the failed batch of a table, the remaining tables are committed by the next flush.
+func (w *writer) DropBatch(table string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failed == nil || w.failed.Table != table {
+		return
+	}
+	w.flushing.Remove(table)
+	w.failed = nil
+}
 
-	for _, event := range events {
-		data := make(map[string]interface{})
-		for _, col := range sch.Columns {
-			var val interface{}
-			f, err := event.FieldByName(col.Name)
-			if err == nil {
-				val, err = dataTypes.Decode(f.Value)
+func (w *writer) flushTable(events []core.Event) error {
+	if w.isStreamLoad() {
+		return w.ExecuteBatch(&events[0].SourceSchema, events)
+	}
+	return w.applyBatch(events)
+}
+
+// applyBatch compacts cdc events by primary key, then deletes and upserts the rows in one transaction.
+// Events are appended by concurrent workers, they're sorted by their positions first.
+func (w *writer) applyBatch(events []core.Event) error {
+	comparer, _ := w.opt.Reader.(core.PositionComparer)
+	core.SortEvents(comparer, events)
+	tableName := events[0].DestinationTableName
+	sch := w.schemaManager.Get(w.opt.Connector.Database, tableName)
+	if len(sch.Columns) < 1 {
+		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", tableName)
+		return nil
+	}
+	if common_sql.TableHistory(w.opt.Task, events[0].SourceSchema.Name) {
+		return w.applyHistory(sch, events)
+	}
+	softDelete, isSoftDelete := common_sql.TableSoftDeleteColumn(w.opt.Task, events[0].SourceSchema.Name, sch)
+	var upserts, deletes []core.EventRecord
+	for _, e := range common_sql.CompactEvents(sch, events) {
+		if e.Type == core.EventTypeDelete {
+			deletes = append(deletes, e.Record.ConvertRecord(sch))
+		} else {
+			upserts = append(upserts, e.Record.ConvertRecord(sch))
+		}
+	}
+	err := w.conn.Transaction(func(tx *gorm.DB) error {
+		for _, records := range common_sql.SplitRecords(deletes, len(sch.GetPrimaryKeyNames())) {
+			sql, params, err := common_sql.BatchDelete(w.opt.Connector, sch, dataTypes, records)
+			if isSoftDelete {
+				sql, params, err = common_sql.BatchSoftDelete(w.opt.Connector, sch, dataTypes, records, softDelete.Name, common_sql.SoftDeleteValue(softDelete))
 			}
-			if val != nil {
-				if col.DataType == schemas.TypeTimestamp {
-					driverVal, ok := val.(driver.Valuer)
-					if ok {
-						val, _ = driverVal.Value()
-					}
-					if strings.Contains(fmt.Sprint(val), "0000-00-00") || fmt.Sprint(val) == "" {
-						if col.Nullable {
-							val = nil
-						} else {
-							val = "1970-01-01 00:00:00"
-						}
-					}
-				}
-				if col.DataType == schemas.TypeDate {
-					driverVal, ok := val.(driver.Valuer)
-					if ok {
-						val, _ = driverVal.Value()
-					}
-					if strings.Contains(fmt.Sprint(val), "0000-00-00") || fmt.Sprint(val) == "" {
-						if col.Nullable {
-							val = nil
-						} else {
-							val = "1970-01-01"
-						}
-					}
-				}
+			if err != nil {
+				return err
 			}
-
-			if val == nil {
-				if !col.Nullable {
-					switch col.DataType {
-					case schemas.TypeBool:
-						val = false
-					case schemas.TypeString:
-						val = ""
-					case schemas.TypeUint, schemas.TypeInt, schemas.TypeDecimal:
-						val = 0
-					case schemas.TypeJSON:
-						val = "{}"
-					case schemas.TypeTimestamp:
-						val = time.Unix(0, 0)
-					default:
-						val = ""
-					}
-				}
+			if err := tx.Exec(sql, params...).Error; err != nil {
+				return err
+			}
+		}
+		for _, records := range common_sql.SplitRecords(upserts, len(sch.Columns)) {
+			sql, params, err := batchUpsert(w.opt.Connector, sch, dataTypes, records)
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(sql, params...).Error; err != nil {
+				return err
 			}
-			data[fmt.Sprintf("`%s`", col.Name)] = val
 		}
-		jsonStr, _ := json.Marshal(data)
-		records = append(records, string(jsonStr))
+		return nil
+	})
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot apply batch on %s: %v", tableName, err)
 	}
-	converted := strings.Join(records, "\n")
+	w.opt.Logger.Debug("Successfully applied batch on %s, upserts = %d, deletes = %d", tableName, len(upserts), len(deletes))
+	return nil
+}
 
-	var respData struct {
-		Status  string `json:"Status"`
-		Message string `json:"Message"`
+// applyHistory closes the current versions of the keys in events and inserts every event as a new version
+// in one transaction, events are not compacted.
+func (w *writer) applyHistory(sch *schemas.Table, events []core.Event) error {
+	tableName := events[0].DestinationTableName
+	var count int
+	err := w.conn.Transaction(func(tx *gorm.DB) error {
+		rows, err := w.closeHistory(tx, sch, events)
+		if err != nil {
+			return err
+		}
+		records := make([]core.EventRecord, len(rows))
+		for i, row := range rows {
+			records[i] = row.Record.ConvertRecord(sch)
+		}
+		count = len(records)
+		for _, chunk := range common_sql.SplitRecords(records, len(sch.Columns)) {
+			sql, params, err := batchUpsert(w.opt.Connector, sch, dataTypes, chunk)
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(sql, params...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot apply history on %s: %v", tableName, err)
 	}
+	w.opt.Logger.Debug("Successfully applied history on %s, versions = %d", tableName, count)
+	return nil
+}
 
-	var ssE ssExtra
-	if err := json.Unmarshal([]byte(w.opt.Connector.Extra), &ssE); err != nil {
-		w.opt.Logger.Error("Unmarshal err: %v", err)
-		return err
+// closeHistory closes the current versions of the keys in events and returns their history rows.
+func (w *writer) closeHistory(db *gorm.DB, sch *schemas.Table, events []core.Event) ([]core.Event, error) {
+	rows := core.HistoryEvents(events)
+	sqls, params, err := common_sql.NewSQLGenerator(w.opt.Connector, sch, dataTypes).CloseHistory(events, rows)
+	if err != nil {
+		return nil, err
 	}
-	url := fmt.Sprintf("http://%s:%d/api/%s/%s/_stream_load",
-		ssE.FEHost,
-		ssE.FEPort,
-		w.opt.Connector.Database,
-		sch.Name,
-	)
-
-	request, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader([]byte(converted)))
-	request.SetBasicAuth(w.opt.Connector.Username, w.opt.Connector.Password)
-	request.Header.Set("format", "json")
-	request.Header.Set("jsonpaths", "["+strings.Join(jsonPaths, ",")+"]")
-	request.Header.Set("columns", strings.Join(columns, ","))
-	request.Header.Set("strict_mode", "true")
-	request.Header.Set("Expect", "100-continue")
-	request.Header.Set("ignore_json_size", "true")
+	for i, sql := range sqls {
+		if err := db.Exec(sql, params[i]...).Error; err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
 
-	resp, err := httpClient.Do(request)
-	if err != nil {
-		w.opt.Logger.Error("Can not push to sr:%s", err)
-		return err
+// Flush applies the buffered events, it's called by the task on a timer and before saving positions.
+func (w *writer) Flush() error {
+	w.mutex.Lock()
+	empty := w.Pipeline.Count == 0 && w.flushing == nil
+	resumed := w.flushing != nil
+	w.mutex.Unlock()
+	if empty {
+		return nil
 	}
-	content, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if err := json.Unmarshal(content, &respData); err != nil {
-		w.opt.Logger.Error("Can not push to sr:%s", err)
+	if err := w.processBatch(); err != nil {
+		return err
 	}
-	if resp.StatusCode != 200 || respData.Status != "Success" {
-		time.Sleep(60 * time.Second)
-		return w.opt.Logger.Errorf("Can not load data,%s,%s", sch.Name, string(content))
+	// the remaining tables of a former flush are committed first, then the events buffered since.
+	if resumed {
+		return w.processBatch()
 	}
-	w.opt.Logger.Error("Completed push to SR %s", sch.Name)
 	return nil
 }
 
 func (w *writer) appendBatch(event core.Event) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	w.Pipeline.Append("", event)
+	w.Pipeline.Append(event.LastPOS, event)
+}
+
+func (w *writer) isStreamLoad() bool {
+	return w.opt.Connector.Type == model.ConnectorTypeStarRocks || w.opt.Connector.Type == model.ConnectorTypeDoris
+}
+
+// batchEnabled reports whether cdc events are buffered and applied in batches of Task.CDCBatchSize,
+// it's opt-in so tasks apply events one by one by default.
+func (w *writer) batchEnabled() bool {
+	return w.opt.Task != nil && w.opt.Task.CDCBatchSize > 0
 }
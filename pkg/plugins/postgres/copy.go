@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pgUniqueViolation = "23505"
+
+var errNoPrimaryKey = errors.New("table has no primary key")
+
+// copyBatch loads dumper records with COPY FROM STDIN (binary format). The first batch of an empty table
+// is copied into the destination directly, the others into a temporary staging table which is merged
+// with INSERT ... SELECT ... ON CONFLICT. Batches are merged on the primary key, so tables without one
+// are rejected, a retried batch would duplicate their rows.
+func (w *writer) copyBatch(sch *schemas.Table, records []core.EventRecord) error {
+	if len(sch.GetPrimaryKeyNames()) < 1 {
+		return errNoPrimaryKey
+	}
+	var columns []schemas.Column
+	for _, col := range sch.Columns {
+		if _, err := records[0].FieldByName(col.Name); err == nil {
+			columns = append(columns, col)
+		}
+	}
+	rows := make([][]interface{}, len(records))
+	for i, record := range records {
+		row := make([]interface{}, len(columns))
+		for j, col := range columns {
+			f, err := record.FieldByName(col.Name)
+			if err != nil {
+				continue
+			}
+			val, err := dataTypes.Decode(f.Value)
+			if err != nil {
+				return err
+			}
+			if row[j], err = copyValue(col, val); err != nil {
+				return err
+			}
+		}
+		rows[i] = row
+	}
+	var names []string
+	for _, col := range columns {
+		names = append(names, col.Name)
+	}
+	ctx := context.Background()
+
+	empty, err := w.isEmptyTable(ctx, sch.Name)
+	if err != nil {
+		return err
+	}
+	if empty {
+		_, err := w.pool.CopyFrom(ctx, pgx.Identifier{sch.Name}, names, pgx.CopyFromRows(rows))
+		// the table has rows once a batch is copied, a retried batch may be copied already.
+		var pgErr *pgconn.PgError
+		if err != nil && (!errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation) {
+			return err
+		}
+		w.emptyTables.Store(sch.Name, false)
+		if err == nil {
+			return nil
+		}
+	}
+
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer (func() {
+		_ = tx.Rollback(ctx)
+	})()
+	stage := "_anycdc_stage_" + sch.Name
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP",
+		pgx.Identifier{stage}.Sanitize(), pgx.Identifier{sch.Name}.Sanitize())); err != nil {
+		return err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{stage}, names, pgx.CopyFromRows(rows)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, mergeSQL(sch, stage, names)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// isEmptyTable checks once if the table has no rows, the result is kept until a batch is copied into it.
+func (w *writer) isEmptyTable(ctx context.Context, table string) (bool, error) {
+	if empty, ok := w.emptyTables.Load(table); ok {
+		return empty.(bool), nil
+	}
+	var one int
+	err := w.pool.QueryRow(ctx, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", pgx.Identifier{table}.Sanitize())).Scan(&one)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+	empty := errors.Is(err, pgx.ErrNoRows)
+	w.emptyTables.Store(table, empty)
+	return empty, nil
+}
+
+func mergeSQL(sch *schemas.Table, stage string, names []string) string {
+	var columns, updateClause, pks []string
+	for _, name := range names {
+		columns = append(columns, fmt.Sprintf(`"%s"`, name))
+	}
+	for _, pk := range sch.GetPrimaryKeyNames() {
+		pks = append(pks, fmt.Sprintf(`"%s"`, pk))
+	}
+	for _, name := range names {
+		if f, ok := sch.GetFieldByName(name); ok && !f.IsPrimaryKey {
+			updateClause = append(updateClause, fmt.Sprintf(`"%s" = EXCLUDED."%s"`, name, name))
+		}
+	}
+	action := "DO NOTHING"
+	if len(updateClause) > 0 {
+		action = "DO UPDATE SET " + strings.Join(updateClause, ",")
+	}
+	return fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM %s ON CONFLICT (%s) %s`,
+		sch.Name,
+		strings.Join(columns, ","),
+		strings.Join(columns, ","),
+		pgx.Identifier{stage}.Sanitize(),
+		strings.Join(pks, ","),
+		action,
+	)
+}
+
+// copyValue converts decoded values which can not be encoded in binary format as they are.
+func copyValue(col schemas.Column, val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch col.DataType {
+	case schemas.TypeDecimal:
+		if col.SecondlyType == schemas.SecondlyTypeFloat || col.SecondlyType == schemas.SecondlyTypeReal {
+			return val, nil
+		}
+		s := fmt.Sprint(val)
+		if f, ok := val.(float64); ok {
+			s = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		var n pgtype.Numeric
+		err := n.Scan(s)
+		return n, err
+	case schemas.TypeUUID:
+		var u pgtype.UUID
+		err := u.Scan(fmt.Sprint(val))
+		return u, err
+	case schemas.TypeTime:
+		if t, ok := val.(time.Time); ok {
+			val = t.Format("15:04:05.999999")
+		}
+		var t pgtype.Time
+		err := t.Scan(fmt.Sprint(val))
+		return t, err
+	}
+	return val, nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/core/types"
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -13,7 +14,9 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	uuid "github.com/satori/go.uuid"
 	"log"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,6 +31,7 @@ type reader struct {
 	ctx             context.Context
 	cancel          context.CancelFunc
 	replication     *replication
+	tablesMutex     sync.Mutex
 	latestLSN       pglogrepl.LSN
 	latestRealLSN   pglogrepl.LSN
 	retries         int
@@ -35,6 +39,7 @@ type reader struct {
 	lastHeartBeatAt time.Time
 	lastEventAt     *time.Time
 	lastSaveAt      time.Time
+	commitTime      time.Time
 	lastCompletedAt time.Time
 	schemaManager   core.SchemaManager
 }
@@ -99,7 +104,7 @@ func (r *reader) Prepare() error {
 		return r.opt.Logger.Errorf("can not prepare reader initial extra: %v", err)
 	}
 	var tables []string
-	for _, v := range r.opt.Task.GetTables() {
+	for _, v := range r.opt.GetTables() {
 		tables = append(tables, v.SourceTable)
 	}
 	r.replication = &replication{
@@ -117,7 +122,10 @@ func (r *reader) Start() error {
 	defer (func() {
 		_ = r.Stop()
 	})()
-	if err := r.replication.syncPublication(); err != nil {
+	r.tablesMutex.Lock()
+	err := r.replication.syncPublication()
+	r.tablesMutex.Unlock()
+	if err != nil {
 		return r.opt.Logger.Errorf("can not prepare reader sync publication: %v", err)
 	}
 	if err := r.replication.syncSlot(); err != nil {
@@ -274,6 +282,8 @@ func (r *reader) handleXLogData(msg *pgproto3.CopyData) error {
 	var e core.Event
 
 	switch logicalMsg := logicalMsg.(type) {
+	case *pglogrepl.BeginMessage:
+		r.commitTime = logicalMsg.CommitTime
 	case *pglogrepl.RelationMessageV2:
 		r.relations[logicalMsg.RelationID] = *logicalMsg
 		break
@@ -294,18 +304,16 @@ func (r *reader) handleXLogData(msg *pgproto3.CopyData) error {
 		if err != nil {
 			return r.opt.Logger.Errorf("can not parse update message into event record %s", err)
 		}
-		oldData := newData
+		sch := r.schemaManager.Get(r.opt.Connector.Database, rel.RelationName)
 		if logicalMsg.OldTuple != nil {
-			oldData, err = r.convertToEventRecord(&rel, logicalMsg.UpdateMessage.OldTuple.Columns)
+			oldData, err := r.convertToEventRecord(&rel, logicalMsg.UpdateMessage.OldTuple.Columns)
 			if err != nil {
 				return r.opt.Logger.Errorf("can not parse update message into event record %s", err)
 			}
+			e.OldRecord = oldRecord(sch, logicalMsg.OldTupleType, oldData)
 		}
 		e.Type = core.EventTypeUpdate
 		e.Record = newData
-		e.OldRecord = new(core.EventRecord)
-		*e.OldRecord = oldData
-		sch := r.schemaManager.Get(r.opt.Connector.Database, rel.RelationName)
 		e.SourceSchema = *sch
 		break
 	case *pglogrepl.DeleteMessageV2:
@@ -321,6 +329,8 @@ func (r *reader) handleXLogData(msg *pgproto3.CopyData) error {
 		break
 	}
 	if e.Type != core.EventTypeUnknown {
+		e.LastPOS = (xld.WALStart + pglogrepl.LSN(len(xld.WALData))).String()
+		e.CommitTime = r.commitTime
 		if err := r.opt.Subscriber.ReaderEvent(e); err != nil {
 			return r.opt.Logger.Errorf("can not consume event %s", err)
 		}
@@ -357,6 +367,15 @@ func (r *reader) convertToEventRecord(rel *pglogrepl.RelationMessageV2, columns
 	return record, nil
 }
 
+// oldRecord returns the row before an update. It's only sent with replica identity full, or with the
+// key columns when the key changed, the other columns are nulls then and they're dropped.
+func oldRecord(sch *schemas.Table, tupleType uint8, old core.EventRecord) *core.EventRecord {
+	if tupleType == pglogrepl.UpdateMessageTupleTypeKey {
+		old = old.ConvertRecord(&schemas.Table{Columns: sch.GetPrimaryKeys()})
+	}
+	return &old
+}
+
 func (r *reader) LatestPosition() core.ReaderPosition {
 	lsn, err := r.replication.getLatestPosition()
 	if err != nil {
@@ -375,6 +394,42 @@ func (r *reader) CurrentPosition() core.ReaderPosition {
 	}
 }
 
+func (r *reader) ComparePosition(a string, b string) int {
+	la, _ := pglogrepl.ParseLSN(a)
+	lb, _ := pglogrepl.ParseLSN(b)
+	switch {
+	case la < lb:
+		return -1
+	case la > lb:
+		return 1
+	}
+	return 0
+}
+
+// PositionSequence returns the LSN of a position.
+func (r *reader) PositionSequence(pos string) (int64, bool) {
+	lsn, err := pglogrepl.ParseLSN(pos)
+	if err != nil {
+		return 0, false
+	}
+	return int64(lsn), true
+}
+
 func (r *reader) Release() error {
 	return r.replication.Release()
 }
+
+// AddTables adds tables to the publication, their changes are streamed once it's altered.
+func (r *reader) AddTables(tables []string) error {
+	r.tablesMutex.Lock()
+	defer r.tablesMutex.Unlock()
+	for _, table := range tables {
+		if !slices.Contains(r.replication.tables, table) {
+			r.replication.tables = append(r.replication.tables, table)
+		}
+	}
+	if err := r.replication.syncPublication(); err != nil {
+		return r.opt.Logger.Errorf("can not add tables %s to publication: %v", strings.Join(tables, ","), err)
+	}
+	return nil
+}
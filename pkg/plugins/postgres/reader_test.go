@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/core/schemas"
+	"github.com/imiskolee/anycdc/pkg/core/types"
+	"github.com/jackc/pglogrepl"
+	"testing"
+)
+
+func TestOldRecord(t *testing.T) {
+	sch := &schemas.Table{
+		Name: "users",
+		Columns: []schemas.Column{
+			{Name: "id", IsPrimaryKey: true},
+			{Name: "name"},
+		},
+	}
+	var old core.EventRecord
+	old.Set("id", types.NewTypedData(schemas.TypeInt, int64(1)))
+	old.Set("name", types.NewNullData())
+
+	key := oldRecord(sch, pglogrepl.UpdateMessageTupleTypeKey, old)
+	if len(key.Columns) != 1 || key.Columns[0].Name != "id" {
+		t.Fatalf("the old key should only have the key columns, got %+v", key.Columns)
+	}
+	full := oldRecord(sch, pglogrepl.UpdateMessageTupleTypeOld, old)
+	if len(full.Columns) != 2 {
+		t.Fatalf("the old row should have every column, got %+v", full.Columns)
+	}
+}
@@ -20,7 +20,7 @@ func newSchema(ctx context.Context, opt interface{}) core.SchemaManager {
 func (s Schema) Get(dbname string, tableName string) *schemas.Table {
 	conn, err := Connect(s.opt.Connector)
 	if err != nil {
-		s.opt.Logger.Error("can not connect to db:%s %v", s.opt.Connector, err)
+		s.opt.Logger.Error("can not connect to db:%s %v", s.opt.Connector.Name, err)
 		return nil
 	}
 
@@ -196,3 +196,18 @@ func getBuiltInType(oid uint32) (schemas.Type, schemas.SecondlyType) {
 	}
 	return schemas.TypeUnknown, schemas.SecondlyTypeUnknown
 }
+
+// ListTables lists the tables of the public schema.
+func (s Schema) ListTables(dbname string) ([]string, error) {
+	conn, err := Connect(s.opt.Connector)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	sql := `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = 'public' AND table_type = 'BASE TABLE' ORDER BY table_name`
+	if err := conn.Raw(sql).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
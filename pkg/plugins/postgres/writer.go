@@ -2,22 +2,34 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"github.com/imiskolee/anycdc/pkg/core"
 	"github.com/imiskolee/anycdc/pkg/core/schemas"
 	"github.com/imiskolee/anycdc/pkg/plugins/common_sql"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"gorm.io/gorm"
+	"sync"
+	"time"
 )
 
 type writer struct {
 	opt           *core.WriterOption
 	conn          *gorm.DB
+	pool          *pgxpool.Pool
+	emptyTables   sync.Map
 	schemaManager core.SchemaManager
+	Pipeline      *core.Pipeline
+	mutex         sync.Mutex
+	flushing      *core.Pipeline
+	// failed is the batch of flushing which failed, it's kept until the task retries or drops it.
+	failed *core.BatchError
 }
 
 func NewWriter(ctx context.Context, opts interface{}) core.Writer {
 	opt := opts.(*core.WriterOption)
 	return &writer{
-		opt: opt,
+		opt:      opt,
+		Pipeline: core.NewPipeline(),
 	}
 }
 
@@ -28,6 +40,11 @@ func (w *writer) Prepare() error {
 	}
 	w.conn = conn
 	w.conn.Logger = common_sql.NewLogger(w.opt.Logger)
+	pool, err := connectPGX(w.opt.Connector)
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot connect to postgres connector: %v", err)
+	}
+	w.pool = pool
 	w.schemaManager = core.NewCachedSchemaManager(newSchema(context.Background(), &core.SchemaOption{
 		Connector: w.opt.Connector,
 		Logger:    w.opt.Logger,
@@ -35,19 +52,50 @@ func (w *writer) Prepare() error {
 	return nil
 }
 
+// Release closes the connection pool of the writer, the gorm connection is shared by the connector.
+func (w *writer) Release() error {
+	if w.pool != nil {
+		w.pool.Close()
+	}
+	return nil
+}
+
 func (w *writer) Execute(e core.Event) error {
+	e = core.WithMetadata(w.opt.Task, e)
 	sch := w.schemaManager.Get(w.opt.Connector.Database, e.DestinationTableName)
 	if len(sch.Columns) < 1 {
 		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", e.DestinationTableName)
 		return nil
 	}
+	if common_sql.TableHistory(w.opt.Task, e.SourceSchema.Name) && !w.batchEnabled() {
+		return w.applyHistory(sch, []core.Event{e})
+	}
+	softDelete, isSoftDelete := common_sql.TableSoftDeleteColumn(w.opt.Task, e.SourceSchema.Name, sch)
+	if isSoftDelete && e.Type != core.EventTypeDelete {
+		e.Record = e.Record.ConvertRecord(sch)
+		e.Record.Set(softDelete.Name, common_sql.SoftDeleteReset(softDelete))
+	}
+	if w.batchEnabled() {
+		w.appendBatch(e)
+		if time.Now().Sub(w.Pipeline.CreatedAt) > common_sql.BatchFlushInterval || w.Pipeline.Count >= w.opt.Task.CDCBatchSize {
+			return w.processBatch()
+		}
+		return nil
+	}
 	e.Record = e.Record.ConvertRecord(sch)
 	sqlGenerator := common_sql.NewSQLGenerator(
 		w.opt.Connector,
 		sch,
 		dataTypes,
 	)
-	sql, params, err := sqlGenerator.DML(e)
+	var sql string
+	var params []interface{}
+	var err error
+	if isSoftDelete && e.Type == core.EventTypeDelete {
+		sql, params, err = sqlGenerator.SoftDelete(e, softDelete.Name, common_sql.SoftDeleteValue(softDelete))
+	} else {
+		sql, params, err = sqlGenerator.DML(e)
+	}
 	if err != nil {
 		return w.opt.Logger.Errorf("cannot generateDML: %v", err)
 	}
@@ -65,21 +113,234 @@ func (w *writer) ExecuteBatch(sourceSchema *schemas.Table, records []core.Event)
 		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", table)
 		return nil
 	}
+	for i := range records {
+		records[i] = core.WithMetadata(w.opt.Task, records[i])
+	}
+	if common_sql.TableHistory(w.opt.Task, sourceSchema.Name) {
+		return w.applyHistory(sch, records)
+	}
 	convertedRecord := make([]core.EventRecord, len(records))
 	for i, record := range records {
 		convertedRecord[i] = record.Record.ConvertRecord(sch)
 	}
-	sql, params, err := batchUpsert(sch, dataTypes, convertedRecord)
+	err := w.copyBatch(sch, convertedRecord)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errNoPrimaryKey) {
+		return w.opt.Logger.Errorf("cannot load batch into %s: %v", table, err)
+	}
+	w.opt.Logger.Error("cannot copy batch into %s, fallback to insert: %v", table, err)
+	for _, chunk := range common_sql.SplitRecords(convertedRecord, len(sch.Columns)) {
+		sql, params, err := batchUpsert(sch, dataTypes, chunk)
+		if err != nil {
+			return w.opt.Logger.Errorf("cannot generate batch SQL: %v", err)
+		}
+		err = w.conn.Exec(sql, params...).Error
+		if err != nil {
+			return w.opt.Logger.Errorf("cannot execute: %v", err)
+		}
+	}
+	return nil
+}
+
+// processBatch freezes the pipeline and applies it table by table, applied tables are removed. A table
+// which fails is returned as a core.BatchError, it's kept until the task retries or drops it.
+func (w *writer) processBatch() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failed != nil {
+		return w.failed
+	}
+	if w.flushing == nil {
+		w.flushing = w.Pipeline
+		w.Pipeline = core.NewPipeline()
+	}
+	for table, batch := range w.flushing.Events {
+		if err := w.applyBatch(batch); err != nil {
+			w.failed = &core.BatchError{Table: table, Events: batch, Err: err}
+			return w.failed
+		}
+		w.flushing.Remove(table)
+	}
+	w.flushing = nil
+	return nil
+}
+
+// RetryBatch applies the failed batch of a table again, the remaining tables are applied by the next flush.
+func (w *writer) RetryBatch(table string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failed == nil || w.failed.Table != table {
+		return nil
+	}
+	if err := w.applyBatch(w.failed.Events); err != nil {
+		return err
+	}
+	w.flushing.Remove(table)
+	w.failed = nil
+	return nil
+}
+
+// DropBatch drops the failed batch of a table, the remaining tables are applied by the next flush.
+func (w *writer) DropBatch(table string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.failed == nil || w.failed.Table != table {
+		return
+	}
+	w.flushing.Remove(table)
+	w.failed = nil
+}
+
+// applyBatch compacts cdc events by primary key, then deletes and upserts the rows in one transaction.
+// Events are appended by concurrent workers, they're sorted by their positions first.
+func (w *writer) applyBatch(events []core.Event) error {
+	comparer, _ := w.opt.Reader.(core.PositionComparer)
+	core.SortEvents(comparer, events)
+	table := events[0].DestinationTableName
+	sch := w.schemaManager.Get(w.opt.Connector.Database, table)
+	if len(sch.Columns) < 1 {
+		w.opt.Logger.Debug("Skipped event, table %s do not exists on the connector", table)
+		return nil
+	}
+	if common_sql.TableHistory(w.opt.Task, events[0].SourceSchema.Name) {
+		return w.applyHistory(sch, events)
+	}
+	softDelete, isSoftDelete := common_sql.TableSoftDeleteColumn(w.opt.Task, events[0].SourceSchema.Name, sch)
+	var upserts [][]core.EventRecord
+	var deletes []core.EventRecord
+	for _, e := range common_sql.CompactEvents(sch, events) {
+		record := e.Record.ConvertRecord(sch)
+		if e.Type == core.EventTypeDelete {
+			deletes = append(deletes, record)
+			continue
+		}
+		// a batch upsert takes the columns of its first record, records with other columns start a new one.
+		last := len(upserts) - 1
+		if last < 0 || !sameColumns(upserts[last][0], record) {
+			upserts = append(upserts, nil)
+			last++
+		}
+		upserts[last] = append(upserts[last], record)
+	}
+	err := w.conn.Transaction(func(tx *gorm.DB) error {
+		for _, records := range common_sql.SplitRecords(deletes, len(sch.GetPrimaryKeyNames())) {
+			sql, params, err := common_sql.BatchDelete(w.opt.Connector, sch, dataTypes, records)
+			if isSoftDelete {
+				sql, params, err = common_sql.BatchSoftDelete(w.opt.Connector, sch, dataTypes, records, softDelete.Name, common_sql.SoftDeleteValue(softDelete))
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(sql, params...).Error; err != nil {
+				return err
+			}
+		}
+		for _, group := range upserts {
+			for _, records := range common_sql.SplitRecords(group, len(group[0].Columns)) {
+				sql, params, err := batchUpsert(sch, dataTypes, records)
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(sql, params...).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return w.opt.Logger.Errorf("cannot generate batch SQL: %v", err)
+		return w.opt.Logger.Errorf("cannot apply batch on %s: %v", table, err)
 	}
-	err = w.conn.Exec(sql, params...).Error
+	w.opt.Logger.Debug("Successfully applied batch on %s, events = %d, deletes = %d", table, len(events), len(deletes))
+	return nil
+}
+
+// applyHistory closes the current versions of the keys in events and inserts every event as a new version
+// in one transaction, events are not compacted.
+func (w *writer) applyHistory(sch *schemas.Table, events []core.Event) error {
+	table := events[0].DestinationTableName
+	rows := core.HistoryEvents(events)
+	sqls, closeParams, err := common_sql.NewSQLGenerator(w.opt.Connector, sch, dataTypes).CloseHistory(events, rows)
 	if err != nil {
-		return w.opt.Logger.Errorf("cannot execute: %v", err)
+		return w.opt.Logger.Errorf("cannot generate history SQL on %s: %v", table, err)
+	}
+	var inserts [][]core.EventRecord
+	for _, row := range rows {
+		record := row.Record.ConvertRecord(sch)
+		last := len(inserts) - 1
+		if last < 0 || !sameColumns(inserts[last][0], record) {
+			inserts = append(inserts, nil)
+			last++
+		}
+		inserts[last] = append(inserts[last], record)
+	}
+	err = w.conn.Transaction(func(tx *gorm.DB) error {
+		for i, sql := range sqls {
+			if err := tx.Exec(sql, closeParams[i]...).Error; err != nil {
+				return err
+			}
+		}
+		for _, group := range inserts {
+			for _, records := range common_sql.SplitRecords(group, len(group[0].Columns)) {
+				sql, params, err := batchUpsert(sch, dataTypes, records)
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(sql, params...).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return w.opt.Logger.Errorf("cannot apply history on %s: %v", table, err)
+	}
+	w.opt.Logger.Debug("Successfully applied history on %s, versions = %d", table, len(rows))
+	return nil
+}
+
+// Flush applies the buffered events, it's called by the task on a timer and before saving positions.
+func (w *writer) Flush() error {
+	w.mutex.Lock()
+	empty := w.Pipeline.Count == 0 && w.flushing == nil
+	resumed := w.flushing != nil
+	w.mutex.Unlock()
+	if empty {
+		return nil
+	}
+	if err := w.processBatch(); err != nil {
+		return err
+	}
+	// the remaining tables of a former flush are committed first, then the events buffered since.
+	if resumed {
+		return w.processBatch()
 	}
 	return nil
 }
 
-func (w *writer) LatestFlushedPOS() string {
-	return ""
+func (w *writer) appendBatch(event core.Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.Pipeline.Append(event.LastPOS, event)
+}
+
+// batchEnabled reports whether cdc events are buffered and applied in batches of Task.CDCBatchSize,
+// it's opt-in so tasks apply events one by one by default.
+func (w *writer) batchEnabled() bool {
+	return w.opt.Task != nil && w.opt.Task.CDCBatchSize > 0
+}
+
+func sameColumns(a core.EventRecord, b core.EventRecord) bool {
+	if len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if a.Columns[i].Name != b.Columns[i].Name {
+			return false
+		}
+	}
+	return true
 }
@@ -13,7 +13,7 @@ func init() {
 	core.RegisterPlugin(pluginName, core.Plugin{
 		Name:             pluginName,
 		WriterFactory:    mysql.NewWriter,
-		SchemaFactory:    mysql.NewSchema,
+		SchemaFactory:    newSchema,
 		DumperFactory:    mysql.NewDumper,
 		ConnectorFactory: mysql.NewConnector,
 	})
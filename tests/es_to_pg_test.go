@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/google/uuid"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"github.com/imiskolee/anycdc/pkg/plugins/postgres"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestESToPG(t *testing.T) {
+	readerConnector, err := model.GetConnectorByName("test_es_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerConnector, err := model.GetConnectorByName("test_pg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{fmt.Sprintf("http://%s:%d", readerConnector.Host, readerConnector.Port)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerDB, err := postgres.Connect(writerConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := "es_documents"
+	_, _ = client.Indices.Delete([]string{index})
+	writerDB.Exec("DROP TABLE IF EXISTS es_documents")
+	for i := 0; i < 25; i++ {
+		doc, _ := json.Marshal(map[string]interface{}{
+			"name":       uuid.New().String(),
+			"age":        rand.Intn(100),
+			"created_at": time.Now().Format(time.RFC3339),
+		})
+		resp, err := client.Index(index, bytes.NewReader(doc), client.Index.WithRefresh("true"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	taskName := "test_es_to_pg"
+	tt, err := model.GetTaskByName(taskName)
+	if err == nil {
+		model.DB().Delete(tt)
+	}
+	task := model.Task{}
+	task.ID = uuid.New().String()
+	task.Name = taskName
+	task.Reader = readerConnector.ID
+	task.Writer = writerConnector.ID
+	task.Tables = index
+	task.BatchSize = 100
+	task.Status = model.TaskStatusActive
+	task.DumperEnabled = true
+	task.CDCEnabled = false
+	task.DebugEnabled = true
+	task.MigrateEnabled = true
+	model.DB().Create(&task)
+
+	coreTask := core.NewTask(task.ID)
+	if err := coreTask.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := coreTask.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = coreTask.Stop()
+	_ = coreTask.Release()
+
+	var c int64
+	writerDB.Table(index).Count(&c)
+	if c != 25 {
+		t.Fatalf("%s should be equal %d,%d", taskName, 25, c)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"github.com/imiskolee/anycdc/pkg/config"
 	"github.com/imiskolee/anycdc/pkg/model"
+	_ "github.com/imiskolee/anycdc/pkg/plugins/doris"
 	_ "github.com/imiskolee/anycdc/pkg/plugins/elasticsearch"
+	_ "github.com/imiskolee/anycdc/pkg/plugins/mongodb"
 	_ "github.com/imiskolee/anycdc/pkg/plugins/starrocks"
 	uuid "github.com/satori/go.uuid"
 )
@@ -65,6 +67,27 @@ var init_connectors = []model.Connector{
 		Username: "root",
 		Password: "",
 		Database: "anycdc_test",
+		Extra:    `{"fe_host":"127.0.0.1","fe_port":8030,"replication_num":1}`,
+	},
+	model.Connector{
+		Type:     "doris",
+		Name:     "test_doris_1",
+		Host:     "127.0.0.1",
+		Port:     39030,
+		Username: "root",
+		Password: "",
+		Database: "anycdc_test",
+		Extra:    `{"fe_host":"127.0.0.1","fe_port":18030,"replication_num":1}`,
+	},
+	model.Connector{
+		Type:     "mongodb",
+		Name:     "test_mongo_1",
+		Host:     "127.0.0.1",
+		Port:     27017,
+		Username: "",
+		Password: "",
+		Database: "anycdc_test",
+		Extra:    `{"replica_set":"rs0"}`,
 	},
 }
 
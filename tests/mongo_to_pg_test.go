@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"github.com/imiskolee/anycdc/pkg/plugins/mongodb"
+	"github.com/imiskolee/anycdc/pkg/plugins/postgres"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func generateRandomDocument() bson.D {
+	return bson.D{
+		{Key: "name", Value: uuid.New().String()},
+		{Key: "age", Value: rand.Int31n(100)},
+		{Key: "score", Value: rand.Float64()},
+		{Key: "active", Value: rand.Intn(2) == 1},
+		{Key: "created_at", Value: time.Now()},
+		{Key: "profile", Value: bson.D{{Key: "tags", Value: bson.A{"a", "b"}}}},
+	}
+}
+
+func TestMongoToPG(t *testing.T) {
+	readerConnector, err := model.GetConnectorByName("test_mongo_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerConnector, err := model.GetConnectorByName("test_pg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readerClient, err := mongodb.Connect(readerConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerDB, err := postgres.Connect(writerConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := readerClient.Database(readerConnector.Database).Collection("mongo_documents")
+	_ = collection.Drop(context.Background())
+	writerDB.Exec("DROP TABLE IF EXISTS mongo_documents")
+
+	taskName := "test_mongo_to_pg"
+	tt, err := model.GetTaskByName(taskName)
+	if err == nil {
+		model.DB().Delete(tt)
+	}
+	task := model.Task{}
+	task.ID = uuid.New().String()
+	task.Name = taskName
+	task.Reader = readerConnector.ID
+	task.Writer = writerConnector.ID
+	task.Tables = "mongo_documents"
+	task.BatchSize = 100
+	task.Status = model.TaskStatusActive
+	task.DumperEnabled = true
+	task.CDCEnabled = true
+	task.DebugEnabled = true
+	task.MigrateEnabled = true
+	model.DB().Create(&task)
+
+	for i := 0; i < 10; i++ {
+		if _, err := collection.InsertOne(context.Background(), generateRandomDocument()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coreTask := core.NewTask(task.ID)
+	if err := coreTask.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	go (func() {
+		coreTask.Start()
+	})()
+	time.Sleep(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		if _, err := collection.InsertOne(context.Background(), generateRandomDocument()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(2 * time.Second)
+	_ = coreTask.Stop()
+	time.Sleep(1 * time.Second)
+	_ = coreTask.Release()
+
+	c1, err := collection.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c2 int64
+	writerDB.Table("mongo_documents").Count(&c2)
+	if c1 < 1 || c2 != c1 {
+		t.Fatalf("%s should be equal %d,%d", taskName, c1, c2)
+	}
+	fmt.Println("Test = ", c1, c2)
+}
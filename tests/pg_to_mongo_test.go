@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/imiskolee/anycdc/pkg/core"
+	"github.com/imiskolee/anycdc/pkg/model"
+	"github.com/imiskolee/anycdc/pkg/plugins/mongodb"
+	"github.com/imiskolee/anycdc/pkg/plugins/postgres"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestPGToMongo(t *testing.T) {
+	readerConnector, err := model.GetConnectorByName("test_pg_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerConnector, err := model.GetConnectorByName("test_mongo_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readerDB, err := postgres.Connect(readerConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerClient, err := mongodb.Connect(writerConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = readerDB.AutoMigrate(&BasicType{})
+	readerDB.Exec("TRUNCATE TABLE basic_types")
+	collection := writerClient.Database(writerConnector.Database).Collection("basic_types")
+	_ = collection.Drop(context.Background())
+
+	taskName := "test_pg_to_mongo"
+	tt, err := model.GetTaskByName(taskName)
+	if err == nil {
+		model.DB().Delete(tt)
+	}
+	task := model.Task{}
+	task.ID = uuid.New().String()
+	task.Name = taskName
+	task.Reader = readerConnector.ID
+	task.Writer = writerConnector.ID
+	task.Tables = "basic_types"
+	task.BatchSize = 100
+	task.Status = model.TaskStatusActive
+	task.DumperEnabled = true
+	task.CDCEnabled = true
+	task.DebugEnabled = true
+	task.MigrateEnabled = false
+	model.DB().Create(&task)
+
+	for i := 0; i < 10; i++ {
+		data := GenerateRandomBasicType()
+		readerDB.Create(data)
+	}
+	coreTask := core.NewTask(task.ID)
+	if err := coreTask.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	go (func() {
+		coreTask.Start()
+	})()
+	time.Sleep(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		data := GenerateRandomBasicType()
+		readerDB.Create(data)
+	}
+	time.Sleep(2 * time.Second)
+	_ = coreTask.Stop()
+	time.Sleep(1 * time.Second)
+	_ = coreTask.Release()
+
+	var c1 int64
+	readerDB.Model(&BasicType{}).Count(&c1)
+	c2, err := collection.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 < 1 || c2 != c1 {
+		t.Fatalf("%s should be equal %d,%d", taskName, c1, c2)
+	}
+	fmt.Println("Test = ", c1, c2)
+}